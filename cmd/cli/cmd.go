@@ -6,10 +6,16 @@
 package cli
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strconv"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/docker/go-units"
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
 
@@ -156,12 +162,22 @@ func NewCommand() (*cobra.Command, *int) {
 			var dnsQuery []string
 			if config.ViperConfig.GetBool("dns-check") {
 				dnsQuery = config.ViperConfig.GetStringSlice("dns-queries")
+				if len(dnsQuery) == 0 {
+					dnsQuery = []string{fmt.Sprintf("coredns.kube-system.svc.%s.", env.GetClusterDomain())}
+				}
+			}
+			apiListeners, err := newAPIListeners(env)
+			if err != nil {
+				exitCode = 1
+				return
 			}
 			r, err := run.NewRunner(env, &run.Config{
 				RunTimeout:          config.ViperConfig.GetDuration("run-timeout"),
 				KubeletGCTimeout:    config.ViperConfig.GetDuration("gc"),
 				ReadinessDNSQueries: dnsQuery,
 				SkipProbes:          config.ViperConfig.GetBool("skip-probes"),
+				APIListeners:        apiListeners,
+				APIApplyRoot:        config.ViperConfig.GetString("api-apply-root"),
 			})
 			if err != nil {
 				exitCode = 2
@@ -208,6 +224,54 @@ func NewCommand() (*cobra.Command, *int) {
 		},
 	}
 
+	certsCommand := &cobra.Command{
+		SuggestFor: []string{"certificates", "secrets"},
+		Use:        "certs [directory]",
+		Short:      "List the certificates of the environment with their expiration",
+		Args:       cobra.ExactArgs(1), // basePathDirectory
+		Example: fmt.Sprintf(`
+# List the certificates:
+%s certs /opt/state/
+
+# Rotate the certificates of a running environment with the bearer token of --api-token-file:
+curl -XPOST -H "Authorization: Bearer $(cat /etc/pupernetes/token)" http://%s/certificates/rotate
+
+# Rotate them through the --api-socket:
+curl -XPOST --unix-socket /run/pupernetes.sock http://localhost/certificates/rotate
+
+# Rotate them through the --api-tls-address with the API client certificate:
+sudo curl -XPOST https://127.0.0.1:8990/certificates/rotate \
+  --cert /opt/state/secrets/pupernetes-api-client.certificate \
+  --key /opt/state/secrets/pupernetes-api-client.private_key \
+  --cacert /opt/state/secrets/pupernetes.certificate
+`,
+			daemonName,
+			config.ViperConfig.GetString("api-address"),
+		),
+		Run: func(cmd *cobra.Command, args []string) {
+			env, err := setup.NewConfigSetup(args[0])
+			if err != nil {
+				exitCode = 1
+				return
+			}
+			infos, err := env.ListCertificates()
+			if err != nil {
+				exitCode = 1
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSUBJECT\tISSUER\tSANS\tNOT AFTER\tEXPIRES IN")
+			for _, info := range infos {
+				sans := strings.Join(append(info.DNSNames, info.IPAddresses...), ",")
+				if sans == "" {
+					sans = "<none>"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", info.Name, info.Subject, info.Issuer, sans, info.NotAfter.Format(time.RFC3339), time.Until(info.NotAfter).Round(time.Minute))
+			}
+			w.Flush()
+		},
+	}
+
 	resetCommand := &cobra.Command{
 		SuggestFor: []string{"rest", "rst", "rset", "erase", "restart"},
 		Use:        "reset [namespaces ...]",
@@ -226,15 +290,37 @@ func NewCommand() (*cobra.Command, *int) {
 
 # Reset all namespaces and redeploy the initial setup:
 %s reset default $(kubectl get ns -o name) --apply
+
+# Reset the deployments and the custom resources labeled app=test:
+%s reset default --include deployments.apps,crontabs.stable.example.com -l app=test
+
+# Reset the default namespace but keep its secrets:
+%s reset default --exclude secrets
 `,
 			programName,
 			programName,
 			programName,
 			programName,
+			programName,
+			programName,
 		),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindAPIClientFlags(cmd)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
+			c, err := newAPIClient()
+			if err != nil {
+				exitCode = 1
+				return
+			}
+			opts := &api.ResetOptions{
+				Include:  config.ViperConfig.GetStringSlice("reset-include"),
+				Exclude:  config.ViperConfig.GetStringSlice("reset-exclude"),
+				Selector: config.ViperConfig.GetString("reset-selector"),
+				Timeout:  config.ViperConfig.GetDuration("reset-timeout"),
+			}
 			for i := 0; i < len(args); i++ {
-				err := api.ResetNamespace(config.ViperConfig.GetDuration("client-timeout"), config.ViperConfig.GetString("api-address"), args[i])
+				err := c.ResetNamespace(args[i], opts)
 				if err != nil {
 					exitCode = 2
 					return
@@ -243,11 +329,112 @@ func NewCommand() (*cobra.Command, *int) {
 			if !config.ViperConfig.GetBool("apply") {
 				return
 			}
-			err := api.Apply(config.ViperConfig.GetDuration("client-timeout"), config.ViperConfig.GetString("api-address"))
+			err = c.Apply()
+			if err != nil {
+				exitCode = 2
+				return
+			}
+		},
+	}
+
+	applyCommand := &cobra.Command{
+		Use:   "apply",
+		Short: fmt.Sprintf("Apply manifests with a running %s and display the result of each object", programName),
+		Args:  cobra.ExactArgs(0),
+		Example: fmt.Sprintf(`
+# Apply the manifests-api directory again:
+%s apply
+
+# Apply a multi-document YAML file:
+%s apply -f manifests.yaml
+
+# Server-side dry-run of the manifests read from stdin:
+cat manifests.yaml | %s apply -f - --dry-run
+
+# Apply a directory of the %s host, run with --api-apply-root /opt/tests, and prune the objects labeled app=test not in it:
+%s apply --path /opt/tests/manifests --prune-selector app=test
+`,
+			programName,
+			programName,
+			programName,
+			programName,
+			programName,
+		),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindAPIClientFlags(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			req := &api.ApplyRequest{
+				Path:          config.ViperConfig.GetString("apply-path"),
+				DryRun:        config.ViperConfig.GetBool("apply-dry-run"),
+				PruneSelector: config.ViperConfig.GetString("apply-prune-selector"),
+				Timeout:       config.ViperConfig.GetDuration("apply-timeout"),
+			}
+			var err error
+			switch filename := config.ViperConfig.GetString("apply-filename"); filename {
+			case "":
+			case "-":
+				req.Manifests, err = ioutil.ReadAll(os.Stdin)
+			default:
+				req.Manifests, err = api.ReadManifests(filename)
+			}
+			if err != nil {
+				glog.Errorf("Cannot read the manifests: %v", err)
+				exitCode = 1
+				return
+			}
+			c, err := newAPIClient()
+			if err != nil {
+				exitCode = 1
+				return
+			}
+			result, err := c.ApplyManifests(req)
+			if result != nil {
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tRESULT\tERROR")
+				for _, obj := range result.Objects {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", obj.Kind, obj.Namespace, obj.Name, obj.Result, strings.Replace(obj.Error, "\n", " ", -1))
+				}
+				w.Flush()
+			}
+			if err != nil {
+				exitCode = 2
+				return
+			}
+		},
+	}
+
+	statusCommand := &cobra.Command{
+		Use:   "status",
+		Short: fmt.Sprintf("Display the status of a running %s", programName),
+		Args:  cobra.ExactArgs(0),
+		Example: fmt.Sprintf(`
+# Display the phase, the systemd units and the readiness blockers:
+%s status
+`,
+			programName,
+		),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindAPIClientFlags(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			c, err := newAPIClient()
+			if err != nil {
+				exitCode = 1
+				return
+			}
+			status, err := c.GetStatus()
 			if err != nil {
 				exitCode = 2
 				return
 			}
+			b, err := json.MarshalIndent(status, "", "  ")
+			if err != nil {
+				glog.Errorf("Cannot marshal the status: %v", err)
+				exitCode = 1
+				return
+			}
+			fmt.Println(string(b))
 		},
 	}
 
@@ -263,10 +450,17 @@ func NewCommand() (*cobra.Command, *int) {
 
 # Wait until the p8s-kubelet.service systemd unit is running:
 %s wait -u p8s-kubelet
+
+# Wait until pupernetes is ready by consuming the events of its API:
+%s wait --events
 `,
 			programName,
 			programName,
+			programName,
 		),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindAPIClientFlags(cmd)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			unitToWatch := config.ViperConfig.GetString("unit-to-watch")
 			if unitToWatch == "" {
@@ -274,7 +468,16 @@ func NewCommand() (*cobra.Command, *int) {
 				exitCode = 1
 				return
 			}
-			err := wait.NewWaiter(unitToWatch, config.ViperConfig.GetDuration("wait-timeout"), config.ViperConfig.GetDuration("logging-since")).Wait()
+			var c *api.Client
+			if config.ViperConfig.GetBool("wait-events") {
+				var err error
+				c, err = newAPIClient()
+				if err != nil {
+					exitCode = 1
+					return
+				}
+			}
+			err := wait.NewWaiter(unitToWatch, c, config.ViperConfig.GetDuration("wait-timeout"), config.ViperConfig.GetDuration("logging-since")).Wait()
 			if err != nil {
 				exitCode = 2
 				return
@@ -282,13 +485,99 @@ func NewCommand() (*cobra.Command, *int) {
 		},
 	}
 
+	cacheCommand := &cobra.Command{
+		Use:   "cache command line",
+		Short: "Use this command to manage the download cache shared across the environments",
+	}
+
+	cacheListCommand := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the cached archives, the most recently used first",
+		Args:    cobra.ExactArgs(0),
+		Example: fmt.Sprintf(`
+# List the cached archives:
+%s cache list
+`,
+			programName,
+		),
+		Run: func(cmd *cobra.Command, args []string) {
+			c, err := setup.NewDownloadCache()
+			if err != nil {
+				exitCode = 1
+				return
+			}
+			if c == nil {
+				glog.Infof("The download cache is disabled")
+				return
+			}
+			entries, err := c.List()
+			if err != nil {
+				exitCode = 1
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "KEY\tSIZE\tLAST USED\tCHECKSUM\tURL")
+			for _, entry := range entries {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Key[:12], units.BytesSize(float64(entry.Size)), entry.LastUsed.Format(time.RFC3339), entry.Checksum, entry.URL)
+			}
+			w.Flush()
+		},
+	}
+
+	cachePruneCommand := &cobra.Command{
+		Use:   "prune",
+		Short: "Evict the least recently used archives until the cache fits in --cache-max-size",
+		Args:  cobra.ExactArgs(0),
+		Example: fmt.Sprintf(`
+# Evict the least recently used archives until the cache fits in 2GB:
+%s cache prune --cache-max-size 2GB
+
+# Empty the cache:
+%s cache prune --all
+`,
+			programName,
+			programName,
+		),
+		Run: func(cmd *cobra.Command, args []string) {
+			c, err := setup.NewDownloadCache()
+			if err != nil {
+				exitCode = 1
+				return
+			}
+			if c == nil {
+				glog.Infof("The download cache is disabled")
+				return
+			}
+			maxSize, err := units.FromHumanSize(config.ViperConfig.GetString("cache-max-size"))
+			if err != nil {
+				exitCode = 1
+				return
+			}
+			if config.ViperConfig.GetBool("cache-prune-all") {
+				maxSize = 0
+			}
+			err = c.Prune(maxSize)
+			if err != nil {
+				exitCode = 1
+				return
+			}
+		},
+	}
+
 	// root
 	rootCommand.PersistentFlags().IntVarP(&verbose, "verbose", "v", 2, "verbose level")
 
+	rootCommand.PersistentFlags().String("cache-dir", config.ViperConfig.GetString("cache-dir"), "directory of the download cache shared across the environments, empty disables it")
+	config.ViperConfig.BindPFlag("cache-dir", rootCommand.PersistentFlags().Lookup("cache-dir"))
+
+	rootCommand.PersistentFlags().String("cache-max-size", config.ViperConfig.GetString("cache-max-size"), "maximum size of the download cache, the least recently used archives are evicted")
+	config.ViperConfig.BindPFlag("cache-max-size", rootCommand.PersistentFlags().Lookup("cache-max-size"))
+
 	// daemon command
 	rootCommand.AddCommand(daemonCommand)
 
-	daemonCommand.PersistentFlags().String("containerd-version", config.ViperConfig.GetString("containerd-version"), "containerd version")
+	daemonCommand.PersistentFlags().String("containerd-version", config.ViperConfig.GetString("containerd-version"), "containerd version, 1.6.0 by default on arm64")
 	config.ViperConfig.BindPFlag("containerd-version", daemonCommand.PersistentFlags().Lookup("containerd-version"))
 
 	daemonCommand.PersistentFlags().String("hyperkube-version", config.ViperConfig.GetString("hyperkube-version"), "hyperkube version")
@@ -303,10 +592,79 @@ func NewCommand() (*cobra.Command, *int) {
 	daemonCommand.PersistentFlags().String("cni-version", config.ViperConfig.GetString("cni-version"), "container network interface (cni) version")
 	config.ViperConfig.BindPFlag("cni-version", daemonCommand.PersistentFlags().Lookup("cni-version"))
 
+	daemonCommand.PersistentFlags().String("hyperkube-checksum", config.ViperConfig.GetString("hyperkube-checksum"), "hyperkube archive digest as sha256:<hex>, sha512:<hex> or URL of a checksum file, default to the upstream checksum file")
+	config.ViperConfig.BindPFlag("hyperkube-checksum", daemonCommand.PersistentFlags().Lookup("hyperkube-checksum"))
+
+	daemonCommand.PersistentFlags().String("vault-checksum", config.ViperConfig.GetString("vault-checksum"), "vault archive digest as sha256:<hex>, sha512:<hex> or URL of a checksum file, default to the upstream checksum file")
+	config.ViperConfig.BindPFlag("vault-checksum", daemonCommand.PersistentFlags().Lookup("vault-checksum"))
+
+	daemonCommand.PersistentFlags().String("etcd-checksum", config.ViperConfig.GetString("etcd-checksum"), "etcd archive digest as sha256:<hex>, sha512:<hex> or URL of a checksum file, default to the upstream checksum file")
+	config.ViperConfig.BindPFlag("etcd-checksum", daemonCommand.PersistentFlags().Lookup("etcd-checksum"))
+
+	daemonCommand.PersistentFlags().String("cni-checksum", config.ViperConfig.GetString("cni-checksum"), "container network interface (cni) archive digest as sha256:<hex>, sha512:<hex> or URL of a checksum file, default to the upstream checksum file")
+	config.ViperConfig.BindPFlag("cni-checksum", daemonCommand.PersistentFlags().Lookup("cni-checksum"))
+
+	daemonCommand.PersistentFlags().String("containerd-checksum", config.ViperConfig.GetString("containerd-checksum"), "containerd archive digest as sha256:<hex>, sha512:<hex> or URL of a checksum file, default to the upstream checksum file")
+	config.ViperConfig.BindPFlag("containerd-checksum", daemonCommand.PersistentFlags().Lookup("containerd-checksum"))
+
+	daemonCommand.PersistentFlags().String("runc-checksum", config.ViperConfig.GetString("runc-checksum"), "runc archive digest as sha256:<hex>, sha512:<hex> or URL of a checksum file, default to the upstream checksum file")
+	config.ViperConfig.BindPFlag("runc-checksum", daemonCommand.PersistentFlags().Lookup("runc-checksum"))
+
+	daemonCommand.PersistentFlags().Bool("require-checksums", config.ViperConfig.GetBool("require-checksums"), "fail the setup when an archive has no digest: no pinned one, no upstream checksum file and no --<component>-checksum")
+	config.ViperConfig.BindPFlag("require-checksums", daemonCommand.PersistentFlags().Lookup("require-checksums"))
+
+	daemonCommand.PersistentFlags().String("arch", config.ViperConfig.GetString("arch"), "architecture of the downloaded binaries and images: amd64 or arm64, default to the one of pupernetes")
+	config.ViperConfig.BindPFlag("arch", daemonCommand.PersistentFlags().Lookup("arch"))
+
+	daemonCommand.PersistentFlags().String("mirror", config.ViperConfig.GetString("mirror"), "base URL of a mirror of the upstream archives like http://mirror.local/p8s or file:///srv/p8s, containing <upstream host>/<upstream path>")
+	config.ViperConfig.BindPFlag("mirror", daemonCommand.PersistentFlags().Lookup("mirror"))
+
+	daemonCommand.PersistentFlags().String("hyperkube-url", config.ViperConfig.GetString("hyperkube-url"), "hyperkube archive URL template overriding the upstream and the mirror, {{.Version}} and {{.Arch}} are available")
+	config.ViperConfig.BindPFlag("hyperkube-url", daemonCommand.PersistentFlags().Lookup("hyperkube-url"))
+
+	daemonCommand.PersistentFlags().String("vault-url", config.ViperConfig.GetString("vault-url"), "vault archive URL template overriding the upstream and the mirror, {{.Version}} and {{.Arch}} are available")
+	config.ViperConfig.BindPFlag("vault-url", daemonCommand.PersistentFlags().Lookup("vault-url"))
+
+	daemonCommand.PersistentFlags().String("etcd-url", config.ViperConfig.GetString("etcd-url"), "etcd archive URL template overriding the upstream and the mirror, {{.Version}} and {{.Arch}} are available")
+	config.ViperConfig.BindPFlag("etcd-url", daemonCommand.PersistentFlags().Lookup("etcd-url"))
+
+	daemonCommand.PersistentFlags().String("cni-url", config.ViperConfig.GetString("cni-url"), "container network interface (cni) archive URL template overriding the upstream and the mirror, {{.Version}} and {{.Arch}} are available")
+	config.ViperConfig.BindPFlag("cni-url", daemonCommand.PersistentFlags().Lookup("cni-url"))
+
+	daemonCommand.PersistentFlags().String("containerd-url", config.ViperConfig.GetString("containerd-url"), "containerd archive URL template overriding the upstream and the mirror, {{.Version}} and {{.Arch}} are available")
+	config.ViperConfig.BindPFlag("containerd-url", daemonCommand.PersistentFlags().Lookup("containerd-url"))
+
+	daemonCommand.PersistentFlags().String("runc-url", config.ViperConfig.GetString("runc-url"), "runc archive URL template overriding the upstream and the mirror, {{.Version}} and {{.Arch}} are available")
+	config.ViperConfig.BindPFlag("runc-url", daemonCommand.PersistentFlags().Lookup("runc-url"))
+
 	daemonCommand.PersistentFlags().String("download-timeout", config.ViperConfig.GetString("download-timeout"), "timeout for each downloaded archive")
 	config.ViperConfig.BindPFlag("download-timeout", daemonCommand.PersistentFlags().Lookup("download-timeout"))
 
-	daemonCommand.PersistentFlags().String("vault-listen-address", config.ViperConfig.GetString("vault-listen-address"), "vault listen address during setup stage")
+	daemonCommand.PersistentFlags().Int("download-retries", config.ViperConfig.GetInt("download-retries"), "number of retries after a failed download, each retry resumes the partial download")
+	config.ViperConfig.BindPFlag("download-retries", daemonCommand.PersistentFlags().Lookup("download-retries"))
+
+	daemonCommand.PersistentFlags().Duration("download-retry-delay", config.ViperConfig.GetDuration("download-retry-delay"), "delay before the first download retry, doubled on each retry")
+	config.ViperConfig.BindPFlag("download-retry-delay", daemonCommand.PersistentFlags().Lookup("download-retry-delay"))
+
+	daemonCommand.PersistentFlags().String("pki-backend", config.ViperConfig.GetString("pki-backend"), "certificate authority issuing the certificates: native or vault, vault is downloaded and started during setup stage")
+	config.ViperConfig.BindPFlag("pki-backend", daemonCommand.PersistentFlags().Lookup("pki-backend"))
+
+	daemonCommand.PersistentFlags().Duration("ca-ttl", config.ViperConfig.GetDuration("ca-ttl"), "validity of the generated root certificate authority")
+	config.ViperConfig.BindPFlag("ca-ttl", daemonCommand.PersistentFlags().Lookup("ca-ttl"))
+
+	daemonCommand.PersistentFlags().Duration("certificate-ttl", config.ViperConfig.GetDuration("certificate-ttl"), "validity of the certificates issued by the root certificate authority")
+	config.ViperConfig.BindPFlag("certificate-ttl", daemonCommand.PersistentFlags().Lookup("certificate-ttl"))
+
+	daemonCommand.PersistentFlags().String("ca-certificate", config.ViperConfig.GetString("ca-certificate"), "path to the PEM certificate of an existing root certificate authority signing the certificates instead of a generated one, with --ca-private-key")
+	config.ViperConfig.BindPFlag("ca-certificate", daemonCommand.PersistentFlags().Lookup("ca-certificate"))
+
+	daemonCommand.PersistentFlags().String("ca-private-key", config.ViperConfig.GetString("ca-private-key"), "path to the PEM private key of the root certificate authority given with --ca-certificate")
+	config.ViperConfig.BindPFlag("ca-private-key", daemonCommand.PersistentFlags().Lookup("ca-private-key"))
+
+	daemonCommand.PersistentFlags().StringSlice("extra-sans", config.ViperConfig.GetStringSlice("extra-sans"), "additional DNS names and IP addresses of the apiserver and etcd certificates, coma-separated values")
+	config.ViperConfig.BindPFlag("extra-sans", daemonCommand.PersistentFlags().Lookup("extra-sans"))
+
+	daemonCommand.PersistentFlags().String("vault-listen-address", config.ViperConfig.GetString("vault-listen-address"), "vault listen address during setup stage, with --pki-backend=vault")
 	config.ViperConfig.BindPFlag("vault-listen-address", daemonCommand.PersistentFlags().Lookup("vault-listen-address"))
 
 	daemonCommand.PersistentFlags().String("kubelet-root-dir", config.ViperConfig.GetString("kubelet-root-dir"), "directory path for managing kubelet files")
@@ -321,12 +679,66 @@ func NewCommand() (*cobra.Command, *int) {
 	daemonCommand.PersistentFlags().String("kubeconfig-path", config.ViperConfig.GetString("kubeconfig-path"), "path to the kubeconfig file")
 	config.ViperConfig.BindPFlag("kubeconfig-path", daemonCommand.PersistentFlags().Lookup("kubeconfig-path"))
 
-	daemonCommand.PersistentFlags().String("kubernetes-cluster-ip-range", config.ViperConfig.GetString("kubernetes-cluster-ip-range"), "kubernetes cluster CIDR")
+	daemonCommand.PersistentFlags().String("kubernetes-cluster-ip-range", config.ViperConfig.GetString("kubernetes-cluster-ip-range"), "kubernetes cluster CIDR, comma separated IPv4 and IPv6 CIDRs for dual-stack, the first one is the primary IP family, or auto to select a range free on the host")
 	config.ViperConfig.BindPFlag("kubernetes-cluster-ip-range", daemonCommand.PersistentFlags().Lookup("kubernetes-cluster-ip-range"))
 
-	daemonCommand.PersistentFlags().String("pod-ip-range", config.ViperConfig.GetString("pod-ip-range"), "pod common network interface CIDR")
+	daemonCommand.PersistentFlags().String("pod-ip-range", config.ViperConfig.GetString("pod-ip-range"), "pod common network interface CIDR, comma separated IPv4 and IPv6 CIDRs for dual-stack in the same IP family order as --kubernetes-cluster-ip-range, or auto to select a range free on the host")
 	config.ViperConfig.BindPFlag("pod-ip-range", daemonCommand.PersistentFlags().Lookup("pod-ip-range"))
 
+	daemonCommand.PersistentFlags().String("node-ip", config.ViperConfig.GetString("node-ip"), "IP address of the node, default to the address of --node-interface")
+	config.ViperConfig.BindPFlag("node-ip", daemonCommand.PersistentFlags().Lookup("node-ip"))
+
+	daemonCommand.PersistentFlags().String("node-interface", config.ViperConfig.GetString("node-interface"), "interface holding the IP address of the node, default to the interface of the default route in the routing table")
+	config.ViperConfig.BindPFlag("node-interface", daemonCommand.PersistentFlags().Lookup("node-interface"))
+
+	daemonCommand.PersistentFlags().StringSlice("upstream-nameservers", config.ViperConfig.GetStringSlice("upstream-nameservers"), "nameservers resolving the names outside of the cluster, default to the ones of the host and required without any, coma-separated values")
+	config.ViperConfig.BindPFlag("upstream-nameservers", daemonCommand.PersistentFlags().Lookup("upstream-nameservers"))
+
+	daemonCommand.PersistentFlags().String("cluster-domain", config.ViperConfig.GetString("cluster-domain"), "dns domain of the cluster, used by the kubelet, CoreDNS and the kube-apiserver certificate")
+	config.ViperConfig.BindPFlag("cluster-domain", daemonCommand.PersistentFlags().Lookup("cluster-domain"))
+
+	daemonCommand.PersistentFlags().StringSlice("dns-stub-domains", config.ViperConfig.GetStringSlice("dns-stub-domains"), "domains forwarded by CoreDNS to their own nameservers as domain=ip[:port], repeat a domain for several forwarders, coma-separated values")
+	config.ViperConfig.BindPFlag("dns-stub-domains", daemonCommand.PersistentFlags().Lookup("dns-stub-domains"))
+
+	daemonCommand.PersistentFlags().StringSlice("dns-hosts", config.ViperConfig.GetStringSlice("dns-hosts"), "static host entries resolved by CoreDNS as name=ip, coma-separated values")
+	config.ViperConfig.BindPFlag("dns-hosts", daemonCommand.PersistentFlags().Lookup("dns-hosts"))
+
+	daemonCommand.PersistentFlags().String("host-dns", config.ViperConfig.GetString("host-dns"), fmt.Sprintf("resolve the cluster domain from the host: %s routes it to CoreDNS on the bridge %s with systemd-resolved, %s adds CoreDNS to /etc/resolv.conf once ready, %s selects one of them or %s, undone by the clean option dns", config.HostDNSResolved, "cni-p8s", config.HostDNSResolvConf, config.HostDNSAuto, config.HostDNSNone))
+	config.ViperConfig.BindPFlag("host-dns", daemonCommand.PersistentFlags().Lookup("host-dns"))
+
+	daemonCommand.PersistentFlags().String("http-proxy", config.ViperConfig.GetString("http-proxy"), "proxy of the http downloads, containerd and the kubelet, default to the HTTP_PROXY environment variable")
+	config.ViperConfig.BindPFlag("http-proxy", daemonCommand.PersistentFlags().Lookup("http-proxy"))
+
+	daemonCommand.PersistentFlags().String("https-proxy", config.ViperConfig.GetString("https-proxy"), "proxy of the https downloads, containerd and the kubelet, default to the HTTPS_PROXY environment variable")
+	config.ViperConfig.BindPFlag("https-proxy", daemonCommand.PersistentFlags().Lookup("https-proxy"))
+
+	daemonCommand.PersistentFlags().StringSlice("no-proxy", config.ViperConfig.GetStringSlice("no-proxy"), "hosts, domains and CIDRs reached without proxy, default to the NO_PROXY environment variable, the cluster ranges, the node IP and the cluster domain are added for containerd and the kubelet, coma-separated values")
+	config.ViperConfig.BindPFlag("no-proxy", daemonCommand.PersistentFlags().Lookup("no-proxy"))
+
+	daemonCommand.PersistentFlags().String("hostname-override", config.ViperConfig.GetString("hostname-override"), fmt.Sprintf("hostname of the node, used by the hostname provider %s", config.HostnameProviderOverride))
+	config.ViperConfig.BindPFlag("hostname-override", daemonCommand.PersistentFlags().Lookup("hostname-override"))
+
+	daemonCommand.PersistentFlags().StringSlice("hostname-providers", config.ViperConfig.GetStringSlice("hostname-providers"), "ordered hostname providers, the first valid and resolvable hostname is used, coma-separated values")
+	config.ViperConfig.BindPFlag("hostname-providers", daemonCommand.PersistentFlags().Lookup("hostname-providers"))
+
+	daemonCommand.PersistentFlags().String("aws-metadata-endpoint", config.ViperConfig.GetString("aws-metadata-endpoint"), fmt.Sprintf("AWS instance metadata endpoint of the hostname provider %s", config.HostnameProviderAWS))
+	config.ViperConfig.BindPFlag("aws-metadata-endpoint", daemonCommand.PersistentFlags().Lookup("aws-metadata-endpoint"))
+
+	daemonCommand.PersistentFlags().String("gce-metadata-endpoint", config.ViperConfig.GetString("gce-metadata-endpoint"), fmt.Sprintf("GCE instance metadata endpoint of the hostname provider %s", config.HostnameProviderGCE))
+	config.ViperConfig.BindPFlag("gce-metadata-endpoint", daemonCommand.PersistentFlags().Lookup("gce-metadata-endpoint"))
+
+	daemonCommand.PersistentFlags().String("azure-metadata-endpoint", config.ViperConfig.GetString("azure-metadata-endpoint"), fmt.Sprintf("Azure instance metadata service (IMDS) endpoint of the hostname provider %s", config.HostnameProviderAzure))
+	config.ViperConfig.BindPFlag("azure-metadata-endpoint", daemonCommand.PersistentFlags().Lookup("azure-metadata-endpoint"))
+
+	daemonCommand.PersistentFlags().String("cni-plugin", config.ViperConfig.GetString("cni-plugin"), fmt.Sprintf("container network interface (cni) plugin of the pods: %s, %s or %s chaining %s with portmap, bandwidth and firewall", config.CNIBridge, config.CNIPtp, config.CNIChained, config.CNIBridge))
+	config.ViperConfig.BindPFlag("cni-plugin", daemonCommand.PersistentFlags().Lookup("cni-plugin"))
+
+	daemonCommand.PersistentFlags().String("cni-conflist", config.ViperConfig.GetString("cni-conflist"), "path to a container network interface (cni) conflist file used as is instead of --cni-plugin, its ranges should match --pod-ip-range")
+	config.ViperConfig.BindPFlag("cni-conflist", daemonCommand.PersistentFlags().Lookup("cni-conflist"))
+
+	daemonCommand.PersistentFlags().String("kernel-preflight", config.ViperConfig.GetString("kernel-preflight"), fmt.Sprintf("kernel modules and sysctls of the pods during the setup: %s to change and record them in the state directory, restored by the clean option kernel, %s to fail if missing or %s", config.KernelPreflightApply, config.KernelPreflightCheck, config.KernelPreflightNone))
+	config.ViperConfig.BindPFlag("kernel-preflight", daemonCommand.PersistentFlags().Lookup("kernel-preflight"))
+
 	daemonCommand.PersistentFlags().String("container-runtime", config.ViperConfig.GetString("container-runtime"), `container runtime interface to use (experimental: "containerd")`)
 	config.ViperConfig.BindPFlag("container-runtime", daemonCommand.PersistentFlags().Lookup("container-runtime"))
 
@@ -348,6 +760,8 @@ func NewCommand() (*cobra.Command, *int) {
 	// run
 	daemonCommand.AddCommand(runCommand)
 
+	daemonCommand.AddCommand(certsCommand)
+
 	runCommand.PersistentFlags().StringP("drain", "d", config.ViperConfig.GetString("drain"), fmt.Sprintf("drain options after %s: %s", runCommand.Name(), options.GetOptionsString(options.Drain{})))
 	config.ViperConfig.BindPFlag("drain", runCommand.PersistentFlags().Lookup("drain"))
 
@@ -360,13 +774,28 @@ func NewCommand() (*cobra.Command, *int) {
 	runCommand.PersistentFlags().String("bind-address", config.ViperConfig.GetString("bind-address"), fmt.Sprintf("bind address for %s API ip:port", programName))
 	config.ViperConfig.BindPFlag("bind-address", runCommand.PersistentFlags().Lookup("bind-address"))
 
+	runCommand.PersistentFlags().String("api-socket", config.ViperConfig.GetString("api-socket"), fmt.Sprintf("unix socket for %s API, disabled if empty", programName))
+	config.ViperConfig.BindPFlag("api-socket", runCommand.PersistentFlags().Lookup("api-socket"))
+
+	runCommand.PersistentFlags().String("api-socket-mode", config.ViperConfig.GetString("api-socket-mode"), "file mode of the --api-socket, in octal")
+	config.ViperConfig.BindPFlag("api-socket-mode", runCommand.PersistentFlags().Lookup("api-socket-mode"))
+
+	runCommand.PersistentFlags().String("api-tls-address", config.ViperConfig.GetString("api-tls-address"), fmt.Sprintf("HTTPS address for %s API ip:port requiring client certificates issued by the CA, disabled if empty", programName))
+	config.ViperConfig.BindPFlag("api-tls-address", runCommand.PersistentFlags().Lookup("api-tls-address"))
+
+	runCommand.PersistentFlags().String("api-token-file", config.ViperConfig.GetString("api-token-file"), "file containing the bearer token required on --bind-address, except for /ready and /metrics, mandatory outside the loopback")
+	config.ViperConfig.BindPFlag("api-token-file", runCommand.PersistentFlags().Lookup("api-token-file"))
+
+	runCommand.PersistentFlags().String("api-apply-root", config.ViperConfig.GetString("api-apply-root"), "directory of the host the path parameter of the apply can read in, in addition to the manifests-api directory")
+	config.ViperConfig.BindPFlag("api-apply-root", runCommand.PersistentFlags().Lookup("api-apply-root"))
+
 	runCommand.PersistentFlags().String("systemd-job-name", config.ViperConfig.GetString("systemd-job-name"), "unit name used when running as systemd service")
 	config.ViperConfig.BindPFlag("systemd-job-name", runCommand.PersistentFlags().Lookup("systemd-job-name"))
 
 	runCommand.PersistentFlags().String(config.JobTypeKey, config.ViperConfig.GetString(config.JobTypeKey), fmt.Sprintf("type of job: %s or %s", config.JobForeground, config.JobSystemd))
 	config.ViperConfig.BindPFlag(config.JobTypeKey, runCommand.PersistentFlags().Lookup(config.JobTypeKey))
 
-	runCommand.PersistentFlags().StringSlice("dns-queries", config.ViperConfig.GetStringSlice("dns-queries"), "dns queries for readiness, coma-separated values")
+	runCommand.PersistentFlags().StringSlice("dns-queries", config.ViperConfig.GetStringSlice("dns-queries"), "dns queries for readiness, default to the coredns service in --cluster-domain, coma-separated values")
 	config.ViperConfig.BindPFlag("dns-queries", runCommand.PersistentFlags().Lookup("dns-queries"))
 
 	runCommand.PersistentFlags().Bool("dns-check", config.ViperConfig.GetBool("dns-check"), "needed dns queries to notify readiness")
@@ -377,14 +806,57 @@ func NewCommand() (*cobra.Command, *int) {
 
 	// Reset
 	rootCommand.AddCommand(resetCommand)
-	resetCommand.PersistentFlags().String("api-address", config.ViperConfig.GetString("api-address"), fmt.Sprintf("address for the %s API ip:port", programName))
-	config.ViperConfig.BindPFlag("api-address", resetCommand.PersistentFlags().Lookup("api-address"))
+	addAPIClientFlags(resetCommand)
 
 	resetCommand.PersistentFlags().BoolP("apply", "a", config.ViperConfig.GetBool("apply"), "apply manifests-api after reset, useful when resetting kube-system namespace")
 	config.ViperConfig.BindPFlag("apply", resetCommand.PersistentFlags().Lookup("apply"))
 
-	resetCommand.PersistentFlags().Duration("client-timeout", config.ViperConfig.GetDuration("client-timeout"), fmt.Sprintf("maximum time waited for a %s command to be executed", programName))
-	config.ViperConfig.BindPFlag("client-timeout", resetCommand.PersistentFlags().Lookup("client-timeout"))
+	resetCommand.PersistentFlags().Duration("client-timeout", config.ViperConfig.GetDuration("client-timeout"), fmt.Sprintf("maximum time waited for a %s command to be executed, in addition to --timeout", programName))
+
+	resetCommand.PersistentFlags().StringSlice("include", config.ViperConfig.GetStringSlice("reset-include"), "only reset these resources, like pods,deployments.apps or a custom resource, default to every namespaced resource")
+	config.ViperConfig.BindPFlag("reset-include", resetCommand.PersistentFlags().Lookup("include"))
+
+	resetCommand.PersistentFlags().StringSlice("exclude", config.ViperConfig.GetStringSlice("reset-exclude"), "keep these resources, like secrets,serviceaccounts")
+	config.ViperConfig.BindPFlag("reset-exclude", resetCommand.PersistentFlags().Lookup("exclude"))
+
+	resetCommand.PersistentFlags().StringP("selector", "l", config.ViperConfig.GetString("reset-selector"), "only reset the objects matching this label selector")
+	config.ViperConfig.BindPFlag("reset-selector", resetCommand.PersistentFlags().Lookup("selector"))
+
+	resetCommand.PersistentFlags().Duration("timeout", config.ViperConfig.GetDuration("reset-timeout"), "maximum time waited for the deleted objects to be gone, at most 5m")
+	config.ViperConfig.BindPFlag("reset-timeout", resetCommand.PersistentFlags().Lookup("timeout"))
+
+	// Apply
+	rootCommand.AddCommand(applyCommand)
+	addAPIClientFlags(applyCommand)
+	applyCommand.PersistentFlags().Duration("client-timeout", config.ViperConfig.GetDuration("client-timeout"), fmt.Sprintf("maximum time waited for a %s command to be executed, in addition to --timeout", programName))
+
+	applyCommand.PersistentFlags().StringP("filename", "f", config.ViperConfig.GetString("apply-filename"), "YAML or JSON file or directory of manifests to apply, - for stdin, default to the manifests-api directory")
+	config.ViperConfig.BindPFlag("apply-filename", applyCommand.PersistentFlags().Lookup("filename"))
+
+	applyCommand.PersistentFlags().String("path", config.ViperConfig.GetString("apply-path"), fmt.Sprintf("absolute path of a file or a directory of manifests on the %s host, in the manifests-api directory or the --api-apply-root of the run", programName))
+	config.ViperConfig.BindPFlag("apply-path", applyCommand.PersistentFlags().Lookup("path"))
+
+	applyCommand.PersistentFlags().Bool("dry-run", config.ViperConfig.GetBool("apply-dry-run"), "server-side dry-run, requires Kubernetes 1.13 or later")
+	config.ViperConfig.BindPFlag("apply-dry-run", applyCommand.PersistentFlags().Lookup("dry-run"))
+
+	applyCommand.PersistentFlags().String("prune-selector", config.ViperConfig.GetString("apply-prune-selector"), "prune the objects matching this label selector and not in the manifests")
+	config.ViperConfig.BindPFlag("apply-prune-selector", applyCommand.PersistentFlags().Lookup("prune-selector"))
+
+	applyCommand.PersistentFlags().Duration("timeout", config.ViperConfig.GetDuration("apply-timeout"), "maximum time of the apply, at most 5m")
+	config.ViperConfig.BindPFlag("apply-timeout", applyCommand.PersistentFlags().Lookup("timeout"))
+
+	// Status
+	rootCommand.AddCommand(statusCommand)
+	addAPIClientFlags(statusCommand)
+	statusCommand.PersistentFlags().Duration("client-timeout", config.ViperConfig.GetDuration("client-timeout"), fmt.Sprintf("maximum time waited for a %s command to be executed", programName))
+
+	// Cache
+	rootCommand.AddCommand(cacheCommand)
+	cacheCommand.AddCommand(cacheListCommand)
+	cacheCommand.AddCommand(cachePruneCommand)
+
+	cachePruneCommand.PersistentFlags().Bool("all", config.ViperConfig.GetBool("cache-prune-all"), "evict all the archives")
+	config.ViperConfig.BindPFlag("cache-prune-all", cachePruneCommand.PersistentFlags().Lookup("all"))
 
 	// Wait
 	rootCommand.AddCommand(waitCommand)
@@ -398,5 +870,67 @@ func NewCommand() (*cobra.Command, *int) {
 	waitCommand.PersistentFlags().StringP("unit-to-watch", "u", config.ViperConfig.GetString("unit-to-watch"), "systemd unit name to watch")
 	config.ViperConfig.BindPFlag("unit-to-watch", waitCommand.PersistentFlags().Lookup("unit-to-watch"))
 
+	waitCommand.PersistentFlags().Bool("events", config.ViperConfig.GetBool("wait-events"), fmt.Sprintf("consume the events of the %s API until the ready or the stopped event instead of polling the systemd unit", programName))
+	config.ViperConfig.BindPFlag("wait-events", waitCommand.PersistentFlags().Lookup("events"))
+
+	addAPIClientFlags(waitCommand)
+
 	return rootCommand, &exitCode
 }
+
+// apiClientKeys are the flags of the commands calling the API
+var apiClientKeys = []string{"api-address", "client-timeout", "api-token-file", "api-tls-certificate", "api-tls-private-key", "api-tls-ca"}
+
+// addAPIClientFlags declares the flags to reach the API,
+// they are bound in the PreRun of each command as the commands share the same keys
+func addAPIClientFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("api-address", config.ViperConfig.GetString("api-address"), fmt.Sprintf("address for the %s API: ip:port, https://ip:port or unix:///path/to/socket", programName))
+	cmd.PersistentFlags().String("api-token-file", config.ViperConfig.GetString("api-token-file"), fmt.Sprintf("file containing the bearer token of the %s API", programName))
+	cmd.PersistentFlags().String("api-tls-certificate", config.ViperConfig.GetString("api-tls-certificate"), "client certificate used with an https:// --api-address")
+	cmd.PersistentFlags().String("api-tls-private-key", config.ViperConfig.GetString("api-tls-private-key"), "private key of the --api-tls-certificate")
+	cmd.PersistentFlags().String("api-tls-ca", config.ViperConfig.GetString("api-tls-ca"), "CA verifying the certificate of the API used with an https:// --api-address")
+}
+
+func bindAPIClientFlags(cmd *cobra.Command) {
+	for _, key := range apiClientKeys {
+		f := cmd.PersistentFlags().Lookup(key)
+		if f != nil {
+			config.ViperConfig.BindPFlag(key, f)
+		}
+	}
+}
+
+func newAPIClient() (*api.Client, error) {
+	return api.NewClient(&api.ClientConfig{
+		Address:         config.ViperConfig.GetString("api-address"),
+		Timeout:         config.ViperConfig.GetDuration("client-timeout"),
+		TokenFile:       config.ViperConfig.GetString("api-token-file"),
+		CertificatePath: config.ViperConfig.GetString("api-tls-certificate"),
+		PrivateKeyPath:  config.ViperConfig.GetString("api-tls-private-key"),
+		CAPath:          config.ViperConfig.GetString("api-tls-ca"),
+	})
+}
+
+// newAPIListeners returns the listeners of the API, the TLS listener uses the certificates of the environment
+func newAPIListeners(env *setup.Environment) (*api.Listeners, error) {
+	listeners := &api.Listeners{
+		Address:    config.ViperConfig.GetString("bind-address"),
+		SocketPath: config.ViperConfig.GetString("api-socket"),
+		TLSAddress: config.ViperConfig.GetString("api-tls-address"),
+	}
+	mode, err := strconv.ParseUint(config.ViperConfig.GetString("api-socket-mode"), 8, 32)
+	if err != nil {
+		glog.Errorf("Invalid --api-socket-mode %q: %v", config.ViperConfig.GetString("api-socket-mode"), err)
+		return nil, err
+	}
+	listeners.SocketMode = os.FileMode(mode)
+	listeners.TLSCertificatePath, listeners.TLSPrivateKeyPath, listeners.TLSClientCAPath = env.GetAPICertificatePaths()
+	tokenFile := config.ViperConfig.GetString("api-token-file")
+	if tokenFile != "" {
+		listeners.Token, err = api.ReadTokenFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return listeners, nil
+}
@@ -6,63 +6,86 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
 	// Register pprof handlers with its package init
 	_ "net/http/pprof"
 	"os"
 	"syscall"
-	"time"
 
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 	corev1 "k8s.io/api/core/v1"
 
-	"github.com/DataDog/pupernetes/pkg/config"
+	"github.com/DataDog/pupernetes/pkg/pki"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
-	stopRoute  = "/stop"
-	applyRoute = "/apply"
-	resetRoute = "/reset"
+	stopRoute         = "/stop"
+	applyRoute        = "/apply"
+	resetRoute        = "/reset"
+	certificatesRoute = "/certificates"
+	rotateRoute       = certificatesRoute + "/rotate"
 )
 
 // HandlerAPI handles the API calls
 type HandlerAPI struct {
 	sigChan        chan os.Signal
-	resetNamespace func(namespaces *corev1.NamespaceList) error
+	resetNamespace func(namespaces *corev1.NamespaceList, opts *ResetOptions) error
 	isReady        func() bool
-	apply          chan struct{}
+	status         func() *Status
+	events         *EventBus
+	apply          func(req *ApplyRequest) (*ApplyResult, error)
+
+	listCertificates   func() ([]pki.Info, error)
+	rotateCertificates func() ([]pki.Info, error)
 }
 
 func (h *HandlerAPI) stopHandler(_ http.ResponseWriter, _ *http.Request) {
 	h.sigChan <- syscall.SIGTERM
 }
 
-func (h *HandlerAPI) applyHandler(_ http.ResponseWriter, _ *http.Request) {
-	h.apply <- struct{}{}
+func writeCertificates(w http.ResponseWriter, infos []pki.Info, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	b, err := json.Marshal(infos)
+	if err != nil {
+		glog.Errorf("Cannot marshal the certificates: %v", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(b)
+}
+
+func (h *HandlerAPI) certificatesHandler(w http.ResponseWriter, _ *http.Request) {
+	infos, err := h.listCertificates()
+	writeCertificates(w, infos, err)
 }
 
-func (h *HandlerAPI) resetHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	namespaceName, ok := vars["namespace"]
-	if !ok || namespaceName == "" {
-		glog.Warningf("Invalid namespace %v", vars)
-		http.NotFound(w, r)
-		return
+func (h *HandlerAPI) rotateHandler(w http.ResponseWriter, _ *http.Request) {
+	glog.Infof("Rotating the certificates ...")
+	infos, err := h.rotateCertificates()
+	if err != nil {
+		glog.Errorf("Cannot rotate the certificates: %v", err)
 	}
-	namespaceItem := corev1.Namespace{}
-	namespaceItem.Name = namespaceName
-	glog.Infof("Resetting namespace %q ...", namespaceItem.Name)
-	err := h.resetNamespace(&corev1.NamespaceList{
-		Items: []corev1.Namespace{namespaceItem},
-	})
+	writeCertificates(w, infos, err)
+}
+
+func (h *HandlerAPI) statusHandler(w http.ResponseWriter, _ *http.Request) {
+	b, err := json.Marshal(h.status())
 	if err != nil {
-		glog.Errorf("Cannot reset namespace %s: %v", namespaceName, err)
+		glog.Errorf("Cannot marshal the status: %v", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
+	w.Write(b)
 }
 
 func (h *HandlerAPI) isReadyHandler(w http.ResponseWriter, _ *http.Request) {
@@ -76,36 +99,43 @@ func (h *HandlerAPI) isReadyHandler(w http.ResponseWriter, _ *http.Request) {
 	return
 }
 
-// NewAPI returns the API HTTP server
-func NewAPI(sigChan chan os.Signal, resetNamespaceFn func(namespaces *corev1.NamespaceList) error, isReadyFn func() bool, apply chan struct{}) *http.Server {
+// NewAPI returns the API served on the given listeners
+func NewAPI(listeners *Listeners, sigChan chan os.Signal, resetNamespaceFn func(namespaces *corev1.NamespaceList, opts *ResetOptions) error, isReadyFn func() bool, statusFn func() *Status, events *EventBus, applyFn func(req *ApplyRequest) (*ApplyResult, error), listCertificatesFn, rotateCertificatesFn func() ([]pki.Info, error)) *API {
 	h := HandlerAPI{
-		sigChan:        sigChan,
-		resetNamespace: resetNamespaceFn,
-		isReady:        isReadyFn,
-		apply:          apply,
+		sigChan:            sigChan,
+		resetNamespace:     resetNamespaceFn,
+		isReady:            isReadyFn,
+		status:             statusFn,
+		events:             events,
+		apply:              applyFn,
+		listCertificates:   listCertificatesFn,
+		rotateCertificates: rotateCertificatesFn,
 	}
 	r := mux.NewRouter()
 
 	// POSTs
-	r.Methods("POST").Path(stopRoute).HandlerFunc(h.stopHandler)
-	r.Methods("POST").Path(applyRoute).HandlerFunc(h.applyHandler)
-	r.Methods("POST").Path(resetRoute + "/{namespace}").HandlerFunc(h.resetHandler)
+	r.Methods("POST").Path(stopRoute).Handler(withTimeout(handlerTimeout, h.stopHandler))
+	r.Methods("POST").Path(applyRoute).Handler(withTimeout(longHandlerTimeout, h.applyHandler))
+	r.Methods("POST").Path(resetRoute + "/{namespace}").Handler(withTimeout(longHandlerTimeout, h.resetHandler))
+	r.Methods("POST").Path(rotateRoute).Handler(withTimeout(longHandlerTimeout, h.rotateHandler))
 
 	// GETs
-	r.Methods("GET").Path("/ready").HandlerFunc(h.isReadyHandler)
+	r.Methods("GET").Path("/ready").Handler(withTimeout(handlerTimeout, h.isReadyHandler))
+	r.Methods("GET").Path(statusRoute).Handler(withTimeout(handlerTimeout, h.statusHandler))
+	// the stream needs the http.Flusher, it ends after eventsStreamDuration
+	r.Methods("GET").Path(eventsRoute).HandlerFunc(h.eventsHandler)
+	r.Methods("GET").Path(certificatesRoute).Handler(withTimeout(handlerTimeout, h.certificatesHandler))
 
 	// monitoring
-	r.Methods("GET").Path("/metrics").Handler(promhttp.Handler())
+	r.Methods("GET").Path("/metrics").Handler(withTimeout(handlerTimeout, promhttp.Handler().ServeHTTP))
 
 	// Known issue with Mux and the registering of pprof:
 	// https://stackoverflow.com/questions/19591065/profiling-go-web-application-built-with-gorillas-mux-with-net-http-pprof
-	r.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
+	// hidden on the listener without token by withoutPprof, the CPU profile lasts 30s by default
+	r.PathPrefix(pprofRoute).Handler(withTimeout(longHandlerTimeout, http.DefaultServeMux.ServeHTTP))
 
-	srv := &http.Server{
-		Handler:      r,
-		Addr:         config.ViperConfig.GetString("bind-address"),
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+	return &API{
+		Listeners: listeners,
+		handler:   r,
 	}
-	return srv
 }
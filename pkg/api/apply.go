@@ -0,0 +1,208 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// ApplyCreated is the result of an object created by the apply
+	ApplyCreated = "created"
+	// ApplyConfigured is the result of an object updated by the apply
+	ApplyConfigured = "configured"
+	// ApplyUnchanged is the result of an object already up to date
+	ApplyUnchanged = "unchanged"
+	// ApplyPruned is the result of an object deleted by the prune
+	ApplyPruned = "pruned"
+	// ApplyError is the result of an object the apply failed on
+	ApplyError = "error"
+	// ApplyUnknown is the result of an object applied with an unexpected kubectl output
+	ApplyUnknown = "unknown"
+
+	defaultApplyTimeout = time.Minute
+	maxApplyTimeout     = 5 * time.Minute
+	maxApplyBodySize    = 10 << 20
+)
+
+// ErrNotReady is returned when the manifests are applied before the readiness
+var ErrNotReady = errors.New("not ready yet")
+
+// ErrPathNotAllowed is returned when the path to apply isn't in the manifests-api directory or the --api-apply-root
+var ErrPathNotAllowed = errors.New("path not allowed, must be in the manifests-api directory or the --api-apply-root")
+
+// ApplyRequest describes the manifests to apply, the manifests-api directory by default
+type ApplyRequest struct {
+	// Manifests are YAML or JSON documents
+	Manifests []byte
+	// Path is a file or a directory on the pupernetes host
+	Path string
+	// DryRun is a server-side dry-run
+	DryRun bool
+	// PruneSelector prunes the objects matching the label selector and not in the manifests
+	PruneSelector string
+	Timeout       time.Duration
+}
+
+// ObjectResult is the result of the apply of an object
+type ObjectResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Result    string `json:"result"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ApplyResult is the result of each object of an ApplyRequest
+type ApplyResult struct {
+	DryRun  bool           `json:"dryRun"`
+	Objects []ObjectResult `json:"objects"`
+	// Errors are the kubectl errors about none of the objects, like a connection refused
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Failed returns the number of objects the apply failed on
+func (r *ApplyResult) Failed() int {
+	failed := 0
+	for _, obj := range r.Objects {
+		if obj.Result == ApplyError {
+			failed++
+		}
+	}
+	return failed
+}
+
+// ReadManifests returns the content of the file or the YAML and JSON files of the directory
+func ReadManifests(manifestsPath string) ([]byte, error) {
+	fi, err := os.Stat(manifestsPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return ioutil.ReadFile(manifestsPath)
+	}
+	files, err := ioutil.ReadDir(manifestsPath)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		switch path.Ext(f.Name()) {
+		case ".yaml", ".yml", ".json":
+			if !f.IsDir() {
+				names = append(names, f.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		b, err := ioutil.ReadFile(path.Join(manifestsPath, name))
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n---\n")
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// newApplyRequest parses the query parameters and the body of POST /apply
+func newApplyRequest(r *http.Request) (*ApplyRequest, error) {
+	query := r.URL.Query()
+	req := &ApplyRequest{
+		Path:          query.Get("path"),
+		PruneSelector: query.Get("pruneSelector"),
+		Timeout:       defaultApplyTimeout,
+	}
+	var err error
+	if req.PruneSelector != "" {
+		_, err = labels.Parse(req.PruneSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pruneSelector parameter %q: %v", req.PruneSelector, err)
+		}
+	}
+	if s := query.Get("dryRun"); s != "" {
+		req.DryRun, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dryRun parameter %q: %v", s, err)
+		}
+	}
+	if s := query.Get("timeout"); s != "" {
+		req.Timeout, err = time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout parameter %q: %v", s, err)
+		}
+		if req.Timeout <= 0 || req.Timeout > maxApplyTimeout {
+			return nil, fmt.Errorf("invalid timeout parameter %q: must be positive and at most %s", s, maxApplyTimeout.String())
+		}
+	}
+	if req.Path != "" && !filepath.IsAbs(req.Path) {
+		return nil, fmt.Errorf("invalid path parameter %q: must be absolute", req.Path)
+	}
+	if r.Body != nil {
+		req.Manifests, err = ioutil.ReadAll(io.LimitReader(r.Body, maxApplyBodySize+1))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the manifests: %v", err)
+		}
+		if len(req.Manifests) > maxApplyBodySize {
+			return nil, fmt.Errorf("the manifests exceed %d bytes", maxApplyBodySize)
+		}
+	}
+	if len(req.Manifests) > 0 && req.Path != "" {
+		return nil, fmt.Errorf("the manifests and the path parameter are mutually exclusive")
+	}
+	return req, nil
+}
+
+func (h *HandlerAPI) applyHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := newApplyRequest(r)
+	if err != nil {
+		glog.Warningf("Invalid apply request: %v", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	result, err := h.apply(req)
+	if err != nil {
+		glog.Errorf("Cannot apply the manifests: %v", err)
+		code := 500
+		switch err {
+		case ErrPathNotAllowed:
+			code = 403
+		case ErrNotReady:
+			code = 503
+		case context.DeadlineExceeded:
+			code = 504
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		glog.Errorf("Cannot marshal the apply result: %v", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(b)
+}
@@ -0,0 +1,106 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewApplyRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/apply?dryRun=true&pruneSelector=app%3Dtest&timeout=30s", strings.NewReader("kind: ConfigMap"))
+	req, err := newApplyRequest(r)
+	require.NoError(t, err)
+	assert.True(t, req.DryRun)
+	assert.Equal(t, "app=test", req.PruneSelector)
+	assert.Equal(t, 30*time.Second, req.Timeout)
+	assert.Equal(t, "kind: ConfigMap", string(req.Manifests))
+
+	req, err = newApplyRequest(httptest.NewRequest(http.MethodPost, "/apply", nil))
+	require.NoError(t, err)
+	assert.Equal(t, defaultApplyTimeout, req.Timeout)
+	assert.Len(t, req.Manifests, 0)
+
+	for _, target := range []string{
+		"/apply?dryRun=maybe",
+		"/apply?timeout=-1s",
+		"/apply?timeout=1h",
+		"/apply?path=relative/manifests",
+		"/apply?pruneSelector=app%20in%20(",
+	} {
+		_, err = newApplyRequest(httptest.NewRequest(http.MethodPost, target, nil))
+		assert.Error(t, err, target)
+	}
+	_, err = newApplyRequest(httptest.NewRequest(http.MethodPost, "/apply?path=/opt/manifests", strings.NewReader("kind: ConfigMap")))
+	assert.Error(t, err)
+}
+
+func TestReadManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pupernetes-api")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "b.yml"), []byte("b"), 0600))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "a.yaml"), []byte("a"), 0600))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "README.md"), []byte("readme"), 0600))
+
+	b, err := ReadManifests(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "\n---\na\n---\nb", string(b))
+
+	b, err = ReadManifests(path.Join(dir, "README.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "readme", string(b))
+
+	_, err = ReadManifests(path.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestApplyHandler(t *testing.T) {
+	var applyErr error
+	result := &ApplyResult{Objects: []ObjectResult{
+		{Kind: "ConfigMap", Name: "one", Result: ApplyCreated},
+		{Kind: "Service", Name: "two", Result: ApplyError, Error: "invalid"},
+	}}
+	h := &HandlerAPI{apply: func(*ApplyRequest) (*ApplyResult, error) { return result, applyErr }}
+	srv := httptest.NewServer(http.HandlerFunc(h.applyHandler))
+	defer srv.Close()
+	c, err := NewClient(&ClientConfig{Address: srv.URL, Timeout: time.Second})
+	require.NoError(t, err)
+
+	r, err := c.ApplyManifests(&ApplyRequest{Manifests: []byte("kind: ConfigMap")})
+	assert.Error(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, 1, r.Failed())
+
+	result.Objects = result.Objects[:1]
+	r, err = c.ApplyManifests(&ApplyRequest{})
+	require.NoError(t, err)
+	assert.Len(t, r.Objects, 1)
+
+	// an error about none of the objects fails the apply
+	result.Errors = []string{"The connection to the server 127.0.0.1:8080 was refused"}
+	r, err = c.ApplyManifests(&ApplyRequest{})
+	assert.Error(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, result.Errors, r.Errors)
+	result.Errors = nil
+
+	for _, applyErr = range []error{ErrNotReady, ErrPathNotAllowed, context.DeadlineExceeded} {
+		r, err = c.ApplyManifests(&ApplyRequest{})
+		assert.Error(t, err)
+		assert.Nil(t, r)
+	}
+}
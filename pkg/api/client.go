@@ -1,21 +1,173 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
 package api
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
-	"github.com/golang/glog"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/golang/glog"
 )
 
 const (
 	namespacePrefix = "namespace/"
+
+	unixScheme  = "unix://"
+	httpsScheme = "https://"
+	httpScheme  = "http://"
 )
 
+// ClientConfig describes how to reach the pupernetes API
+type ClientConfig struct {
+	// Address is ip:port, http://ip:port, https://ip:port or unix:///path/to/socket
+	Address string
+	Timeout time.Duration
+
+	// TokenFile contains the bearer token sent to the API
+	TokenFile string
+
+	// TLS client certificate and CA, used with https://
+	CertificatePath string
+	PrivateKeyPath  string
+	CAPath          string
+}
+
+// Client calls the pupernetes API over http, https or a unix socket
+type Client struct {
+	baseURL    string
+	token      string
+	transport  *http.Transport
+	httpClient *http.Client
+}
+
+func newClientTLSConfig(conf *ClientConfig) (*tls.Config, error) {
+	if conf.CertificatePath == "" || conf.PrivateKeyPath == "" || conf.CAPath == "" {
+		err := fmt.Errorf("the certificate, the private key and the CA are required to reach %s", conf.Address)
+		glog.Errorf("Cannot configure the TLS client: %v", err)
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(conf.CertificatePath, conf.PrivateKeyPath)
+	if err != nil {
+		glog.Errorf("Cannot load the client certificate %s: %v", conf.CertificatePath, err)
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(conf.CAPath)
+	if err != nil {
+		glog.Errorf("Cannot read the CA %s: %v", conf.CAPath, err)
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		err := fmt.Errorf("no certificate in %s", conf.CAPath)
+		glog.Errorf("Invalid CA: %v", err)
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ReadTokenFile returns the bearer token of the file
+func ReadTokenFile(tokenFile string) (string, error) {
+	b, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		glog.Errorf("Cannot read the token file %s: %v", tokenFile, err)
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		err := fmt.Errorf("empty token in %s", tokenFile)
+		glog.Errorf("Invalid token file: %v", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// NewClient instantiate a Client with the given ClientConfig
+func NewClient(conf *ClientConfig) (*Client, error) {
+	c := &Client{
+		transport: &http.Transport{},
+	}
+	switch {
+	case strings.HasPrefix(conf.Address, unixScheme):
+		socketPath := strings.TrimPrefix(conf.Address, unixScheme)
+		if socketPath == "" {
+			err := fmt.Errorf("empty socket path in %q", conf.Address)
+			glog.Errorf("Invalid API address: %v", err)
+			return nil, err
+		}
+		c.transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		}
+		// the host is ignored by the dialer
+		c.baseURL = "http://unix"
+
+	case strings.HasPrefix(conf.Address, httpsScheme):
+		tlsConfig, err := newClientTLSConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+		c.transport.TLSClientConfig = tlsConfig
+		c.baseURL = strings.TrimSuffix(conf.Address, "/")
+
+	case strings.HasPrefix(conf.Address, httpScheme):
+		c.baseURL = strings.TrimSuffix(conf.Address, "/")
+
+	default:
+		c.baseURL = httpScheme + conf.Address
+	}
+	if conf.TokenFile != "" {
+		token, err := ReadTokenFile(conf.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		c.token = token
+	}
+	c.httpClient = &http.Client{
+		Transport: c.transport,
+		Timeout:   conf.Timeout,
+	}
+	return c, nil
+}
+
+func (c *Client) newRequest(method, apiRoute string) (*http.Request, error) {
+	u, err := url.Parse(c.baseURL + apiRoute)
+	if err != nil {
+		glog.Errorf("Error during urlParse: %v", err)
+		return nil, err
+	}
+	glog.V(3).Infof("Using url: %s", u.String())
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		glog.Errorf("Cannot create the request %s %s: %v", method, u.String(), err)
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
 // ResetNamespace executes an API call to the pupernetes API to reset
-// the namespace in parameter. The namespace can be like ns/default or just default
-func ResetNamespace(timeout time.Duration, apiAddress, namespace string) error {
+// the namespace in parameter. The namespace can be like ns/default or just default.
+// The call returns once the deleted objects are gone
+func (c *Client) ResetNamespace(namespace string, opts *ResetOptions) error {
 	if strings.HasPrefix(namespace, namespacePrefix) {
 		glog.V(4).Infof("Stripping namespace %q", namespace)
 		namespace = namespace[len(namespacePrefix):]
@@ -27,36 +179,182 @@ func ResetNamespace(timeout time.Duration, apiAddress, namespace string) error {
 		return err
 	}
 	glog.Infof("Resetting namespace %q ...", namespace)
-	return doPOST(timeout, apiAddress, fmt.Sprintf("%s/%s", resetRoute, namespace))
+	apiRoute := fmt.Sprintf("%s/%s", resetRoute, namespace)
+	query := opts.query()
+	if len(query) > 0 {
+		apiRoute += "?" + query.Encode()
+	}
+	timeout := defaultResetTimeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	return c.doPOST(apiRoute, timeout)
 }
 
 // Apply executes an API call to the pupernetes API to force an apply of the "manifest-api" directory
-func Apply(timeout time.Duration, apiAddress string) error {
+func (c *Client) Apply() error {
 	glog.Infof("Applying ...")
-	return doPOST(timeout, apiAddress, applyRoute)
+	_, err := c.ApplyManifests(&ApplyRequest{})
+	return err
 }
 
-func doPOST(timeout time.Duration, apiAddress, apiRoute string) error {
-	glog.Infof("Calling POST %s ...", apiRoute)
-	c := &http.Client{}
-	c.Timeout = timeout
+// ApplyManifests executes an API call to the pupernetes API to apply the manifests of the request,
+// an error is returned if the apply failed on any object
+func (c *Client) ApplyManifests(applyRequest *ApplyRequest) (*ApplyResult, error) {
+	query := url.Values{}
+	if applyRequest.Path != "" {
+		query.Set("path", applyRequest.Path)
+	}
+	if applyRequest.DryRun {
+		query.Set("dryRun", "true")
+	}
+	if applyRequest.PruneSelector != "" {
+		query.Set("pruneSelector", applyRequest.PruneSelector)
+	}
+	timeout := defaultApplyTimeout
+	if applyRequest.Timeout > 0 {
+		timeout = applyRequest.Timeout
+		query.Set("timeout", timeout.String())
+	}
+	apiRoute := applyRoute
+	if len(query) > 0 {
+		apiRoute += "?" + query.Encode()
+	}
+	req, err := c.newRequest(http.MethodPost, apiRoute)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(applyRequest.Manifests))
+	req.ContentLength = int64(len(applyRequest.Manifests))
+	req.Header.Set("Content-Type", "application/yaml")
 
-	u, err := url.Parse(fmt.Sprintf("http://%s%s", apiAddress, apiRoute))
+	// the API replies once the apply is done
+	applyClient := &http.Client{
+		Transport: c.transport,
+		Timeout:   timeout + c.httpClient.Timeout,
+	}
+	resp, err := applyClient.Do(req)
+	if err != nil {
+		glog.Errorf("Unexpected error during POST %s: %v", req.URL.String(), err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("non OK status code when POST %s: %d %s", req.URL.String(), resp.StatusCode, strings.TrimSpace(string(b)))
+		glog.Errorf("Cannot POST: %v", err)
+		return nil, err
+	}
+	result := &ApplyResult{}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		glog.Errorf("Cannot decode the apply result: %v", err)
+		return nil, err
+	}
+	failed := result.Failed()
+	if failed > 0 {
+		err := fmt.Errorf("the apply failed on %d/%d objects", failed, len(result.Objects))
+		glog.Errorf("Unexpected apply result: %v", err)
+		return result, err
+	}
+	if len(result.Errors) > 0 {
+		err := fmt.Errorf("the apply failed: %s", strings.Join(result.Errors, ", "))
+		glog.Errorf("Unexpected apply result: %v", err)
+		return result, err
+	}
+	glog.Infof("Applied %d objects", len(result.Objects))
+	return result, nil
+}
+
+// GetStatus executes an API call to the pupernetes API to get the status of the run
+func (c *Client) GetStatus() (*Status, error) {
+	req, err := c.newRequest(http.MethodGet, statusRoute)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		glog.Errorf("Unexpected error during GET %s: %v", req.URL.String(), err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("non OK status code when GET %s: %d", req.URL.String(), resp.StatusCode)
+		glog.Errorf("Cannot GET: %v", err)
+		return nil, err
+	}
+	status := &Status{}
+	err = json.NewDecoder(resp.Body).Decode(status)
+	if err != nil {
+		glog.Errorf("Cannot decode the status: %v", err)
+		return nil, err
+	}
+	return status, nil
+}
+
+// StreamEvents executes an API call to the pupernetes API to stream the events after the given id,
+// handle is called for each event until it returns true.
+// It returns the id of the last handled event, to resume the stream, and if handle returned true
+func (c *Client) StreamEvents(since int, handle func(*Event) bool) (int, bool, error) {
+	streamClient := &http.Client{
+		Transport: c.transport,
+		Timeout:   eventsStreamDuration + 5*time.Second,
+	}
+	req, err := c.newRequest(http.MethodGet, fmt.Sprintf("%s?since=%d", eventsRoute, since))
+	if err != nil {
+		return since, false, err
+	}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		glog.V(2).Infof("Unexpected error during GET %s: %v", req.URL.String(), err)
+		return since, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("non OK status code when GET %s: %d", req.URL.String(), resp.StatusCode)
+		glog.Errorf("Cannot GET: %v", err)
+		return since, false, err
+	}
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		event := &Event{}
+		err = dec.Decode(event)
+		if err != nil {
+			glog.Errorf("Cannot decode the event after %d: %v", since, err)
+			return since, false, err
+		}
+		since = event.ID
+		if handle(event) {
+			return since, true, nil
+		}
+	}
+	return since, false, nil
+}
+
+// doPOST calls the route, the API replies after at most the given timeout
+func (c *Client) doPOST(apiRoute string, timeout time.Duration) error {
+	glog.Infof("Calling POST %s ...", apiRoute)
+	req, err := c.newRequest(http.MethodPost, apiRoute)
 	if err != nil {
-		glog.Errorf("Error during urlParse: %v", err)
 		return err
 	}
-	glog.V(3).Infof("Using url: %s", u.String())
-	resp, err := c.Post(u.String(), "application/json", nil)
+	req.Header.Set("Content-Type", "application/json")
+	postClient := &http.Client{
+		Transport: c.transport,
+		Timeout:   timeout + c.httpClient.Timeout,
+	}
+	resp, err := postClient.Do(req)
 	if err != nil {
-		glog.Errorf("Unexpected error during POST %s: %v", u.String(), err)
+		glog.Errorf("Unexpected error during POST %s: %v", req.URL.String(), err)
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		err := fmt.Errorf("non OK status code when POST %s: %d", u.String(), resp.StatusCode)
+		b, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("non OK status code when POST %s: %d %s", req.URL.String(), resp.StatusCode, strings.TrimSpace(string(b)))
 		glog.Errorf("Cannot POST: %v", err)
 		return err
 	}
-	glog.Infof("POST on %s successfully executed: %d", u.String(), resp.StatusCode)
+	glog.Infof("POST on %s successfully executed: %d", req.URL.String(), resp.StatusCode)
 	return nil
 }
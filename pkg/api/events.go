@@ -0,0 +1,204 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	eventsRoute = "/events"
+
+	// eventsStreamDuration is below the handlerTimeout of the other routes,
+	// the clients resume the stream with the since parameter
+	eventsStreamDuration = 10 * time.Second
+
+	// eventsHistory is the number of events kept for the clients connecting late
+	eventsHistory = 1024
+
+	// eventsCloseTimeout is the maximum time to wait for the streams to send the remaining events on close
+	eventsCloseTimeout = 2 * time.Second
+)
+
+const (
+	// EventUnitStarted is emitted when a systemd unit is started
+	EventUnitStarted = "unit-started"
+	// EventProbeFailed is emitted when the systemd units or the kubelet probe failed
+	EventProbeFailed = "probe-failed"
+	// EventManifestsApplied is emitted after a successful kubectl apply
+	EventManifestsApplied = "manifests-applied"
+	// EventDNSReady is emitted when the in-cluster dns queries succeed
+	EventDNSReady = "dns-ready"
+	// EventReady is emitted when pupernetes is ready
+	EventReady = "ready"
+	// EventDrainStarted is emitted when the pods are drained before the stop
+	EventDrainStarted = "drain-started"
+	// EventPodsRemaining is emitted while pods are still running during the drain
+	EventPodsRemaining = "pods-remaining"
+	// EventStopped is emitted when the systemd units are stopped, this is the last event
+	EventStopped = "stopped"
+)
+
+// Event is a lifecycle event of a pupernetes run
+type Event struct {
+	ID      int               `json:"id"`
+	Type    string            `json:"type"`
+	Time    time.Time         `json:"time"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// EventBus keeps the events of the run and notifies the streams
+type EventBus struct {
+	sync.RWMutex
+
+	lastID  int
+	events  []Event
+	closed  bool
+	notify  chan struct{}
+	streams sync.WaitGroup
+}
+
+// NewEventBus instantiate an empty EventBus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		notify: make(chan struct{}),
+	}
+}
+
+// Publish records a new event and notifies the streams
+func (b *EventBus) Publish(eventType string, details map[string]string) {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		glog.V(4).Infof("Discarding the event %s, the event bus is closed", eventType)
+		return
+	}
+	b.lastID++
+	b.events = append(b.events, Event{
+		ID:      b.lastID,
+		Type:    eventType,
+		Time:    time.Now(),
+		Details: details,
+	})
+	if len(b.events) > eventsHistory {
+		b.events = b.events[len(b.events)-eventsHistory:]
+	}
+	glog.V(4).Infof("Published the event %d %s %v", b.lastID, eventType, details)
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// Close ends the streams and waits until they sent the remaining events, like the stopped one,
+// the process usually exits right after
+func (b *EventBus) Close() {
+	b.Lock()
+	if b.closed {
+		b.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.notify)
+	b.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.streams.Wait()
+		close(done)
+	}()
+	timeout := time.NewTimer(eventsCloseTimeout)
+	defer timeout.Stop()
+	select {
+	case <-done:
+		glog.V(4).Infof("All the event streams are ended")
+	case <-timeout.C:
+		glog.Warningf("Timeout %s reached while ending the event streams", eventsCloseTimeout.String())
+	}
+}
+
+// addStream registers a stream waited by Close, false if the bus is already closed
+func (b *EventBus) addStream() bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return false
+	}
+	b.streams.Add(1)
+	return true
+}
+
+// since returns the events after the given id, a channel closed on the next publish and if the bus is closed
+func (b *EventBus) since(id int) ([]Event, <-chan struct{}, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	var events []Event
+	for _, event := range b.events {
+		if event.ID > id {
+			events = append(events, event)
+		}
+	}
+	return events, b.notify, b.closed
+}
+
+// eventsHandler streams the events as JSON lines
+func (h *HandlerAPI) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	since := 0
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = strconv.Atoi(s)
+		if err != nil {
+			glog.Warningf("Invalid since parameter %q: %v", s, err)
+			http.Error(w, "invalid since parameter: "+err.Error(), 400)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		glog.Errorf("Cannot stream the events: unsupported http.Flusher")
+		http.Error(w, "streaming unsupported", 500)
+		return
+	}
+	if !h.events.addStream() {
+		glog.V(2).Infof("Cannot stream the events: the event bus is closed")
+		http.Error(w, "stopped", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.events.streams.Done()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	end := time.NewTimer(eventsStreamDuration)
+	defer end.Stop()
+	enc := json.NewEncoder(w)
+	for {
+		events, notify, closed := h.events.since(since)
+		for _, event := range events {
+			err := enc.Encode(event)
+			if err != nil {
+				glog.V(2).Infof("Cannot write the event %d: %v", event.ID, err)
+				return
+			}
+			since = event.ID
+		}
+		flusher.Flush()
+		if closed {
+			return
+		}
+		select {
+		case <-notify:
+		case <-end.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
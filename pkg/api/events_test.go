@@ -0,0 +1,94 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamEvents(t *testing.T) {
+	bus := NewEventBus()
+	h := &HandlerAPI{events: bus}
+	srv := httptest.NewServer(http.HandlerFunc(h.eventsHandler))
+	defer srv.Close()
+	c, err := NewClient(&ClientConfig{Address: srv.URL})
+	require.NoError(t, err)
+
+	bus.Publish(EventUnitStarted, map[string]string{"unit": "p8s-etcd.service"})
+	bus.Publish(EventManifestsApplied, nil)
+
+	// the published events are replayed then the stream waits for the next ones
+	var types []string
+	go bus.Publish(EventReady, nil)
+	since, done, err := c.StreamEvents(0, func(event *Event) bool {
+		types = append(types, event.Type)
+		return event.Type == EventReady
+	})
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 3, since)
+	assert.Equal(t, []string{EventUnitStarted, EventManifestsApplied, EventReady}, types)
+
+	// the stream resumes after the given id
+	bus.Publish(EventStopped, map[string]string{"error": "timeout reached"})
+	var events []*Event
+	since, done, err = c.StreamEvents(since, func(event *Event) bool {
+		events = append(events, event)
+		return event.Type == EventStopped
+	})
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 4, since)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventStopped, events[0].Type)
+	assert.Equal(t, "timeout reached", events[0].Details["error"])
+
+	// the closed bus refuses the new streams
+	bus.Close()
+	bus.Publish(EventReady, nil)
+	_, done, err = c.StreamEvents(since, func(event *Event) bool {
+		return true
+	})
+	assert.Error(t, err)
+	assert.False(t, done)
+	assert.False(t, bus.addStream())
+}
+
+func TestCloseWaitsForStreams(t *testing.T) {
+	bus := NewEventBus()
+	h := &HandlerAPI{events: bus}
+	srv := httptest.NewServer(http.HandlerFunc(h.eventsHandler))
+	defer srv.Close()
+	c, err := NewClient(&ClientConfig{Address: srv.URL})
+	require.NoError(t, err)
+
+	bus.Publish(EventReady, nil)
+	streaming := make(chan struct{})
+	result := make(chan []string, 1)
+	go func() {
+		var types []string
+		c.StreamEvents(0, func(event *Event) bool {
+			types = append(types, event.Type)
+			if event.Type == EventReady {
+				close(streaming)
+			}
+			return false
+		})
+		result <- types
+	}()
+	<-streaming
+
+	// the process exits right after the close
+	bus.Publish(EventStopped, nil)
+	bus.Close()
+	srv.CloseClientConnections()
+	assert.Equal(t, []string{EventReady, EventStopped}, <-result)
+}
@@ -0,0 +1,271 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Listeners are the addresses served by the API
+type Listeners struct {
+	// Address is the plain HTTP listener ip:port, empty disables it
+	Address string
+
+	// SocketPath is the unix domain socket, empty disables it
+	SocketPath string
+	SocketMode os.FileMode
+
+	// TLSAddress is the HTTPS listener ip:port requiring a client certificate issued by the CA, empty disables it
+	TLSAddress         string
+	TLSCertificatePath string
+	TLSPrivateKeyPath  string
+	TLSClientCAPath    string
+
+	// Token is the bearer token required on the plain HTTP listener, empty disables the authentication
+	Token string
+}
+
+const (
+	// TLSClientCommonName and TLSClientOrganization are the subject of the client certificates accepted on the TLS listener,
+	// the root CA also issues the certificates of the Kubernetes components
+	TLSClientCommonName   = "pupernetes-api-client"
+	TLSClientOrganization = "pupernetes:api-clients"
+
+	pprofRoute = "/debug/pprof/"
+
+	// handlerTimeout is the deadline of the routes, except the events stream ending after eventsStreamDuration
+	handlerTimeout = 15 * time.Second
+	// longHandlerTimeout is the deadline of the synchronous apply and reset, waiting at most maxApplyTimeout,
+	// and of the rotation restarting the control plane
+	longHandlerTimeout = maxApplyTimeout + handlerTimeout
+)
+
+// API is the pupernetes API served on its listeners
+type API struct {
+	Listeners *Listeners
+
+	handler http.Handler
+}
+
+// umaskLock serializes the umask changes of listenUnix
+var umaskLock sync.Mutex
+
+// publicRoutes don't require the bearer token
+var publicRoutes = map[string]bool{
+	"/ready":   true,
+	"/metrics": true,
+}
+
+// withToken requires the bearer token on the non public routes
+func withToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicRoutes[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			glog.Warningf("Unauthorized %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withoutPprof hides the profiling routes on the listener without authentication
+func withoutPprof(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, pprofRoute) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withTimeout replies 503 if the handler doesn't complete before the timeout,
+// the server has no WriteTimeout to let the synchronous apply and reset run longer than the other routes
+func withTimeout(timeout time.Duration, handler http.HandlerFunc) http.Handler {
+	return http.TimeoutHandler(handler, timeout, fmt.Sprintf("timeout %s reached", timeout.String()))
+}
+
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:     handler,
+		ReadTimeout: 15 * time.Second,
+		IdleTimeout: 2 * time.Minute,
+	}
+}
+
+// verifyTLSClient rejects the client certificates issued by the CA to anything else than the API clients
+func verifyTLSClient(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return fmt.Errorf("no verified client certificate")
+	}
+	subject := verifiedChains[0][0].Subject
+	if subject.CommonName != TLSClientCommonName {
+		return fmt.Errorf("unexpected client certificate common name %q", subject.CommonName)
+	}
+	for _, o := range subject.Organization {
+		if o == TLSClientOrganization {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected client certificate organization %q", subject.Organization)
+}
+
+// newTLSConfig requires the client certificates of the API clients issued by the CA,
+// the server certificate is read at each handshake to use the rotated one
+func (l *Listeners) newTLSConfig() (*tls.Config, error) {
+	b, err := ioutil.ReadFile(l.TLSClientCAPath)
+	if err != nil {
+		glog.Errorf("Cannot read the client CA %s: %v", l.TLSClientCAPath, err)
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		err := fmt.Errorf("no certificate in %s", l.TLSClientCAPath)
+		glog.Errorf("Invalid client CA: %v", err)
+		return nil, err
+	}
+	_, err = tls.LoadX509KeyPair(l.TLSCertificatePath, l.TLSPrivateKeyPath)
+	if err != nil {
+		glog.Errorf("Cannot load the certificate %s: %v", l.TLSCertificatePath, err)
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(l.TLSCertificatePath, l.TLSPrivateKeyPath)
+			if err != nil {
+				glog.Errorf("Cannot load the certificate %s: %v", l.TLSCertificatePath, err)
+				return nil, err
+			}
+			return &cert, nil
+		},
+		ClientCAs:             pool,
+		ClientAuth:            tls.RequireAndVerifyClientCert,
+		VerifyPeerCertificate: verifyTLSClient,
+		MinVersion:            tls.VersionTLS12,
+	}, nil
+}
+
+// listenUnix binds the socket with the umask of its mode, bind creates it with 0777 minus the umask
+// so it's never reachable with other permissions
+func (l *Listeners) listenUnix() (net.Listener, error) {
+	err := os.Remove(l.SocketPath)
+	if err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Cannot remove the previous socket %s: %v", l.SocketPath, err)
+		return nil, err
+	}
+	// the umask is process wide
+	umaskLock.Lock()
+	umask := syscall.Umask(int(^l.SocketMode.Perm() & os.ModePerm))
+	listener, err := net.Listen("unix", l.SocketPath)
+	syscall.Umask(umask)
+	umaskLock.Unlock()
+	if err != nil {
+		glog.Errorf("Cannot listen on %s: %v", l.SocketPath, err)
+		return nil, err
+	}
+	return listener, nil
+}
+
+// isLoopbackAddress returns true if the ip:port only listens on the loopback, an empty host listens on every interface
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Serve listens on the configured listeners and serves them in the background,
+// the connections on the unix socket and the TLS listener are already authenticated
+func (a *API) Serve() error {
+	l := a.Listeners
+	if l.Address == "" && l.SocketPath == "" && l.TLSAddress == "" {
+		err := fmt.Errorf("no listener configured")
+		glog.Errorf("Cannot serve the API: %v", err)
+		return err
+	}
+	// the unauthenticated routes like /stop and /reset must not be reachable from the other hosts
+	if l.Address != "" && l.Token == "" && !isLoopbackAddress(l.Address) {
+		err := fmt.Errorf("the API on %s isn't bound to the loopback and requires a token", l.Address)
+		glog.Errorf("Cannot serve the API: %v, set --api-token-file or use --api-tls-address", err)
+		return err
+	}
+	var listeners []net.Listener
+	var servers []*http.Server
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+	if l.Address != "" {
+		listener, err := net.Listen("tcp", l.Address)
+		if err != nil {
+			glog.Errorf("Cannot listen on %s: %v", l.Address, err)
+			closeAll()
+			return err
+		}
+		handler := a.handler
+		if l.Token != "" {
+			handler = withToken(l.Token, handler)
+		} else {
+			glog.V(2).Infof("No token configured, the API on %s isn't authenticated and doesn't serve %s", l.Address, pprofRoute)
+			handler = withoutPprof(handler)
+		}
+		listeners = append(listeners, listener)
+		servers = append(servers, newServer(handler))
+	}
+	if l.SocketPath != "" {
+		listener, err := l.listenUnix()
+		if err != nil {
+			closeAll()
+			return err
+		}
+		listeners = append(listeners, listener)
+		servers = append(servers, newServer(a.handler))
+	}
+	if l.TLSAddress != "" {
+		tlsConfig, err := l.newTLSConfig()
+		if err != nil {
+			closeAll()
+			return err
+		}
+		listener, err := tls.Listen("tcp", l.TLSAddress, tlsConfig)
+		if err != nil {
+			glog.Errorf("Cannot listen on %s: %v", l.TLSAddress, err)
+			closeAll()
+			return err
+		}
+		listeners = append(listeners, listener)
+		servers = append(servers, newServer(a.handler))
+	}
+	for i := range listeners {
+		glog.V(2).Infof("Serving the API on %s", listeners[i].Addr().String())
+		go servers[i].Serve(listeners[i])
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/pupernetes/pkg/pki"
+)
+
+func newTestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(200)
+		w.Write([]byte(`{"dryRun":false,"objects":[]}`))
+	})
+}
+
+func TestWithToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pupernetes-api")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := path.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("s3cr3t\n"), 0600))
+
+	srv := httptest.NewServer(withToken("s3cr3t", newTestHandler()))
+	defer srv.Close()
+
+	anonymous, err := NewClient(&ClientConfig{Address: srv.URL, Timeout: time.Second})
+	require.NoError(t, err)
+	assert.Error(t, anonymous.Apply())
+	req, err := anonymous.newRequest(http.MethodGet, "/ready")
+	require.NoError(t, err)
+	resp, err := anonymous.httpClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	authenticated, err := NewClient(&ClientConfig{Address: srv.URL, Timeout: time.Second, TokenFile: tokenFile})
+	require.NoError(t, err)
+	assert.NoError(t, authenticated.Apply())
+
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("\n"), 0600))
+	_, err = NewClient(&ClientConfig{Address: srv.URL, TokenFile: tokenFile})
+	assert.Error(t, err)
+}
+
+func TestWithoutPprof(t *testing.T) {
+	srv := httptest.NewServer(withoutPprof(newTestHandler()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/debug/pprof/heap")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/status")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestIsLoopbackAddress(t *testing.T) {
+	for address, loopback := range map[string]bool{
+		"127.0.0.1:8989": true,
+		"[::1]:8989":     true,
+		"localhost:8989": true,
+		"0.0.0.0:8989":   false,
+		":8989":          false,
+		"10.0.0.1:8989":  false,
+		"127.0.0.1":      false,
+	} {
+		assert.Equal(t, loopback, isLoopbackAddress(address), address)
+	}
+}
+
+func TestServeRequiresTokenOutsideLoopback(t *testing.T) {
+	a := &API{Listeners: &Listeners{Address: "0.0.0.0:0"}, handler: newTestHandler()}
+	assert.Error(t, a.Serve())
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pupernetes-api")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := path.Join(dir, "api.sock")
+
+	l := &Listeners{SocketPath: socketPath, SocketMode: 0600}
+	listener, err := l.listenUnix()
+	require.NoError(t, err)
+	go newServer(newTestHandler()).Serve(listener)
+	defer listener.Close()
+
+	fi, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	c, err := NewClient(&ClientConfig{Address: "unix://" + socketPath, Timeout: time.Second})
+	require.NoError(t, err)
+	assert.NoError(t, c.Apply())
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pupernetes-api")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, err := pki.NewRootCA("p8s", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, ca.WriteFiles(dir, "pupernetes", 0600))
+	server, err := ca.Issue(&pki.Request{
+		CommonName:   "pupernetes-api",
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		TTL:          time.Hour,
+		ExtKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	require.NoError(t, err)
+	require.NoError(t, server.WriteFiles(dir, "pupernetes-api", 0600))
+	client, err := ca.Issue(&pki.Request{
+		CommonName:   TLSClientCommonName,
+		Organization: []string{TLSClientOrganization},
+		TTL:          time.Hour,
+		ExtKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.WriteFiles(dir, "pupernetes-api-client", 0600))
+	// issued by the same CA to a Kubernetes component
+	admin, err := ca.Issue(&pki.Request{
+		CommonName:   "p8s-admin",
+		Organization: []string{"system:masters"},
+		TTL:          time.Hour,
+		ExtKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	require.NoError(t, err)
+	require.NoError(t, admin.WriteFiles(dir, "p8s-admin", 0600))
+
+	l := &Listeners{
+		TLSCertificatePath: pki.FilePath(dir, "pupernetes-api", pki.CertificateSuffix),
+		TLSPrivateKeyPath:  pki.FilePath(dir, "pupernetes-api", pki.PrivateKeySuffix),
+		TLSClientCAPath:    pki.FilePath(dir, "pupernetes", pki.CertificateSuffix),
+	}
+	tlsConfig, err := l.newTLSConfig()
+	require.NoError(t, err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	require.NoError(t, err)
+	go newServer(newTestHandler()).Serve(listener)
+	defer listener.Close()
+	address := "https://" + listener.Addr().String()
+
+	c, err := NewClient(&ClientConfig{
+		Address:         address,
+		Timeout:         time.Second,
+		CertificatePath: pki.FilePath(dir, "pupernetes-api-client", pki.CertificateSuffix),
+		PrivateKeyPath:  pki.FilePath(dir, "pupernetes-api-client", pki.PrivateKeySuffix),
+		CAPath:          pki.FilePath(dir, "pupernetes", pki.CertificateSuffix),
+	})
+	require.NoError(t, err)
+	assert.NoError(t, c.Apply())
+
+	// the other certificates of the CA are rejected
+	c, err = NewClient(&ClientConfig{
+		Address:         address,
+		Timeout:         time.Second,
+		CertificatePath: pki.FilePath(dir, "p8s-admin", pki.CertificateSuffix),
+		PrivateKeyPath:  pki.FilePath(dir, "p8s-admin", pki.PrivateKeySuffix),
+		CAPath:          pki.FilePath(dir, "pupernetes", pki.CertificateSuffix),
+	})
+	require.NoError(t, err)
+	assert.Error(t, c.Apply())
+
+	// the client certificate is required
+	_, err = NewClient(&ClientConfig{Address: address, CAPath: pki.FilePath(dir, "pupernetes", pki.CertificateSuffix)})
+	assert.Error(t, err)
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, Timeout: time.Second}
+	_, err = anonymous.Post(address+applyRoute, "application/json", nil)
+	assert.Error(t, err)
+}
+
+func TestWithTimeout(t *testing.T) {
+	srv := httptest.NewServer(withTimeout(10*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/slow")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 503, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/fast")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// the events are streamed without timeout handler
+	bus := NewEventBus()
+	a := NewAPI(&Listeners{}, nil, nil, nil, nil, bus, nil, nil, nil)
+	events := httptest.NewServer(a.handler)
+	defer events.Close()
+	c, err := NewClient(&ClientConfig{Address: events.URL})
+	require.NoError(t, err)
+	bus.Publish(EventReady, nil)
+	_, done, err := c.StreamEvents(0, func(event *Event) bool { return event.Type == EventReady })
+	require.NoError(t, err)
+	assert.True(t, done)
+}
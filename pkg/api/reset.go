@@ -0,0 +1,120 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	defaultResetTimeout = time.Minute
+	maxResetTimeout     = maxApplyTimeout
+)
+
+// ResetOptions selects the objects deleted by a reset, every namespaced resource by default
+type ResetOptions struct {
+	// Include restricts the reset to these resources, like pods, deployments.apps or a custom resource
+	Include []string
+	// Exclude keeps these resources
+	Exclude []string
+	// Selector is a label selector of the objects to delete
+	Selector string
+	// Timeout is the maximum time waited for the deleted objects to disappear
+	Timeout time.Duration
+}
+
+func splitResources(s string) []string {
+	var resources []string
+	for _, resource := range strings.Split(s, ",") {
+		resource = strings.TrimSpace(resource)
+		if resource != "" {
+			resources = append(resources, resource)
+		}
+	}
+	return resources
+}
+
+// newResetOptions parses the query parameters of POST /reset/{namespace}
+func newResetOptions(query url.Values) (*ResetOptions, error) {
+	opts := &ResetOptions{
+		Include:  splitResources(query.Get("include")),
+		Exclude:  splitResources(query.Get("exclude")),
+		Selector: query.Get("selector"),
+		Timeout:  defaultResetTimeout,
+	}
+	if opts.Selector != "" {
+		_, err := labels.Parse(opts.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector parameter %q: %v", opts.Selector, err)
+		}
+	}
+	if s := query.Get("timeout"); s != "" {
+		var err error
+		opts.Timeout, err = time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout parameter %q: %v", s, err)
+		}
+		if opts.Timeout <= 0 || opts.Timeout > maxResetTimeout {
+			return nil, fmt.Errorf("invalid timeout parameter %q: must be positive and at most %s", s, maxResetTimeout.String())
+		}
+	}
+	return opts, nil
+}
+
+// query returns the query parameters of the ResetOptions
+func (o *ResetOptions) query() url.Values {
+	query := url.Values{}
+	if len(o.Include) > 0 {
+		query.Set("include", strings.Join(o.Include, ","))
+	}
+	if len(o.Exclude) > 0 {
+		query.Set("exclude", strings.Join(o.Exclude, ","))
+	}
+	if o.Selector != "" {
+		query.Set("selector", o.Selector)
+	}
+	if o.Timeout > 0 {
+		query.Set("timeout", o.Timeout.String())
+	}
+	return query
+}
+
+func (h *HandlerAPI) resetHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	namespaceName, ok := vars["namespace"]
+	if !ok || namespaceName == "" {
+		glog.Warningf("Invalid namespace %v", vars)
+		http.NotFound(w, r)
+		return
+	}
+	opts, err := newResetOptions(r.URL.Query())
+	if err != nil {
+		glog.Warningf("Invalid reset request: %v", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	namespaceItem := corev1.Namespace{}
+	namespaceItem.Name = namespaceName
+	glog.Infof("Resetting namespace %q ...", namespaceItem.Name)
+	err = h.resetNamespace(&corev1.NamespaceList{
+		Items: []corev1.Namespace{namespaceItem},
+	}, opts)
+	if err != nil {
+		glog.Errorf("Cannot reset namespace %s: %v", namespaceName, err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(200)
+}
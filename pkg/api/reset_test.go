@@ -0,0 +1,43 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewResetOptions(t *testing.T) {
+	opts, err := newResetOptions(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, &ResetOptions{Timeout: defaultResetTimeout}, opts)
+
+	expected := &ResetOptions{
+		Include:  []string{"pods", "deployments.apps"},
+		Exclude:  []string{"secrets"},
+		Selector: "app in (nginx,web)",
+		Timeout:  30 * time.Second,
+	}
+	opts, err = newResetOptions(expected.query())
+	require.NoError(t, err)
+	assert.Equal(t, expected, opts)
+
+	for _, query := range []string{
+		"selector=app%20in%20(",
+		"timeout=0s",
+		"timeout=10m",
+		"timeout=soon",
+	} {
+		values, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		_, err = newResetOptions(values)
+		assert.Error(t, err, query)
+	}
+}
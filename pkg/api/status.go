@@ -0,0 +1,51 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"time"
+)
+
+const (
+	statusRoute = "/status"
+)
+
+// Status is the current state of a pupernetes run
+type Status struct {
+	Phase                string            `json:"phase"`
+	Ready                bool              `json:"ready"`
+	StateDirectory       string            `json:"stateDirectory"`
+	StartedAt            time.Time         `json:"startedAt"`
+	Uptime               string            `json:"uptime"`
+	Units                []UnitStatus      `json:"units"`
+	UnitsError           string            `json:"unitsError,omitempty"`
+	Versions             map[string]string `json:"versions"`
+	Endpoints            map[string]string `json:"endpoints"`
+	Blockers             []Blocker         `json:"blockers"`
+	KubectlApplied       bool              `json:"kubectlApplied"`
+	KubeletProbeFailures int               `json:"kubeletProbeFailures"`
+	Pods                 PodCounts         `json:"pods"`
+}
+
+// UnitStatus is the state of a systemd unit
+type UnitStatus struct {
+	Name        string `json:"name"`
+	LoadState   string `json:"loadState"`
+	ActiveState string `json:"activeState"`
+	SubState    string `json:"subState"`
+}
+
+// Blocker is a readiness condition not met yet with its last error
+type Blocker struct {
+	Name      string `json:"name"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// PodCounts are the running pods reported by the kubelet
+type PodCounts struct {
+	KubeletAPIRunning  int `json:"kubeletAPIRunning"`
+	KubeletLogsRunning int `json:"kubeletLogsRunning"`
+}
@@ -0,0 +1,109 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusHandler(t *testing.T) {
+	startedAt := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		status *Status
+	}{
+		{
+			name: "starting",
+			status: &Status{
+				Phase:     "starting",
+				StartedAt: startedAt,
+				Units: []UnitStatus{
+					{Name: "p8s-etcd.service", LoadState: "loaded", ActiveState: "activating", SubState: "start"},
+				},
+				Versions:  map[string]string{"hyperkube": "1.10.3"},
+				Endpoints: map[string]string{"kubeAPIServer": "http://127.0.0.1:8080"},
+				Blockers:  []Blocker{{Name: "units"}, {Name: "apiserver", LastError: "connection refused"}},
+			},
+		},
+		{
+			name: "units error",
+			status: &Status{
+				Phase:      "running",
+				StartedAt:  startedAt,
+				UnitsError: "dbus: connection closed",
+				Versions:   map[string]string{},
+				Endpoints:  map[string]string{},
+				Blockers:   []Blocker{{Name: "dns", LastError: "i/o timeout"}},
+			},
+		},
+		{
+			name: "ready",
+			status: &Status{
+				Phase:                "ready",
+				Ready:                true,
+				StateDirectory:       "/opt/sandbox",
+				StartedAt:            startedAt,
+				Uptime:               "5m0s",
+				Versions:             map[string]string{"hyperkube": "1.10.3", "pupernetes": "0.9.0"},
+				Endpoints:            map[string]string{"api": "127.0.0.1:8989"},
+				Blockers:             []Blocker{},
+				KubectlApplied:       true,
+				KubeletProbeFailures: 1,
+				Pods:                 PodCounts{KubeletAPIRunning: 3, KubeletLogsRunning: 4},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &HandlerAPI{status: func() *Status { return tc.status }}
+			srv := httptest.NewServer(http.HandlerFunc(h.statusHandler))
+			defer srv.Close()
+			c, err := NewClient(&ClientConfig{Address: srv.URL, Timeout: time.Second})
+			require.NoError(t, err)
+
+			s, err := c.GetStatus()
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, s)
+		})
+	}
+}
+
+func TestGetStatusErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "unauthorized",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			},
+		},
+		{
+			name: "not json",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(200)
+				w.Write([]byte("ok"))
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+			c, err := NewClient(&ClientConfig{Address: srv.URL, Timeout: time.Second})
+			require.NoError(t, err)
+
+			s, err := c.GetStatus()
+			assert.Error(t, err)
+			assert.Nil(t, s)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const metadataSuffix = ".json"
+
+// Entry is an archive stored in the cache
+type Entry struct {
+	Key      string    `json:"-"`
+	URL      string    `json:"url"`
+	Checksum string    `json:"checksum,omitempty"`
+	Size     int64     `json:"-"`
+	LastUsed time.Time `json:"-"`
+}
+
+// Cache is a host-wide directory of archives shared across the state directories.
+// The archives are keyed by their URL and their digest.
+// The least recently used archives are evicted when the total size goes over maxSize
+type Cache struct {
+	dir     string
+	maxSize int64
+}
+
+// NewCache instantiate a Cache in the given directory, a maxSize <= 0 disables the eviction
+func NewCache(dir string, maxSize int64) *Cache {
+	return &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+}
+
+// GetDir returns the directory of the cache
+func (c *Cache) GetDir() string {
+	return c.dir
+}
+
+// Key returns the content address of the archive behind the url and the checksum
+func Key(url, checksum string) string {
+	h := sha256.Sum256([]byte(url + "\n" + checksum))
+	return hex.EncodeToString(h[:])
+}
+
+func (c *Cache) entryPath(key string) string {
+	return path.Join(c.dir, key)
+}
+
+// linkOrCopy hard links src to dst and falls back to a copy, like across devices
+func linkOrCopy(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return nil
+	}
+	glog.V(4).Infof("Cannot hard link %s to %s, copying: %v", src, dst, err)
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	d, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0400)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(d, s)
+	if err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// Get links the cached archive to dst, it returns false when the archive isn't cached
+func (c *Cache) Get(url, checksum, dst string) (bool, error) {
+	key := Key(url, checksum)
+	p := c.entryPath(key)
+	_, err := os.Stat(p)
+	if err != nil {
+		glog.V(4).Infof("Cache miss for %s: %s", url, key)
+		return false, nil
+	}
+	err = linkOrCopy(p, dst)
+	if err != nil {
+		glog.Errorf("Cannot use the cached archive %s for %s: %v", p, url, err)
+		return false, err
+	}
+	now := time.Now()
+	err = os.Chtimes(p, now, now)
+	if err != nil {
+		glog.Warningf("Cannot update the last use of %s: %v", p, err)
+	}
+	glog.V(2).Infof("Using the cached archive %s for %s", p, url)
+	return true, nil
+}
+
+// Put stores src as the archive of the url and the checksum, then evicts the old entries
+func (c *Cache) Put(url, checksum, src string) error {
+	err := os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		glog.Errorf("Cannot create the cache directory %s: %v", c.dir, err)
+		return err
+	}
+	key := Key(url, checksum)
+	p := c.entryPath(key)
+
+	// write in temporary files and rename to stay consistent with concurrent pupernetes
+	tmp := fmt.Sprintf("%s.%d.tmp", p, os.Getpid())
+	_ = os.Remove(tmp)
+	err = linkOrCopy(src, tmp)
+	if err != nil {
+		glog.Errorf("Cannot store %s in the cache: %v", src, err)
+		return err
+	}
+	b, err := json.Marshal(&Entry{URL: url, Checksum: checksum})
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	err = ioutil.WriteFile(tmp+metadataSuffix, b, 0444)
+	if err != nil {
+		_ = os.Remove(tmp)
+		glog.Errorf("Cannot write the cache metadata of %s: %v", url, err)
+		return err
+	}
+	err = os.Rename(tmp+metadataSuffix, p+metadataSuffix)
+	if err != nil {
+		_ = os.Remove(tmp)
+		_ = os.Remove(tmp + metadataSuffix)
+		return err
+	}
+	err = os.Rename(tmp, p)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	glog.V(2).Infof("Cached %s as %s", url, p)
+	if c.maxSize <= 0 {
+		return nil
+	}
+	return c.Prune(c.maxSize)
+}
+
+// Remove deletes the archive of the url and the checksum
+func (c *Cache) Remove(url, checksum string) error {
+	return c.remove(Key(url, checksum))
+}
+
+func (c *Cache) remove(key string) error {
+	p := c.entryPath(key)
+	for _, f := range []string{p, p + metadataSuffix} {
+		err := os.Remove(f)
+		if err != nil && !os.IsNotExist(err) {
+			glog.Errorf("Cannot remove %s: %v", f, err)
+			return err
+		}
+	}
+	glog.V(2).Infof("Removed cache entry %s", key)
+	return nil
+}
+
+// List returns the cached archives, the most recently used first
+func (c *Cache) List() ([]Entry, error) {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		glog.Errorf("Cannot read the cache directory %s: %v", c.dir, err)
+		return nil, err
+	}
+	var entries []Entry
+	for _, f := range files {
+		if f.IsDir() || strings.Contains(f.Name(), ".") {
+			// skip the metadata and temporary files
+			continue
+		}
+		entry := Entry{}
+		b, err := ioutil.ReadFile(c.entryPath(f.Name()) + metadataSuffix)
+		if err == nil {
+			err = json.Unmarshal(b, &entry)
+		}
+		if err != nil {
+			glog.Warningf("Invalid metadata for the cache entry %s: %v", f.Name(), err)
+		}
+		entry.Key = f.Name()
+		entry.Size = f.Size()
+		entry.LastUsed = f.ModTime()
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Prune evicts the least recently used archives until the cache size is under maxSize,
+// a maxSize < 0 is a no-op and a maxSize of 0 removes everything
+func (c *Cache) Prune(maxSize int64) error {
+	if maxSize < 0 {
+		return nil
+	}
+	entries, err := c.List()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	for i := len(entries) - 1; i >= 0 && total > maxSize; i-- {
+		glog.V(2).Infof("Evicting %s from the cache, last used %s", entries[i].URL, entries[i].LastUsed.Format(time.RFC3339))
+		err = c.remove(entries[i].Key)
+		if err != nil {
+			return err
+		}
+		total -= entries[i].Size
+	}
+	return nil
+}
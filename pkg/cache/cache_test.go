@@ -0,0 +1,80 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bin := path.Join(dir, "bin")
+	require.NoError(t, os.Mkdir(bin, 0755))
+	c := NewCache(path.Join(dir, "cache"), 0)
+
+	entries, err := c.List()
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	found, err := c.Get("https://dl.k8s.io/a.tar.gz", "sha256:aa", path.Join(bin, "a.tar.gz"))
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	for _, name := range []string{"a", "b"} {
+		src := path.Join(bin, name+".tar.gz")
+		require.NoError(t, ioutil.WriteFile(src, []byte(name+name+name), 0400))
+		require.NoError(t, c.Put("https://dl.k8s.io/"+name+".tar.gz", "sha256:"+name+name, src))
+		require.NoError(t, os.Remove(src))
+	}
+
+	// another digest is another entry
+	found, err = c.Get("https://dl.k8s.io/a.tar.gz", "sha256:ab", path.Join(bin, "a.tar.gz"))
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// make "b" the least recently used
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(c.entryPath(Key("https://dl.k8s.io/b.tar.gz", "sha256:bb")), old, old))
+
+	found, err = c.Get("https://dl.k8s.io/a.tar.gz", "sha256:aa", path.Join(bin, "a.tar.gz"))
+	require.NoError(t, err)
+	assert.True(t, found)
+	b, err := ioutil.ReadFile(path.Join(bin, "a.tar.gz"))
+	require.NoError(t, err)
+	assert.Equal(t, "aaa", string(b))
+
+	entries, err = c.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "https://dl.k8s.io/a.tar.gz", entries[0].URL)
+	assert.Equal(t, "sha256:aa", entries[0].Checksum)
+	assert.Equal(t, int64(3), entries[0].Size)
+	assert.Equal(t, "https://dl.k8s.io/b.tar.gz", entries[1].URL)
+
+	require.NoError(t, c.Prune(4))
+	entries, err = c.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "https://dl.k8s.io/a.tar.gz", entries[0].URL)
+
+	// the linked archive survives the eviction
+	require.NoError(t, c.Prune(0))
+	entries, err = c.List()
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+	_, err = os.Stat(path.Join(bin, "a.tar.gz"))
+	assert.NoError(t, err)
+}
@@ -6,6 +6,7 @@
 package config
 
 import (
+	"runtime"
 	"time"
 
 	"github.com/spf13/viper"
@@ -31,35 +32,185 @@ const (
 
 	// CRIContainerd is a container runtime engine
 	CRIContainerd = "containerd"
+
+	// PKINative is the certificate authority implemented in pupernetes
+	PKINative = "native"
+
+	// PKIVault is the certificate authority of a vault started during the setup
+	PKIVault = "vault"
+
+	// CNIBridge is the bridge plugin with the host-local IPAM
+	CNIBridge = "bridge"
+
+	// CNIPtp is the ptp plugin with the host-local IPAM, a veth pair per pod without bridge
+	CNIPtp = "ptp"
+
+	// CNIChained is the bridge plugin chained with the portmap, bandwidth and firewall plugins
+	CNIChained = "chained"
+
+	// KernelPreflightApply loads the missing kernel modules and sets the sysctls during the setup
+	KernelPreflightApply = "apply"
+
+	// KernelPreflightCheck fails the setup on a missing kernel module or sysctl
+	KernelPreflightCheck = "check"
+
+	// KernelPreflightNone skips the kernel preflight
+	KernelPreflightNone = "none"
+
+	// HostDNSNone leaves the DNS of the host unchanged
+	HostDNSNone = "none"
+
+	// HostDNSAuto uses systemd-resolved when it's running, resolv.conf otherwise
+	HostDNSAuto = "auto"
+
+	// HostDNSResolved routes the cluster domain to CoreDNS with a per-link domain of systemd-resolved
+	HostDNSResolved = "resolved"
+
+	// HostDNSResolvConf adds CoreDNS as first nameserver in the resolv.conf of the host
+	HostDNSResolvConf = "resolv-conf"
+
+	// HostnameProviderOverride is the hostname given with --hostname-override
+	HostnameProviderOverride = "override"
+
+	// HostnameProviderOS is the hostname of the kernel
+	HostnameProviderOS = "os"
+
+	// HostnameProviderAWS is the hostname of the AWS instance metadata
+	HostnameProviderAWS = "aws"
+
+	// HostnameProviderGCE is the hostname of the GCE instance metadata
+	HostnameProviderGCE = "gce"
+
+	// HostnameProviderAzure is the name of the virtual machine in the Azure instance metadata service (IMDS)
+	HostnameProviderAzure = "azure"
+
+	// HostnameProviderReverseDNS is the name of the node IP
+	HostnameProviderReverseDNS = "reverse-dns"
 )
 
+// HostnameProviders are the supported hostname providers in their default order
+var HostnameProviders = []string{
+	HostnameProviderOverride,
+	HostnameProviderOS,
+	HostnameProviderAWS,
+	HostnameProviderGCE,
+	HostnameProviderAzure,
+	HostnameProviderReverseDNS,
+}
+
+// DefaultVersions are the default "<component>-version" of the downloaded components
+var DefaultVersions = map[string]string{
+	"hyperkube":  templates.KubeTaggedVersions["latest"],
+	"vault":      "0.9.5",
+	"etcd":       "3.4.7",
+	"cni":        "0.8.1",
+	"containerd": "1.1.3",
+	"runc":       "1.0.0-rc5",
+}
+
+// ArchDefaultVersions replace the DefaultVersions not published for the arch
+var ArchDefaultVersions = map[string]map[string]string{
+	"arm64": {
+		"containerd": "1.6.0",
+		"runc":       "1.0.0",
+	},
+}
+
+// GetVersion returns the "<component>-version" of the arch, the default one is replaced
+// by the default of the arch unless the archive comes from a "<component>-url"
+func GetVersion(component, arch string) string {
+	version := ViperConfig.GetString(component + "-version")
+	archVersion, ok := ArchDefaultVersions[arch][component]
+	if !ok || version != DefaultVersions[component] || ViperConfig.GetString(component+"-url") != "" {
+		return version
+	}
+	return archVersion
+}
+
 func init() {
 	ViperConfig.SetDefault("version", false)
 
 	ViperConfig.SetDefault("skip-binaries-version", false)
-	ViperConfig.SetDefault("hyperkube-version", templates.KubeTaggedVersions["latest"])
-	ViperConfig.SetDefault("vault-version", "0.9.5")
-	ViperConfig.SetDefault("etcd-version", "3.4.7")
-	ViperConfig.SetDefault("cni-version", "0.8.1")
-	ViperConfig.SetDefault("containerd-version", "1.1.3")
-	ViperConfig.SetDefault("runc-version", "1.0.0-rc5")
+	for component, version := range DefaultVersions {
+		ViperConfig.SetDefault(component+"-version", version)
+	}
+
+	// Architecture of the binaries and images, like amd64 or arm64
+	ViperConfig.SetDefault("arch", runtime.GOARCH)
+
+	// Digests like sha256:<hex>, sha512:<hex> or URL of a checksum file,
+	// empty means the upstream checksum file
+	ViperConfig.SetDefault("hyperkube-checksum", "")
+	ViperConfig.SetDefault("vault-checksum", "")
+	ViperConfig.SetDefault("etcd-checksum", "")
+	ViperConfig.SetDefault("cni-checksum", "")
+	ViperConfig.SetDefault("containerd-checksum", "")
+	ViperConfig.SetDefault("runc-checksum", "")
+	ViperConfig.SetDefault("require-checksums", false)
+
+	// Base URL of a mirror of the upstream layout, like http://mirror.local/p8s or file:///srv/p8s
+	ViperConfig.SetDefault("mirror", "")
+	// URL templates overriding the archive locations, like file:///srv/etcd-v{{.Version}}-linux-{{.Arch}}.tar.gz
+	ViperConfig.SetDefault("hyperkube-url", "")
+	ViperConfig.SetDefault("vault-url", "")
+	ViperConfig.SetDefault("etcd-url", "")
+	ViperConfig.SetDefault("cni-url", "")
+	ViperConfig.SetDefault("containerd-url", "")
+	ViperConfig.SetDefault("runc-url", "")
 
 	ViperConfig.SetDefault("container-runtime", "docker")
 
 	ViperConfig.SetDefault("download-timeout", time.Minute*30)
+	ViperConfig.SetDefault("download-retries", 3)
+	ViperConfig.SetDefault("download-retry-delay", time.Second*5)
+	ViperConfig.SetDefault("cache-dir", "/var/cache/pupernetes")
+	ViperConfig.SetDefault("cache-max-size", "10GB")
+	ViperConfig.SetDefault("cache-prune-all", false)
 
 	ViperConfig.SetDefault("kubernetes-cluster-ip-range", "192.168.254.0/24")
 	ViperConfig.SetDefault("pod-ip-range", "192.168.253.0/24")
+	ViperConfig.SetDefault("node-ip", "")
+	ViperConfig.SetDefault("node-interface", "")
+	ViperConfig.SetDefault("upstream-nameservers", []string{})
+	ViperConfig.SetDefault("cluster-domain", "cluster.local")
+	ViperConfig.SetDefault("dns-stub-domains", []string{})
+	ViperConfig.SetDefault("dns-hosts", []string{})
+	ViperConfig.SetDefault("host-dns", HostDNSNone)
+	ViperConfig.SetDefault("http-proxy", "")
+	ViperConfig.SetDefault("https-proxy", "")
+	ViperConfig.SetDefault("no-proxy", []string{})
+	ViperConfig.SetDefault("hostname-override", "")
+	ViperConfig.SetDefault("hostname-providers", HostnameProviders)
+	ViperConfig.SetDefault("aws-metadata-endpoint", "http://169.254.169.254/latest/meta-data")
+	ViperConfig.SetDefault("gce-metadata-endpoint", "http://169.254.169.254/computeMetadata/v1")
+	ViperConfig.SetDefault("azure-metadata-endpoint", "http://169.254.169.254/metadata/instance")
+	ViperConfig.SetDefault("cni-plugin", CNIBridge)
+	ViperConfig.SetDefault("cni-conflist", "")
+	ViperConfig.SetDefault("kernel-preflight", KernelPreflightCheck)
 	ViperConfig.SetDefault("bind-address", defaultAPIAddress)
 	ViperConfig.SetDefault("api-address", defaultAPIAddress)
+	ViperConfig.SetDefault("api-socket", "")
+	ViperConfig.SetDefault("api-socket-mode", "0660")
+	ViperConfig.SetDefault("api-tls-address", "")
+	ViperConfig.SetDefault("api-token-file", "")
+	ViperConfig.SetDefault("api-apply-root", "")
+	ViperConfig.SetDefault("api-tls-certificate", "")
+	ViperConfig.SetDefault("api-tls-private-key", "")
+	ViperConfig.SetDefault("api-tls-ca", "")
 	ViperConfig.SetDefault("kubelet-root-dir", "/var/lib/p8s-kubelet")
 	ViperConfig.SetDefault("systemd-unit-prefix", "p8s-")
 
 	ViperConfig.SetDefault("kubectl-link", "")
+	ViperConfig.SetDefault("pki-backend", PKINative)
+	ViperConfig.SetDefault("ca-ttl", time.Hour*87600)
+	ViperConfig.SetDefault("certificate-ttl", time.Hour*8760)
+	ViperConfig.SetDefault("ca-certificate", "")
+	ViperConfig.SetDefault("ca-private-key", "")
+	ViperConfig.SetDefault("extra-sans", []string{})
 	ViperConfig.SetDefault("vault-root-token", "")
 	ViperConfig.SetDefault("vault-listen-address", "127.0.0.1:8201")
 
-	ViperConfig.SetDefault("clean", "etcd,kubelet,logs,mounts,iptables")
+	ViperConfig.SetDefault("clean", "etcd,kubelet,logs,mounts,iptables,kernel,dns")
 	ViperConfig.SetDefault("keep", "")
 	ViperConfig.SetDefault("drain", "all")
 	ViperConfig.SetDefault("skip-probes", false)
@@ -71,12 +222,22 @@ func init() {
 	ViperConfig.SetDefault("systemd-job-name", "pupernetes")
 
 	ViperConfig.SetDefault("apply", false)
+	ViperConfig.SetDefault("reset-include", []string{})
+	ViperConfig.SetDefault("reset-exclude", []string{})
+	ViperConfig.SetDefault("reset-selector", "")
+	ViperConfig.SetDefault("reset-timeout", time.Minute)
 
 	ViperConfig.SetDefault("logging-since", time.Minute*5)
 	ViperConfig.SetDefault("unit-to-watch", "pupernetes.service")
+	ViperConfig.SetDefault("wait-events", false)
+	ViperConfig.SetDefault("apply-filename", "")
+	ViperConfig.SetDefault("apply-path", "")
+	ViperConfig.SetDefault("apply-dry-run", false)
+	ViperConfig.SetDefault("apply-prune-selector", "")
+	ViperConfig.SetDefault("apply-timeout", time.Minute)
 	ViperConfig.SetDefault("wait-timeout", time.Minute*15)
 	ViperConfig.SetDefault("client-timeout", time.Minute*1)
 	ViperConfig.SetDefault("kubeconfig-path", "")
-	ViperConfig.SetDefault("dns-queries", []string{"coredns.kube-system.svc.cluster.local."})
+	ViperConfig.SetDefault("dns-queries", []string{})
 	ViperConfig.SetDefault("dns-check", false)
 }
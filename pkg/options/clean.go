@@ -33,6 +33,8 @@ type Clean struct {
 	Mounts    bool `json:"mounts,omitempty"`
 	Iptables  bool `json:"iptables,omitempty"`
 	Logs      bool `json:"logs,omitempty"`
+	Kernel    bool `json:"kernel,omitempty"`
+	DNS       bool `json:"dns,omitempty"`
 }
 
 // NewCleanOptions instantiate a new Clean from the cleanString and keepString
@@ -62,6 +64,8 @@ func NewCleanOptions(cleanString, keepString string) *Clean {
 		Mounts:    opts.Has("mounts"),
 		Iptables:  opts.Has("iptables"),
 		Logs:      opts.Has("logs"),
+		Kernel:    opts.Has("kernel"),
+		DNS:       opts.Has("dns"),
 	}
 }
 
@@ -37,6 +37,8 @@ func TestNewCleanOptions(t *testing.T) {
 				true,
 				true,
 				true,
+				true,
+				true,
 			},
 			"all",
 		},
@@ -59,6 +61,8 @@ func TestNewCleanOptions(t *testing.T) {
 				false,
 				false,
 				false,
+				false,
+				false,
 			},
 			"",
 		},
@@ -81,6 +85,8 @@ func TestNewCleanOptions(t *testing.T) {
 				true,
 				true,
 				true,
+				true,
+				true,
 			},
 			"all",
 		},
@@ -103,6 +109,8 @@ func TestNewCleanOptions(t *testing.T) {
 				true,
 				true,
 				true,
+				true,
+				true,
 			},
 			"all",
 		},
@@ -125,6 +133,8 @@ func TestNewCleanOptions(t *testing.T) {
 				false,
 				false,
 				false,
+				false,
+				false,
 			},
 			"etcd",
 		},
@@ -147,6 +157,8 @@ func TestNewCleanOptions(t *testing.T) {
 				true,
 				true,
 				true,
+				true,
+				true,
 			},
 			"all",
 		},
@@ -169,6 +181,8 @@ func TestNewCleanOptions(t *testing.T) {
 				false,
 				false,
 				false,
+				false,
+				false,
 			},
 			"binaries,etcd",
 		},
@@ -191,6 +205,8 @@ func TestNewCleanOptions(t *testing.T) {
 				false,
 				false,
 				false,
+				false,
+				false,
 			},
 			"binaries,etcd,secrets",
 		},
@@ -213,8 +229,10 @@ func TestNewCleanOptions(t *testing.T) {
 				true,
 				true,
 				true,
+				true,
+				true,
 			},
-			"etcd,iptables,kubectl,kubelet,logs,manifests,mounts,network,secrets,systemd",
+			"dns,etcd,iptables,kernel,kubectl,kubelet,logs,manifests,mounts,network,secrets,systemd",
 		},
 		{
 			"etcd",
@@ -235,8 +253,10 @@ func TestNewCleanOptions(t *testing.T) {
 				true,
 				true,
 				true,
+				true,
+				true,
 			},
-			"etcd,iptables,kubectl,kubelet,logs,manifests,mounts,network,secrets,systemd",
+			"dns,etcd,iptables,kernel,kubectl,kubelet,logs,manifests,mounts,network,secrets,systemd",
 		},
 		{
 			"none,etcd",
@@ -257,6 +277,8 @@ func TestNewCleanOptions(t *testing.T) {
 				false,
 				false,
 				false,
+				false,
+				false,
 			},
 			"",
 		},
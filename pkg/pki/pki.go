@@ -0,0 +1,402 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// CertificateSuffix is the file suffix of the PEM certificate
+	CertificateSuffix = "certificate"
+	// IssuingCASuffix is the file suffix of the PEM certificate of the issuer
+	IssuingCASuffix = "issuing_ca"
+	// PrivateKeySuffix is the file suffix of the PEM private key
+	PrivateKeySuffix = "private_key"
+
+	// PrivateKeyPerm is the mode of the private key files, only readable by their owner
+	PrivateKeyPerm os.FileMode = 0400
+
+	rsaKeySize = 2048
+
+	// the clocks of the components may be slightly behind
+	notBeforeSkew = time.Minute
+)
+
+// Suffixes are the files written for each key pair: <name>.certificate, <name>.issuing_ca and <name>.private_key
+var Suffixes = []string{CertificateSuffix, IssuingCASuffix, PrivateKeySuffix}
+
+// KeyPair is a certificate, its private key and the certificate of its issuer
+type KeyPair struct {
+	Certificate *x509.Certificate
+	PrivateKey  *rsa.PrivateKey
+
+	CertificatePEM []byte
+	IssuingCAPEM   []byte
+	PrivateKeyPEM  []byte
+}
+
+// CA is a certificate authority able to issue leaf certificates
+type CA struct {
+	*KeyPair
+}
+
+// Info describes a certificate
+type Info struct {
+	Name        string    `json:"name"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+}
+
+// Describe returns the Info of the certificate written as name
+func Describe(name string, cert *x509.Certificate) *Info {
+	info := &Info{
+		Name:      name,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		DNSNames:  cert.DNSNames,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
+
+// Request describes a leaf certificate to issue
+type Request struct {
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	IPAddresses  []net.IP
+	TTL          time.Duration
+	// ExtKeyUsages default to server and client auth
+	ExtKeyUsages []x509.ExtKeyUsage
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodeCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func encodePrivateKey(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// NewRootCA generates a self signed certificate authority
+func NewRootCA(commonName string, ttl time.Duration) (*CA, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+	if err != nil {
+		glog.Errorf("Cannot generate the private key of the CA %s: %v", commonName, err)
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-notBeforeSkew),
+		NotAfter:              now.Add(ttl),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		glog.Errorf("Cannot create the certificate of the CA %s: %v", commonName, err)
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := encodeCertificate(cert)
+	return &CA{
+		KeyPair: &KeyPair{
+			Certificate:    cert,
+			PrivateKey:     key,
+			CertificatePEM: certPEM,
+			IssuingCAPEM:   certPEM,
+			PrivateKeyPEM:  encodePrivateKey(key),
+		},
+	}, nil
+}
+
+// Issue signs a new leaf certificate
+func (ca *CA) Issue(req *Request) (*KeyPair, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+	if err != nil {
+		glog.Errorf("Cannot generate the private key of %s: %v", req.CommonName, err)
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	extKeyUsages := req.ExtKeyUsages
+	if len(extKeyUsages) == 0 {
+		extKeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	now := time.Now()
+	notAfter := now.Add(req.TTL)
+	if notAfter.After(ca.Certificate.NotAfter) {
+		glog.Warningf("Capping the expiration of %s to the one of its CA: %s", req.CommonName, ca.Certificate.NotAfter.Format(time.RFC3339))
+		notAfter = ca.Certificate.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   req.CommonName,
+			Organization: req.Organization,
+		},
+		DNSNames:              req.DNSNames,
+		IPAddresses:           req.IPAddresses,
+		NotBefore:             now.Add(-notBeforeSkew),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           extKeyUsages,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		glog.Errorf("Cannot create the certificate of %s: %v", req.CommonName, err)
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{
+		Certificate:    cert,
+		PrivateKey:     key,
+		CertificatePEM: encodeCertificate(cert),
+		IssuingCAPEM:   ca.CertificatePEM,
+		PrivateKeyPEM:  encodePrivateKey(key),
+	}, nil
+}
+
+// FilePath returns the path of the PEM file of the key pair name with the given suffix
+func FilePath(dir, name, suffix string) string {
+	return path.Join(dir, fmt.Sprintf("%s.%s", name, suffix))
+}
+
+// writeFile replaces the content of a possibly read only file
+func writeFile(filePath string, b []byte, perm os.FileMode) error {
+	err := os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(filePath, b, perm)
+}
+
+// FilePerm returns the mode of the file with the given suffix,
+// the private key is never readable by the others whatever the perm
+func FilePerm(suffix string, perm os.FileMode) os.FileMode {
+	if suffix == PrivateKeySuffix {
+		return PrivateKeyPerm
+	}
+	return perm
+}
+
+// RestrictPrivateKeyFile sets PrivateKeyPerm on the <name>.private_key in dir,
+// the keys written by the previous releases were readable by everyone
+func RestrictPrivateKeyFile(dir, name string) error {
+	p := FilePath(dir, name, PrivateKeySuffix)
+	err := os.Chmod(p, PrivateKeyPerm)
+	if err != nil {
+		glog.Errorf("Cannot restrict the mode of %s: %v", p, err)
+		return err
+	}
+	return nil
+}
+
+// WriteFiles writes <name>.certificate, <name>.issuing_ca with perm and <name>.private_key with PrivateKeyPerm in dir
+func (k *KeyPair) WriteFiles(dir, name string, perm os.FileMode) error {
+	for suffix, b := range map[string][]byte{
+		CertificateSuffix: k.CertificatePEM,
+		IssuingCASuffix:   k.IssuingCAPEM,
+		PrivateKeySuffix:  k.PrivateKeyPEM,
+	} {
+		p := FilePath(dir, name, suffix)
+		err := writeFile(p, b, FilePerm(suffix, perm))
+		if err != nil {
+			glog.Errorf("Cannot write %s: %v", p, err)
+			return err
+		}
+		glog.V(4).Infof("Successfully created %s", p)
+	}
+	return nil
+}
+
+// ParseCertificatePEM returns the first certificate of the PEM content
+func ParseCertificatePEM(b []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("cannot find any PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// ParsePrivateKeyPEM returns the RSA private key of the PKCS1 or PKCS8 PEM content
+func ParsePrivateKeyPEM(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("cannot find any PEM private key")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T, must be RSA", key)
+		}
+		return rsaKey, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// LoadCA reads the CA <name>.certificate and <name>.private_key written in dir
+func LoadCA(dir, name string) (*CA, error) {
+	return LoadCAFiles(FilePath(dir, name, CertificateSuffix), FilePath(dir, name, PrivateKeySuffix))
+}
+
+// LoadCAFiles reads the CA from the given PEM certificate and private key files
+func LoadCAFiles(certificatePath, privateKeyPath string) (*CA, error) {
+	certPEM, err := ioutil.ReadFile(certificatePath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCA(certPEM, keyPEM)
+}
+
+// ParseCA returns the CA of the PEM certificate and private key
+func ParseCA(certPEM, keyPEM []byte) (*CA, error) {
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		glog.Errorf("Invalid certificate for the CA: %v", err)
+		return nil, err
+	}
+	if !cert.IsCA {
+		err = fmt.Errorf("the certificate %q isn't a CA", cert.Subject.CommonName)
+		glog.Errorf("Invalid CA: %v", err)
+		return nil, err
+	}
+	key, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		glog.Errorf("Invalid private key for the CA %q: %v", cert.Subject.CommonName, err)
+		return nil, err
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || pub.N.Cmp(key.N) != 0 {
+		err = fmt.Errorf("the private key of %q doesn't match its certificate", cert.Subject.CommonName)
+		glog.Errorf("Invalid CA: %v", err)
+		return nil, err
+	}
+	// the supplied PEM may hold a chain or a PKCS8 key, keep only the CA in PKCS1
+	certPEM = encodeCertificate(cert)
+	return &CA{
+		KeyPair: &KeyPair{
+			Certificate:    cert,
+			PrivateKey:     key,
+			CertificatePEM: certPEM,
+			IssuingCAPEM:   certPEM,
+			PrivateKeyPEM:  encodePrivateKey(key),
+		},
+	}, nil
+}
+
+// LoadKeyPair reads the <name>.certificate, <name>.issuing_ca and <name>.private_key written in dir
+func LoadKeyPair(dir, name string) (*KeyPair, error) {
+	kp := &KeyPair{}
+	var err error
+	kp.CertificatePEM, err = ioutil.ReadFile(FilePath(dir, name, CertificateSuffix))
+	if err != nil {
+		return nil, err
+	}
+	kp.IssuingCAPEM, err = ioutil.ReadFile(FilePath(dir, name, IssuingCASuffix))
+	if err != nil {
+		return nil, err
+	}
+	kp.PrivateKeyPEM, err = ioutil.ReadFile(FilePath(dir, name, PrivateKeySuffix))
+	if err != nil {
+		return nil, err
+	}
+	kp.Certificate, err = ParseCertificatePEM(kp.CertificatePEM)
+	if err != nil {
+		return nil, err
+	}
+	kp.PrivateKey, err = ParsePrivateKeyPEM(kp.PrivateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// Verify returns an error if the key pair isn't a valid certificate of the CA matching the request
+func (ca *CA) Verify(kp *KeyPair, req *Request) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate)
+	_, err := kp.Certificate.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return err
+	}
+	if kp.Certificate.Subject.CommonName != req.CommonName {
+		return fmt.Errorf("unexpected common name %q, want %q", kp.Certificate.Subject.CommonName, req.CommonName)
+	}
+	if strings.Join(kp.Certificate.Subject.Organization, ",") != strings.Join(req.Organization, ",") {
+		return fmt.Errorf("unexpected organization %q, want %q", kp.Certificate.Subject.Organization, req.Organization)
+	}
+	for _, name := range req.DNSNames {
+		err = kp.Certificate.VerifyHostname(name)
+		if err != nil {
+			return err
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		err = kp.Certificate.VerifyHostname(ip.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
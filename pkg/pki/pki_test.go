@@ -0,0 +1,133 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssue(t *testing.T) {
+	ca, err := NewRootCA("p8s", time.Hour*24)
+	require.NoError(t, err)
+	assert.True(t, ca.Certificate.IsCA)
+	assert.Equal(t, "p8s", ca.Certificate.Subject.CommonName)
+	assert.Equal(t, ca.CertificatePEM, ca.IssuingCAPEM)
+
+	kp, err := ca.Issue(&Request{
+		CommonName:  "p8s",
+		DNSNames:    []string{"localhost", "p8s"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("192.168.254.1")},
+		TTL:         time.Hour,
+	})
+	require.NoError(t, err)
+	assert.False(t, kp.Certificate.IsCA)
+	assert.Equal(t, ca.CertificatePEM, kp.IssuingCAPEM)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, kp.Certificate.ExtKeyUsage)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), kp.Certificate.NotAfter, time.Minute)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate)
+	_, err = kp.Certificate.Verify(x509.VerifyOptions{
+		DNSName:   "localhost",
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, kp.Certificate.VerifyHostname("192.168.254.1"))
+	assert.Error(t, kp.Certificate.VerifyHostname("10.0.0.1"))
+
+	_, err = tls.X509KeyPair(kp.CertificatePEM, kp.PrivateKeyPEM)
+	assert.NoError(t, err)
+}
+
+func TestIssueCappedToCA(t *testing.T) {
+	ca, err := NewRootCA("p8s", time.Hour)
+	require.NoError(t, err)
+	kp, err := ca.Issue(&Request{CommonName: "p8s", TTL: time.Hour * 24})
+	require.NoError(t, err)
+	assert.Equal(t, ca.Certificate.NotAfter, kp.Certificate.NotAfter)
+}
+
+func TestWriteAndLoadCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-pki")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, err := NewRootCA("p8s", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, ca.WriteFiles(dir, "pupernetes", 0400))
+	// the read only files are replaced
+	require.NoError(t, ca.WriteFiles(dir, "pupernetes", 0400))
+
+	for _, suffix := range Suffixes {
+		_, err = os.Stat(FilePath(dir, "pupernetes", suffix))
+		assert.NoError(t, err)
+	}
+
+	loaded, err := LoadCA(dir, "pupernetes")
+	require.NoError(t, err)
+	assert.True(t, ca.Certificate.Equal(loaded.Certificate))
+	assert.Equal(t, 0, ca.PrivateKey.N.Cmp(loaded.PrivateKey.N))
+
+	kp, err := ca.Issue(&Request{CommonName: "p8s", TTL: time.Minute})
+	require.NoError(t, err)
+	require.NoError(t, kp.WriteFiles(dir, "leaf", 0444))
+	for suffix, perm := range map[string]os.FileMode{
+		CertificateSuffix: 0444,
+		IssuingCASuffix:   0444,
+		PrivateKeySuffix:  0400,
+	} {
+		fi, err := os.Stat(FilePath(dir, "leaf", suffix))
+		require.NoError(t, err)
+		assert.Equal(t, perm, fi.Mode().Perm(), suffix)
+	}
+	_, err = LoadCA(dir, "leaf")
+	assert.Error(t, err)
+
+	_, err = LoadCA(dir, "missing")
+	assert.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-pki")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, err := NewRootCA("p8s", time.Hour)
+	require.NoError(t, err)
+	req := &Request{
+		CommonName:  "p8s",
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		TTL:         time.Minute,
+	}
+	kp, err := ca.Issue(req)
+	require.NoError(t, err)
+	require.NoError(t, kp.WriteFiles(dir, "etcd", 0444))
+
+	loaded, err := LoadKeyPair(dir, "etcd")
+	require.NoError(t, err)
+	assert.NoError(t, ca.Verify(loaded, req))
+
+	// the node IP changed
+	assert.Error(t, ca.Verify(loaded, &Request{
+		CommonName:  "p8s",
+		IPAddresses: []net.IP{net.ParseIP("192.168.1.1")},
+	}))
+
+	other, err := NewRootCA("p8s", time.Hour)
+	require.NoError(t, err)
+	assert.Error(t, other.Verify(loaded, req))
+}
@@ -0,0 +1,399 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/DataDog/pupernetes/pkg/api"
+)
+
+// applyObject is a single object of the manifests
+type applyObject struct {
+	kind      string
+	namespace string
+	name      string
+	labels    labels.Set
+	content   []byte
+}
+
+// getString returns the string at the given keys of the decoded object
+func getString(obj map[string]interface{}, keys ...string) string {
+	var v interface{} = obj
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[key]
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// newApplyObjects returns the object, or the items of a List,
+// the errors don't contain the content of the manifests, like the secrets
+func newApplyObjects(obj map[string]interface{}) ([]applyObject, error) {
+	kind := getString(obj, "kind")
+	if kind == "" {
+		return nil, fmt.Errorf("missing kind")
+	}
+	items, isList := obj["items"].([]interface{})
+	if isList && strings.HasSuffix(kind, "List") {
+		var objects []applyObject
+		for i, item := range items {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid item %d in the %s", i, kind)
+			}
+			itemObjects, err := newApplyObjects(m)
+			if err != nil {
+				return nil, fmt.Errorf("item %d in the %s: %v", i, kind, err)
+			}
+			objects = append(objects, itemObjects...)
+		}
+		return objects, nil
+	}
+	name := getString(obj, "metadata", "name")
+	if name == "" {
+		return nil, fmt.Errorf("missing metadata.name in the %s", kind)
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	objLabels := labels.Set{}
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		m, _ := metadata["labels"].(map[string]interface{})
+		for k, v := range m {
+			objLabels[k], _ = v.(string)
+		}
+	}
+	return []applyObject{{
+		kind:      kind,
+		namespace: getString(obj, "metadata", "namespace"),
+		name:      name,
+		labels:    objLabels,
+		content:   b,
+	}}, nil
+}
+
+// splitManifests returns the objects of the YAML or JSON documents
+func splitManifests(manifests []byte) ([]applyObject, error) {
+	var objects []applyObject
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifests), 4096)
+	for doc := 1; ; doc++ {
+		var obj map[string]interface{}
+		err := decoder.Decode(&obj)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode the document %d of the manifests: %v", doc, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		docObjects, err := newApplyObjects(obj)
+		if err != nil {
+			return nil, fmt.Errorf("invalid document %d of the manifests: %v", doc, err)
+		}
+		objects = append(objects, docObjects...)
+	}
+}
+
+// parseApplyAction returns the action reported by kubectl apply after the object reference
+func parseApplyAction(output string) string {
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return api.ApplyUnknown
+	}
+	switch fields[0] {
+	case api.ApplyCreated, api.ApplyConfigured, api.ApplyUnchanged, api.ApplyPruned:
+		return fields[0]
+	}
+	return api.ApplyUnknown
+}
+
+// appliedObject is an object reported by kubectl apply
+type appliedObject struct {
+	kind   string
+	name   string
+	action string
+}
+
+// matches returns true if the reported object is the given one, the reported kind is lower case with its group
+func (a *appliedObject) matches(obj *applyObject) bool {
+	kind := a.kind
+	if i := strings.Index(kind, "."); i != -1 {
+		kind = kind[:i]
+	}
+	return a.name == obj.name && strings.EqualFold(kind, obj.kind)
+}
+
+// parseApplyOutput returns the objects reported by kubectl apply in the order of the manifests, like:
+// - deployment.apps/nginx configured (server dry run)
+// - deployment "nginx" pruned
+func parseApplyOutput(output string) []appliedObject {
+	var objects []appliedObject
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		obj := appliedObject{}
+		if j := strings.LastIndex(fields[0], "/"); j != -1 {
+			obj.kind = fields[0][:j]
+			obj.name = fields[0][j+1:]
+			obj.action = parseApplyAction(strings.Join(fields[1:], " "))
+		} else if len(fields) >= 2 && strings.HasPrefix(fields[1], `"`) {
+			obj.kind = fields[0]
+			obj.name = strings.Trim(fields[1], `"`)
+			obj.action = parseApplyAction(strings.Join(fields[2:], " "))
+		} else {
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+// getObjectErrors returns the lines of the kubectl errors about the object and marks them as attributed
+func getObjectErrors(lines []string, attributed []bool, obj *applyObject) string {
+	var objectLines []string
+	for i, line := range lines {
+		if strings.Contains(line, `"`+obj.name+`"`) || strings.Contains(line, "/"+obj.name+" ") {
+			objectLines = append(objectLines, line)
+			attributed[i] = true
+		}
+	}
+	return strings.Join(objectLines, "\n")
+}
+
+// newObjectResults returns the result of each object applied by a single kubectl apply and the pruned objects,
+// kubectl reports the applied objects in the order of the manifests and the errors in its stderr.
+// The errors about none of the objects, like a connection refused, are returned once for the whole apply
+// and the objects not reported are unknown
+func newObjectResults(objects []applyObject, output, errors string) ([]api.ObjectResult, []string) {
+	applied := parseApplyOutput(output)
+	var errorLines []string
+	for _, line := range strings.Split(errors, "\n") {
+		if strings.TrimSpace(line) != "" {
+			errorLines = append(errorLines, line)
+		}
+	}
+	attributed := make([]bool, len(errorLines))
+	results := make([]api.ObjectResult, 0, len(objects))
+	next := 0
+	for i := range objects {
+		obj := &objects[i]
+		res := api.ObjectResult{
+			Kind:      obj.kind,
+			Namespace: obj.namespace,
+			Name:      obj.name,
+			Result:    api.ApplyUnknown,
+		}
+		reported := false
+		for j := next; j < len(applied); j++ {
+			if applied[j].action != api.ApplyPruned && applied[j].matches(obj) {
+				res.Result = applied[j].action
+				next = j + 1
+				reported = true
+				break
+			}
+		}
+		if !reported {
+			res.Error = getObjectErrors(errorLines, attributed, obj)
+			if res.Error != "" {
+				res.Result = api.ApplyError
+			}
+		}
+		results = append(results, res)
+	}
+	for _, a := range applied {
+		if a.action == api.ApplyPruned {
+			results = append(results, api.ObjectResult{Kind: a.kind, Name: a.name, Result: api.ApplyPruned})
+		}
+	}
+	var requestErrors []string
+	for i, line := range errorLines {
+		if !attributed[i] {
+			requestErrors = append(requestErrors, line)
+		}
+	}
+	if len(requestErrors) == 0 {
+		return results, nil
+	}
+	return results, []string{strings.Join(requestErrors, "\n")}
+}
+
+// splitBySelector returns the objects not matching the selector and the matching ones
+func splitBySelector(objects []applyObject, selector labels.Selector) ([]applyObject, []applyObject) {
+	var others, matching []applyObject
+	for _, obj := range objects {
+		if selector.Matches(obj.labels) {
+			matching = append(matching, obj)
+			continue
+		}
+		others = append(others, obj)
+	}
+	return others, matching
+}
+
+// isPathInDirectory returns true if the path is the directory or in it, both are absolute and without symlinks
+func isPathInDirectory(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// getAllowedPath returns the path without symlinks if it's in one of the directories,
+// pupernetes runs as root and the API may not be authenticated
+func getAllowedPath(p string, dirs ...string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		resolvedDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			glog.V(4).Infof("Cannot resolve the directory %s: %v", dir, err)
+			continue
+		}
+		if isPathInDirectory(resolved, resolvedDir) {
+			return resolved, nil
+		}
+	}
+	return "", api.ErrPathNotAllowed
+}
+
+// kubectlApply applies the objects at once and returns their results with the errors about none of them
+func (r *Runtime) kubectlApply(ctx context.Context, objects []applyObject, flags ...string) ([]api.ObjectResult, []string, error) {
+	items := make([]json.RawMessage, 0, len(objects))
+	for _, obj := range objects {
+		items = append(items, obj.content)
+	}
+	list, err := json.Marshal(map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": items})
+	if err != nil {
+		return nil, nil, err
+	}
+	args := append([]string{"kubectl", "--kubeconfig", r.env.GetKubeconfigInsecurePath(), "apply", "-f", "-"}, flags...)
+	cmd := exec.CommandContext(ctx, r.env.GetHyperkubePath(), args...)
+	cmd.Stdin = bytes.NewReader(list)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	if err != nil {
+		glog.Warningf("Unexpected kubectl apply error on %d objects: %v: %s", len(objects), err, strings.TrimSpace(stderr.String()))
+	}
+	results, errs := newObjectResults(objects, stdout.String(), strings.TrimSpace(stderr.String()))
+	return results, errs, nil
+}
+
+// ApplyManifests applies the objects of the request and returns their results, used by the API.
+// The objects matching the prune selector are applied with --prune, the others without it
+func (r *Runtime) ApplyManifests(req *api.ApplyRequest) (*api.ApplyResult, error) {
+	if !r.state.IsReady() {
+		return nil, api.ErrNotReady
+	}
+	manifests := req.Manifests
+	source := "the request body"
+	if len(manifests) == 0 {
+		manifestsPath := r.env.GetManifestsPathToApply()
+		if req.Path != "" {
+			var err error
+			manifestsPath, err = getAllowedPath(req.Path, r.env.GetManifestsPathToApply(), r.conf.APIApplyRoot)
+			if err != nil {
+				glog.Errorf("Cannot apply %s: %v", req.Path, err)
+				return nil, err
+			}
+		}
+		source = manifestsPath
+		var err error
+		manifests, err = api.ReadManifests(manifestsPath)
+		if err != nil {
+			glog.Errorf("Cannot read the manifests in %s: %v", manifestsPath, err)
+			return nil, err
+		}
+	}
+	objects, err := splitManifests(manifests)
+	if err != nil {
+		glog.Errorf("Cannot parse the manifests of %s: %v", source, err)
+		return nil, err
+	}
+	var flags []string
+	if req.DryRun {
+		flags, err = r.env.GetKubectlServerDryRunFlags()
+		if err != nil {
+			return nil, err
+		}
+	}
+	toApply, toPrune := objects, []applyObject(nil)
+	if req.PruneSelector != "" {
+		selector, err := labels.Parse(req.PruneSelector)
+		if err != nil {
+			return nil, err
+		}
+		toApply, toPrune = splitBySelector(objects, selector)
+		if len(toPrune) == 0 {
+			// kubectl apply --prune fails without objects, pruning everything would be unexpected
+			glog.Warningf("No object of %s matches %s, skipping the prune", source, req.PruneSelector)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout)
+	defer cancel()
+
+	glog.Infof("Applying %d objects of %s ...", len(objects), source)
+	result := &api.ApplyResult{DryRun: req.DryRun, Objects: []api.ObjectResult{}}
+	if len(toApply) > 0 {
+		results, errs, err := r.kubectlApply(ctx, toApply, flags...)
+		if err != nil {
+			glog.Errorf("Cannot apply the objects of %s: %v", source, err)
+			return nil, err
+		}
+		result.Objects = append(result.Objects, results...)
+		result.Errors = append(result.Errors, errs...)
+	}
+	if len(toPrune) > 0 {
+		results, errs, err := r.kubectlApply(ctx, toPrune, append(flags, "--prune", "--selector", req.PruneSelector)...)
+		if err != nil {
+			glog.Errorf("Cannot apply the objects of %s with the prune of %s: %v", source, req.PruneSelector, err)
+			return nil, err
+		}
+		result.Objects = append(result.Objects, results...)
+		result.Errors = append(result.Errors, errs...)
+	}
+	failed := result.Failed()
+	glog.Infof("Applied %d objects of %s, %d failed", len(result.Objects)-failed, source, failed)
+	for _, e := range result.Errors {
+		glog.Errorf("Unexpected kubectl apply error on %s: %s", source, e)
+	}
+	if !req.DryRun && failed == 0 && len(result.Errors) == 0 {
+		r.events.Publish(api.EventManifestsApplied, map[string]string{"path": source, "objects": strconv.Itoa(len(result.Objects))})
+	}
+	return result, nil
+}
@@ -0,0 +1,177 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package run
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/DataDog/pupernetes/pkg/api"
+)
+
+func TestSplitManifests(t *testing.T) {
+	objects, err := splitManifests([]byte(`
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: one
+  namespace: default
+---
+---
+{"apiVersion": "v1", "kind": "List", "items": [
+  {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "two"}},
+  {"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "three", "namespace": "kube-system"}}
+]}
+`))
+	require.NoError(t, err)
+	require.Len(t, objects, 3)
+	assert.Equal(t, "ConfigMap", objects[0].kind)
+	assert.Equal(t, "default", objects[0].namespace)
+	assert.Equal(t, "one", objects[0].name)
+	assert.Equal(t, "Service", objects[1].kind)
+	assert.Equal(t, "", objects[1].namespace)
+	assert.Equal(t, "three", objects[2].name)
+	assert.Contains(t, string(objects[2].content), `"kube-system"`)
+
+	_, err = splitManifests([]byte("apiVersion: v1\nkind: ConfigMap\nmetadata: {}\n"))
+	assert.Error(t, err)
+	_, err = splitManifests([]byte("apiVersion: v1\nmetadata:\n  name: one\n"))
+	assert.Error(t, err)
+
+	// the content isn't in the errors
+	_, err = splitManifests([]byte("kind: ConfigMap\nmetadata:\n  name: one\n---\napiVersion: v1\ndata:\n  password: s3cr3t\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "document 2")
+	assert.NotContains(t, err.Error(), "s3cr3t")
+	_, err = splitManifests([]byte(`{"kind": "List", "items": [{"kind": "Secret", "metadata": {"name": "one"}}, {"data": {"password": "s3cr3t"}}]}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "item 1")
+	assert.NotContains(t, err.Error(), "s3cr3t")
+
+	objects, err = splitManifests(nil)
+	require.NoError(t, err)
+	assert.Len(t, objects, 0)
+}
+
+func TestGetAllowedPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-apply")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	manifestsAPI := path.Join(dir, "manifests-api")
+	applyRoot := path.Join(dir, "tests")
+	for _, d := range []string{manifestsAPI, applyRoot, path.Join(dir, "tests-other")} {
+		require.NoError(t, os.Mkdir(d, 0755))
+	}
+	manifest := path.Join(applyRoot, "nginx.yaml")
+	require.NoError(t, ioutil.WriteFile(manifest, []byte("kind: ConfigMap"), 0644))
+	require.NoError(t, os.Symlink("/etc", path.Join(applyRoot, "etc")))
+
+	for _, p := range []string{manifestsAPI, applyRoot, manifest} {
+		allowed, err := getAllowedPath(p, manifestsAPI, applyRoot)
+		require.NoError(t, err, p)
+		assert.Equal(t, p, allowed)
+	}
+	for _, p := range []string{
+		"/etc/shadow",
+		dir,
+		path.Join(dir, "tests-other"),
+		path.Join(applyRoot, "..", "tests-other"),
+		path.Join(applyRoot, "etc", "shadow"),
+	} {
+		_, err = getAllowedPath(p, manifestsAPI, applyRoot)
+		assert.Equal(t, api.ErrPathNotAllowed, err, p)
+	}
+	_, err = getAllowedPath(manifest, manifestsAPI, "")
+	assert.Equal(t, api.ErrPathNotAllowed, err)
+	_, err = getAllowedPath(path.Join(applyRoot, "missing"), manifestsAPI, applyRoot)
+	assert.Error(t, err)
+}
+
+func TestParseApplyAction(t *testing.T) {
+	assert.Equal(t, api.ApplyCreated, parseApplyAction("created"))
+	assert.Equal(t, api.ApplyUnchanged, parseApplyAction(" unchanged"))
+	assert.Equal(t, api.ApplyConfigured, parseApplyAction("configured (server dry run)"))
+	assert.Equal(t, api.ApplyPruned, parseApplyAction("pruned"))
+	assert.Equal(t, api.ApplyUnknown, parseApplyAction("serverside-applied"))
+	assert.Equal(t, api.ApplyUnknown, parseApplyAction(""))
+}
+
+func TestParseApplyOutput(t *testing.T) {
+	assert.Equal(t, []appliedObject{
+		{kind: "configmap", name: "one", action: api.ApplyUnchanged},
+		{kind: "deployment.apps", name: "nginx", action: api.ApplyConfigured},
+		{kind: "service", name: "two", action: api.ApplyUnknown},
+		{kind: "deployment", name: "web", action: api.ApplyPruned},
+	}, parseApplyOutput(`configmap/one unchanged
+deployment.apps/nginx configured (server dry run)
+service/two
+
+deployment "web" pruned
+Warning: kubectl apply should be used on resource created by either kubectl create --save-config or kubectl apply`))
+	assert.Len(t, parseApplyOutput(""), 0)
+}
+
+func TestNewObjectResults(t *testing.T) {
+	objects := []applyObject{
+		{kind: "ConfigMap", namespace: "default", name: "one"},
+		{kind: "Deployment", namespace: "default", name: "nginx"},
+		{kind: "ConfigMap", namespace: "kube-system", name: "one"},
+		{kind: "Service", name: "two"},
+	}
+	results, errs := newObjectResults(objects, `configmap/one created
+configmap/one unchanged
+service/two configured
+deployment.apps/old pruned
+`, `Error from server (Invalid): error when creating "STDIN": Deployment.apps "nginx" is invalid: spec.template: Required value
+Error from server (Forbidden): error when creating "STDIN": something else`)
+	assert.Equal(t, []api.ObjectResult{
+		{Kind: "ConfigMap", Namespace: "default", Name: "one", Result: api.ApplyCreated},
+		{Kind: "Deployment", Namespace: "default", Name: "nginx", Result: api.ApplyError, Error: `Error from server (Invalid): error when creating "STDIN": Deployment.apps "nginx" is invalid: spec.template: Required value`},
+		{Kind: "ConfigMap", Namespace: "kube-system", Name: "one", Result: api.ApplyUnchanged},
+		{Kind: "Service", Name: "two", Result: api.ApplyConfigured},
+		{Kind: "deployment.apps", Name: "old", Result: api.ApplyPruned},
+	}, results)
+	assert.Equal(t, []string{`Error from server (Forbidden): error when creating "STDIN": something else`}, errs)
+
+	// the error of kubectl is reported once, the objects are unknown
+	results, errs = newObjectResults(objects[:2], "", "The connection to the server 127.0.0.1:8080 was refused")
+	require.Len(t, results, 2)
+	for _, res := range results {
+		assert.Equal(t, api.ApplyUnknown, res.Result)
+		assert.Empty(t, res.Error)
+	}
+	assert.Equal(t, []string{"The connection to the server 127.0.0.1:8080 was refused"}, errs)
+
+	results, errs = newObjectResults(objects[:1], "configmap/one created\n", "")
+	assert.Equal(t, api.ApplyCreated, results[0].Result)
+	assert.Nil(t, errs)
+}
+
+func TestSplitBySelector(t *testing.T) {
+	objects := []applyObject{
+		{kind: "ConfigMap", name: "one", labels: labels.Set{"app": "test"}},
+		{kind: "ConfigMap", name: "two", labels: labels.Set{}},
+		{kind: "ConfigMap", name: "three", labels: labels.Set{"app": "web"}},
+	}
+	selector, err := labels.Parse("app=test")
+	require.NoError(t, err)
+	others, matching := splitBySelector(objects, selector)
+	assert.Equal(t, []applyObject{objects[1], objects[2]}, others)
+	assert.Equal(t, []applyObject{objects[0]}, matching)
+
+	objects, err = splitManifests([]byte("kind: ConfigMap\nmetadata:\n  name: one\n  labels:\n    app: test\n"))
+	require.NoError(t, err)
+	others, matching = splitBySelector(objects, selector)
+	assert.Len(t, others, 0)
+	assert.Len(t, matching, 1)
+}
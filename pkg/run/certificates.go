@@ -0,0 +1,84 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package run
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/DataDog/pupernetes/pkg/pki"
+	"github.com/DataDog/pupernetes/pkg/util"
+)
+
+const apiServerRestartTimeout = time.Minute
+
+// RotateCertificates reissues the certificates and schedules the restart of the components using them
+func (r *Runtime) RotateCertificates() ([]pki.Info, error) {
+	infos, err := r.env.RotateCertificates()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case r.certificatesRotated <- struct{}{}:
+	default:
+		glog.V(2).Infof("A restart after the certificates rotation is already scheduled")
+	}
+	return infos, nil
+}
+
+func (r *Runtime) waitAPIServer() error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	timeout := time.NewTimer(apiServerRestartTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := r.httpProbe("http://127.0.0.1:8080/healthz")
+			if err == nil {
+				return nil
+			}
+		case <-timeout.C:
+			err := fmt.Errorf("timeout awaiting the kube-apiserver after %s", apiServerRestartTimeout)
+			glog.Errorf("Unexpected error: %v", err)
+			return err
+		}
+	}
+}
+
+// restartCertificatesConsumers restarts the systemd units and recreates the pods using the rotated certificates
+func (r *Runtime) restartCertificatesConsumers() error {
+	for _, u := range r.env.GetCertificateUnits() {
+		err := util.RestartUnit(r.env.GetDBUSClient(), u)
+		if err != nil {
+			return err
+		}
+	}
+	err := r.waitAPIServer()
+	if err != nil {
+		return err
+	}
+	for _, app := range r.env.GetCertificatePods() {
+		glog.V(2).Infof("Deleting the pods app=%s to use the rotated certificates", app)
+		err = r.env.GetKubernetesClient().CoreV1().Pods("kube-system").DeleteCollection(r.kubeDeleteOption, v1.ListOptions{
+			LabelSelector: "app=" + app,
+		})
+		if err != nil {
+			glog.Errorf("Cannot delete the pods app=%s: %v", app, err)
+			return err
+		}
+	}
+	if !r.state.IsKubectlApplied() {
+		return nil
+	}
+	// the control plane pods aren't owned by any controller
+	return r.applyManifests()
+}
@@ -6,319 +6,329 @@
 package run
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+
+	"github.com/DataDog/pupernetes/pkg/api"
 )
 
-func (r *Runtime) deleteDeployments(namespaces *corev1.NamespaceList) error {
-	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().AppsV1beta1().Deployments(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get Deployments in ns %q: %v", ns.Name, err)
-			return err
-		}
-		glog.V(4).Infof("Deleting %d Deployments in ns %q ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().AppsV1beta1().Deployments(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil {
-				glog.Errorf("Cannot delete Deployments %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
-			}
-		}
+// apiResource is a namespaced resource served by the apiserver
+type apiResource struct {
+	groupVersion string
+	v1.APIResource
+}
+
+// path returns the path of the resource in the namespace, like /apis/apps/v1/namespaces/default/deployments
+func (a *apiResource) path(namespace string) string {
+	prefix := "/apis"
+	if a.groupVersion == "v1" {
+		prefix = "/api"
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete Deployments: %s", strings.Join(errs, ", "))
+	return path.Join(prefix, a.groupVersion, "namespaces", namespace, a.Name)
+}
+
+// group returns the API group of the resource, empty for the core group
+func (a *apiResource) group() string {
+	if i := strings.Index(a.groupVersion, "/"); i != -1 {
+		return a.groupVersion[:i]
 	}
-	return nil
+	return ""
 }
 
-func (r *Runtime) deleteDaemonset(namespaces *corev1.NamespaceList) error {
-	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().AppsV1().DaemonSets(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get DaemonSets in ns %q: %v", ns.Name, err)
-			return err
+// matches returns true if any of the names is the resource, its singular name, its kind, one of its short names
+// or resource.group like deployments.apps
+func (a *apiResource) matches(names []string) bool {
+	group := a.group()
+	for _, name := range names {
+		name = strings.ToLower(name)
+		resource := name
+		if i := strings.Index(name, "."); i != -1 {
+			if name[i+1:] != group {
+				continue
+			}
+			resource = name[:i]
 		}
-		glog.V(4).Infof("Deleting %d DaemonSets in ns %q ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().AppsV1().DaemonSets(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil {
-				glog.Errorf("Cannot delete DaemonSets %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
+		if resource == a.Name || resource == a.SingularName || resource == strings.ToLower(a.Kind) {
+			return true
+		}
+		for _, shortName := range a.ShortNames {
+			if resource == shortName {
+				return true
 			}
 		}
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete DaemonSets: %s", strings.Join(errs, ", "))
-	}
-	return nil
+	return false
 }
 
-func (r *Runtime) deleteReplicationController(namespaces *corev1.NamespaceList) error {
-	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().CoreV1().ReplicationControllers(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get ReplicationControllers in ns %q: %v", ns.Name, err)
-			return err
-		}
-		glog.V(4).Infof("Deleting %d ReplicationControllers in ns %q ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().AppsV1().DaemonSets(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil {
-				glog.Errorf("Cannot delete ReplicationControllers %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
+// selectAPIResources returns the resources that can be listed and deleted, filtered by the options
+func selectAPIResources(lists []*v1.APIResourceList, opts *api.ResetOptions) []apiResource {
+	var resources []apiResource
+	for _, list := range discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "get", "delete"}}, lists) {
+		for _, r := range list.APIResources {
+			resource := apiResource{groupVersion: list.GroupVersion, APIResource: r}
+			if !resource.Namespaced {
+				continue
+			}
+			if len(opts.Include) > 0 && !resource.matches(opts.Include) {
+				continue
 			}
+			if resource.matches(opts.Exclude) {
+				continue
+			}
+			resources = append(resources, resource)
 		}
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete ReplicationControllers: %s", strings.Join(errs, ", "))
-	}
-	return nil
+	return resources
 }
 
-func (r *Runtime) deleteReplicaSets(namespaces *corev1.NamespaceList) error {
-	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().AppsV1().ReplicaSets(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get ReplicaSets in ns %q: %v", ns.Name, err)
-			return err
-		}
-		glog.V(4).Infof("Deleting %d ReplicaSets in ns %q ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().AppsV1().ReplicaSets(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil && !errors.IsNotFound(err) {
-				glog.Errorf("Cannot delete ReplicaSets %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
-			}
+// apiObject is an object of a resource in a namespace
+type apiObject struct {
+	resource *apiResource
+	v1.ObjectMeta
+}
+
+func (o *apiObject) String() string {
+	return fmt.Sprintf("%s/%s in ns %q", o.resource.Name, o.Name, o.Namespace)
+}
+
+// isOwnedBy returns true if an owner of the object is in the given uids,
+// the object is then deleted by the garbage collector
+func (o *apiObject) isOwnedBy(uids map[types.UID]bool) bool {
+	for _, owner := range o.OwnerReferences {
+		if uids[owner.UID] {
+			return true
 		}
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete ReplicaSets: %s", strings.Join(errs, ", "))
-	}
-	return nil
+	return false
 }
 
-func (r *Runtime) deleteJobs(namespaces *corev1.NamespaceList) error {
-	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().BatchV1().Jobs(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get Jobs in ns %q: %v", ns.Name, err)
-			return err
-		}
-		glog.V(4).Infof("Deleting %d Jobs in ns %q ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().BatchV1().Jobs(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil && !errors.IsNotFound(err) {
-				glog.Errorf("Cannot delete Jobs %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
-			}
+func (r *Runtime) getAPIResources(opts *api.ResetOptions) ([]apiResource, error) {
+	lists, err := r.env.GetKubernetesClient().Discovery().ServerPreferredNamespacedResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			glog.Errorf("Cannot discover the API resources: %v", err)
+			return nil, err
 		}
+		// an unavailable aggregated API shouldn't prevent the reset of the other resources
+		glog.Warningf("Partial discovery of the API resources: %v", err)
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete Jobs: %s", strings.Join(errs, ", "))
-	}
-	return nil
+	resources := selectAPIResources(lists, opts)
+	glog.V(4).Infof("Discovered %d namespaced resources to reset", len(resources))
+	return resources, nil
 }
 
-func (r *Runtime) deletePods(namespaces *corev1.NamespaceList) error {
-	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().CoreV1().Pods(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get Pods in ns %q: %v", ns.Name, err)
-			return err
-		}
-		glog.V(4).Infof("Deleting %d Pods in ns %s ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().CoreV1().Pods(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil && !errors.IsNotFound(err) {
-				glog.Errorf("Cannot delete Pods %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
-			}
-		}
+// newAPIObject returns the object of the resource with the metadata used by the reset
+func newAPIObject(resource *apiResource, u *unstructured.Unstructured) apiObject {
+	return apiObject{
+		resource: resource,
+		ObjectMeta: v1.ObjectMeta{
+			Name:              u.GetName(),
+			Namespace:         u.GetNamespace(),
+			UID:               u.GetUID(),
+			OwnerReferences:   u.GetOwnerReferences(),
+			DeletionTimestamp: u.GetDeletionTimestamp(),
+		},
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete Pods: %s", strings.Join(errs, ", "))
+}
+
+// isBootstrapObject returns true if the object is managed by the apiserver, like the Service kubernetes
+func (o *apiObject) isBootstrapObject() bool {
+	if o.Namespace != "default" || o.Name != "kubernetes" || o.resource.group() != "" {
+		return false
 	}
-	return nil
+	return o.resource.Name == "services" || o.resource.Name == "endpoints"
 }
 
-func (r *Runtime) deleteServices(namespaces *corev1.NamespaceList) error {
-	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().CoreV1().Services(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get Services in ns %q: %v", ns.Name, err)
-			return err
-		}
-		glog.V(4).Infof("Deleting %d Services in ns %s ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().CoreV1().Services(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil && !errors.IsNotFound(err) {
-				glog.Errorf("Cannot delete Services %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
-			}
-		}
+func listAPIObjects(client rest.Interface, resource *apiResource, namespace, selector string) ([]apiObject, error) {
+	req := client.Get().AbsPath(resource.path(namespace))
+	if selector != "" {
+		req = req.Param("labelSelector", selector)
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete Services: %s", strings.Join(errs, ", "))
+	b, err := req.DoRaw()
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	list := &unstructured.UnstructuredList{}
+	err = list.UnmarshalJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]apiObject, 0, len(list.Items))
+	for i := range list.Items {
+		objects = append(objects, newAPIObject(resource, &list.Items[i]))
+	}
+	return objects, nil
 }
 
-func (r *Runtime) deleteEndpoints(namespaces *corev1.NamespaceList) error {
-	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().CoreV1().Endpoints(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get Endpoints in ns %q: %v", ns.Name, err)
-			return err
-		}
-		glog.V(4).Infof("Deleting %d Endpoints in ns %s ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().CoreV1().Endpoints(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil && !errors.IsNotFound(err) {
-				glog.Errorf("Cannot delete Endpoints %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
-			}
-		}
+func deleteAPIObject(client rest.Interface, obj *apiObject, deleteOptions v1.DeleteOptions) error {
+	deleteOptions.APIVersion = "v1"
+	deleteOptions.Kind = "DeleteOptions"
+	b, err := json.Marshal(&deleteOptions)
+	if err != nil {
+		return err
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete Endpoints: %s", strings.Join(errs, ", "))
+	err = client.Delete().
+		AbsPath(obj.resource.path(obj.Namespace), obj.Name).
+		SetHeader("Content-Type", "application/json").
+		Body(b).
+		Do().
+		Error()
+	if err != nil && !errors.IsNotFound(err) {
+		return err
 	}
 	return nil
 }
 
-func (r *Runtime) deleteConfigMaps(namespaces *corev1.NamespaceList) error {
-	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().CoreV1().ConfigMaps(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get ConfigMaps in ns %q: %v", ns.Name, err)
-			return err
-		}
-		glog.V(4).Infof("Deleting %d ConfigMaps in ns %s ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().CoreV1().ConfigMaps(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil && !errors.IsNotFound(err) {
-				glog.Errorf("Cannot delete ConfigMaps %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
-			}
-		}
+// isAPIObjectDeleted returns true if the object is gone or replaced by another one with the same name,
+// like the default ServiceAccount or the mirror pods
+func isAPIObjectDeleted(client rest.Interface, obj *apiObject) (bool, error) {
+	b, err := client.Get().
+		AbsPath(obj.resource.path(obj.Namespace), obj.Name).
+		DoRaw()
+	if errors.IsNotFound(err) {
+		return true, nil
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete ConfigMaps: %s", strings.Join(errs, ", "))
+	if err != nil {
+		return false, err
 	}
-	return nil
+	current := &unstructured.Unstructured{}
+	err = current.UnmarshalJSON(b)
+	if err != nil {
+		return false, err
+	}
+	return current.GetUID() != obj.UID, nil
 }
 
-func (r *Runtime) deleteSecrets(namespaces *corev1.NamespaceList) error {
-	var errs []string
+// getRESTClient returns the client of the apiserver paths
+func (r *Runtime) getRESTClient() rest.Interface {
+	return r.env.GetKubernetesClient().Discovery().RESTClient()
+}
+
+// deleteAPIResources deletes the objects of each resource in the namespaces and returns them
+func (r *Runtime) deleteAPIResources(namespaces *corev1.NamespaceList, opts *api.ResetOptions) ([]apiObject, error) {
+	resources, err := r.getAPIResources(opts)
+	if err != nil {
+		return nil, err
+	}
+	client := r.getRESTClient()
+	var objects []apiObject
 	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().CoreV1().Secrets(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get Secrets in ns %q: %v", ns.Name, err)
-			return err
-		}
-		glog.V(4).Infof("Deleting %d Secrets in ns %s ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().CoreV1().Secrets(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil && !errors.IsNotFound(err) {
-				glog.Errorf("Cannot delete Secrets %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
+		for i := range resources {
+			nsObjects, err := listAPIObjects(client, &resources[i], ns.Name, opts.Selector)
+			if err != nil {
+				// like a forbidden or unavailable aggregated resource, the other ones are still reset
+				glog.Warningf("Skipping %s in ns %q, cannot list them: %v", resources[i].Name, ns.Name, err)
+				continue
+			}
+			for _, obj := range nsObjects {
+				if obj.isBootstrapObject() {
+					glog.V(4).Infof("Not deleting %s: managed by the apiserver", obj.String())
+					continue
+				}
+				objects = append(objects, obj)
 			}
 		}
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete Secrets: %s", strings.Join(errs, ", "))
+	uids := make(map[types.UID]bool, len(objects))
+	for _, obj := range objects {
+		uids[obj.UID] = true
 	}
-	return nil
-}
 
-func (r *Runtime) deleteServiceAccounts(namespaces *corev1.NamespaceList) error {
 	var errs []string
-	for _, ns := range namespaces.Items {
-		toDelete, err := r.env.GetKubernetesClient().CoreV1().ServiceAccounts(ns.Name).List(v1.ListOptions{})
-		if err != nil {
-			glog.Errorf("Cannot get ServiceAccounts in ns %q: %v", ns.Name, err)
-			return err
+	errChan := make(chan error, len(objects))
+	deleting := 0
+	for i := range objects {
+		obj := &objects[i]
+		if obj.DeletionTimestamp != nil || obj.isOwnedBy(uids) {
+			glog.V(4).Infof("Not deleting %s: already deleted or owned by a deleted object", obj.String())
+			continue
 		}
-		glog.V(4).Infof("Deleting %d ServiceAccounts in ns %s ...", len(toDelete.Items), ns.Name)
-		for _, elt := range toDelete.Items {
-			err = r.env.GetKubernetesClient().CoreV1().ServiceAccounts(elt.Namespace).Delete(elt.Name, r.kubeDeleteOption)
-			if err != nil && !errors.IsNotFound(err) {
-				glog.Errorf("Cannot delete ServiceAccounts %s in ns %q: %v", elt.Name, elt.Namespace, err)
-				errs = append(errs, err.Error())
+		deleting++
+		go func() {
+			glog.V(4).Infof("Deleting %s ...", obj.String())
+			err := deleteAPIObject(client, obj, *r.kubeDeleteOption)
+			if err != nil {
+				glog.Errorf("Cannot delete %s: %v", obj.String(), err)
 			}
+			errChan <- err
+		}()
+	}
+	for i := 0; i < deleting; i++ {
+		err = <-errChan
+		if err != nil {
+			errs = append(errs, err.Error())
 		}
 	}
+	close(errChan)
 	if len(errs) > 0 {
-		return fmt.Errorf("unexpected errors during delete ServiceAccounts: %s", strings.Join(errs, ", "))
+		return objects, fmt.Errorf("unexpected errors during delete API resources: %s", strings.Join(errs, ", "))
 	}
-	return nil
+	glog.V(2).Infof("Deleted %d objects in %d namespaces", deleting, len(namespaces.Items))
+	return objects, nil
 }
 
-// DeleteAPIManifests execute Kubernetes delete API calls to delete the following resources:
-// - services
-// - Jobs
-// - Deployments
-// - Daemonset
-// - ReplicationController
-// - ReplicaSets
-// - Pods
-// - Endpoints
-// - ConfigMaps
-// - Secrets
-// - ServiceAccounts
-func (r *Runtime) DeleteAPIManifests(namespaces *corev1.NamespaceList) error {
-	fnList := []func(ns *corev1.NamespaceList) error{
-		r.deleteServices,
-		r.deleteJobs,
-		r.deleteDeployments,
-		r.deleteDaemonset,
-		r.deleteReplicationController,
-		r.deleteReplicaSets,
-		r.deletePods,
-		r.deleteEndpoints,
-		r.deleteConfigMaps,
-		r.deleteSecrets,
-		r.deleteServiceAccounts,
-	}
-	errChan := make(chan error, len(fnList))
+// waitAPIObjectsDeleted polls the objects until they are all deleted or the timeout is reached
+func waitAPIObjectsDeleted(client rest.Interface, objects []apiObject, timeout time.Duration) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-	for _, elt := range fnList {
-		go func(fn func(ns *corev1.NamespaceList) error) {
-			errChan <- fn(namespaces)
-		}(elt)
-	}
-	var err error
-	var errMsgList []string
-	for i := 0; i < len(fnList); i++ {
-		err = <-errChan
-		if err != nil {
-			errMsgList = append(errMsgList, err.Error())
+	remaining := objects
+	for {
+		var stillPresent []apiObject
+		for i := range remaining {
+			deleted, err := isAPIObjectDeleted(client, &remaining[i])
+			if err != nil {
+				glog.V(4).Infof("Cannot get %s: %v", remaining[i].String(), err)
+			}
+			if !deleted {
+				stillPresent = append(stillPresent, remaining[i])
+			}
+		}
+		remaining = stillPresent
+		if len(remaining) == 0 {
+			return nil
+		}
+		glog.V(4).Infof("Waiting for %d objects to be deleted ...", len(remaining))
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			var names []string
+			for i := range remaining {
+				names = append(names, remaining[i].String())
+			}
+			err := fmt.Errorf("timeout %s reached, %d objects still present: %s", timeout.String(), len(remaining), strings.Join(names, ", "))
+			glog.Errorf("Cannot wait for the deletion: %v", err)
+			return err
 		}
 	}
-	close(errChan)
+}
 
-	// Remaining Pods
-	err = r.deletePods(namespaces)
+// DeleteAPIManifests deletes the objects of every namespaced resource discovered on the apiserver,
+// including the custom resources, and waits until they are gone.
+// The objects owned by another deleted object are left to the garbage collector
+func (r *Runtime) DeleteAPIManifests(namespaces *corev1.NamespaceList, opts *api.ResetOptions) error {
+	objects, err := r.deleteAPIResources(namespaces, opts)
 	if err != nil {
-		errMsgList = append(errMsgList, err.Error())
+		return err
 	}
-	if len(errMsgList) == 0 {
-		glog.Infof("Graceful deleted API resources in %d namespaces", len(namespaces.Items))
-		return nil
+	err = waitAPIObjectsDeleted(r.getRESTClient(), objects, opts.Timeout)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("cannot delete API resources: %v", strings.Join(errMsgList, ","))
+	glog.Infof("Graceful deleted %d API objects in %d namespaces", len(objects), len(namespaces.Items))
+	return nil
 }
@@ -0,0 +1,229 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package run
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+
+	"github.com/DataDog/pupernetes/pkg/api"
+)
+
+var testAPIResourceLists = []*v1.APIResourceList{
+	{
+		GroupVersion: "v1",
+		APIResources: []v1.APIResource{
+			{Name: "pods", SingularName: "pod", Kind: "Pod", Namespaced: true, ShortNames: []string{"po"}, Verbs: []string{"create", "delete", "get", "list"}},
+			{Name: "secrets", SingularName: "secret", Kind: "Secret", Namespaced: true, Verbs: []string{"delete", "get", "list"}},
+			{Name: "bindings", SingularName: "binding", Kind: "Binding", Namespaced: true, Verbs: []string{"create"}},
+			{Name: "nodes", SingularName: "node", Kind: "Node", Namespaced: false, Verbs: []string{"delete", "get", "list"}},
+		},
+	},
+	{
+		GroupVersion: "apps/v1",
+		APIResources: []v1.APIResource{
+			{Name: "deployments", SingularName: "deployment", Kind: "Deployment", Namespaced: true, ShortNames: []string{"deploy"}, Verbs: []string{"delete", "get", "list"}},
+		},
+	},
+	{
+		GroupVersion: "stable.example.com/v1",
+		APIResources: []v1.APIResource{
+			{Name: "crontabs", SingularName: "crontab", Kind: "CronTab", Namespaced: true, Verbs: []string{"delete", "get", "list"}},
+		},
+	},
+}
+
+func getResourceNames(resources []apiResource) []string {
+	var names []string
+	for _, r := range resources {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func TestSelectAPIResources(t *testing.T) {
+	resources := selectAPIResources(testAPIResourceLists, &api.ResetOptions{})
+	assert.Equal(t, []string{"pods", "secrets", "deployments", "crontabs"}, getResourceNames(resources))
+	assert.Equal(t, "/api/v1/namespaces/default/pods", resources[0].path("default"))
+	assert.Equal(t, "/apis/apps/v1/namespaces/default/deployments", resources[2].path("default"))
+	assert.Equal(t, "/apis/stable.example.com/v1/namespaces/test/crontabs", resources[3].path("test"))
+
+	resources = selectAPIResources(testAPIResourceLists, &api.ResetOptions{Include: []string{"po", "Deployment", "crontabs.stable.example.com"}})
+	assert.Equal(t, []string{"pods", "deployments", "crontabs"}, getResourceNames(resources))
+
+	resources = selectAPIResources(testAPIResourceLists, &api.ResetOptions{Exclude: []string{"secret", "deployments.extensions", "crontab"}})
+	assert.Equal(t, []string{"pods", "deployments"}, getResourceNames(resources))
+
+	resources = selectAPIResources(testAPIResourceLists, &api.ResetOptions{Include: []string{"pods.apps"}})
+	assert.Len(t, resources, 0)
+}
+
+func TestIsOwnedBy(t *testing.T) {
+	obj := &apiObject{ObjectMeta: v1.ObjectMeta{
+		Name:            "nginx-6c54bd5869-7xz2l",
+		OwnerReferences: []v1.OwnerReference{{Kind: "ReplicaSet", Name: "nginx-6c54bd5869", UID: types.UID("rs")}},
+	}}
+	assert.True(t, obj.isOwnedBy(map[types.UID]bool{"rs": true}))
+	assert.False(t, obj.isOwnedBy(map[types.UID]bool{"deploy": true}))
+	assert.False(t, (&apiObject{}).isOwnedBy(map[types.UID]bool{"rs": true}))
+}
+
+// fakeAPIServer serves the pods of the namespace default by name, with their uid, deleted when the uid is empty
+type fakeAPIServer struct {
+	sync.Mutex
+	uids     map[string]string
+	selector string
+	deleted  []string
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	const prefix = "/api/v1/namespaces/default/pods"
+	notFound := `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == prefix && r.Method == http.MethodGet:
+		f.selector = r.URL.Query().Get("labelSelector")
+		items := ""
+		for name, uid := range f.uids {
+			if uid == "" {
+				continue
+			}
+			if items != "" {
+				items += ","
+			}
+			items += fmt.Sprintf(`{"metadata":{"name":%q,"namespace":"default","uid":%q}}`, name, uid)
+		}
+		fmt.Fprintf(w, `{"kind":"PodList","apiVersion":"v1","items":[%s]}`, items)
+
+	case r.URL.Path == prefix+"/forbidden":
+		w.WriteHeader(403)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403}`))
+
+	case r.Method == http.MethodGet:
+		uid := f.uids[r.URL.Path[len(prefix)+1:]]
+		if uid == "" {
+			w.WriteHeader(404)
+			w.Write([]byte(notFound))
+			return
+		}
+		fmt.Fprintf(w, `{"kind":"Pod","apiVersion":"v1","metadata":{"name":%q,"namespace":"default","uid":%q}}`, r.URL.Path[len(prefix)+1:], uid)
+
+	case r.Method == http.MethodDelete:
+		name := r.URL.Path[len(prefix)+1:]
+		if f.uids[name] == "" {
+			w.WriteHeader(404)
+			w.Write([]byte(notFound))
+			return
+		}
+		f.deleted = append(f.deleted, name)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`))
+	}
+}
+
+func (f *fakeAPIServer) setUID(name, uid string) {
+	f.Lock()
+	f.uids[name] = uid
+	f.Unlock()
+}
+
+func newTestRESTClient(t *testing.T, handler http.Handler) (rest.Interface, func()) {
+	srv := httptest.NewServer(handler)
+	c, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: srv.URL})
+	require.NoError(t, err)
+	return c.RESTClient(), srv.Close
+}
+
+var testPods = &apiResource{groupVersion: "v1", APIResource: v1.APIResource{Name: "pods", Kind: "Pod", Namespaced: true}}
+
+func TestListAndDeleteAPIObjects(t *testing.T) {
+	f := &fakeAPIServer{uids: map[string]string{"nginx": "1"}}
+	client, closeServer := newTestRESTClient(t, f)
+	defer closeServer()
+
+	objects, err := listAPIObjects(client, testPods, "default", "app=nginx")
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "nginx", objects[0].Name)
+	assert.Equal(t, "default", objects[0].Namespace)
+	assert.Equal(t, types.UID("1"), objects[0].UID)
+	assert.Equal(t, "app=nginx", f.selector)
+
+	require.NoError(t, deleteAPIObject(client, &objects[0], v1.DeleteOptions{}))
+	assert.Equal(t, []string{"nginx"}, f.deleted)
+	// already gone
+	assert.NoError(t, deleteAPIObject(client, &apiObject{resource: testPods, ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: "default"}}, v1.DeleteOptions{}))
+	assert.Error(t, deleteAPIObject(client, &apiObject{resource: testPods, ObjectMeta: v1.ObjectMeta{Name: "forbidden", Namespace: "default"}}, v1.DeleteOptions{}))
+}
+
+func TestIsAPIObjectDeleted(t *testing.T) {
+	f := &fakeAPIServer{uids: map[string]string{"nginx": "1", "mirror": "2"}}
+	client, closeServer := newTestRESTClient(t, f)
+	defer closeServer()
+
+	for _, tc := range []struct {
+		name     string
+		uid      types.UID
+		deleted  bool
+		hasError bool
+	}{
+		{name: "nginx", uid: "1", deleted: false},
+		{name: "missing", uid: "3", deleted: true},
+		// recreated with the same name, like the mirror pods or the default ServiceAccount
+		{name: "mirror", uid: "1", deleted: true},
+		{name: "forbidden", uid: "4", hasError: true},
+	} {
+		obj := &apiObject{resource: testPods, ObjectMeta: v1.ObjectMeta{Name: tc.name, Namespace: "default", UID: tc.uid}}
+		deleted, err := isAPIObjectDeleted(client, obj)
+		if tc.hasError {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.deleted, deleted, tc.name)
+	}
+}
+
+func TestWaitAPIObjectsDeleted(t *testing.T) {
+	f := &fakeAPIServer{uids: map[string]string{"nginx": "1", "mirror": "2"}}
+	client, closeServer := newTestRESTClient(t, f)
+	defer closeServer()
+	objects := []apiObject{
+		{resource: testPods, ObjectMeta: v1.ObjectMeta{Name: "nginx", Namespace: "default", UID: "1"}},
+		{resource: testPods, ObjectMeta: v1.ObjectMeta{Name: "mirror", Namespace: "default", UID: "2"}},
+	}
+
+	err := waitAPIObjectsDeleted(client, objects, 10*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 objects still present")
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		f.setUID("nginx", "")
+		f.setUID("mirror", "3")
+	}()
+	assert.NoError(t, waitAPIObjectsDeleted(client, objects, 5*time.Second))
+}
+
+func TestIsBootstrapObject(t *testing.T) {
+	services := &apiResource{groupVersion: "v1", APIResource: v1.APIResource{Name: "services", Kind: "Service", Namespaced: true}}
+	assert.True(t, (&apiObject{resource: services, ObjectMeta: v1.ObjectMeta{Name: "kubernetes", Namespace: "default"}}).isBootstrapObject())
+	assert.False(t, (&apiObject{resource: services, ObjectMeta: v1.ObjectMeta{Name: "kubernetes", Namespace: "test"}}).isBootstrapObject())
+	assert.False(t, (&apiObject{resource: services, ObjectMeta: v1.ObjectMeta{Name: "nginx", Namespace: "default"}}).isBootstrapObject())
+	assert.False(t, (&apiObject{resource: testPods, ObjectMeta: v1.ObjectMeta{Name: "kubernetes", Namespace: "default"}}).isBootstrapObject())
+}
@@ -12,6 +12,8 @@ import (
 	"os/exec"
 	"strings"
 	"time"
+
+	"github.com/DataDog/pupernetes/pkg/api"
 )
 
 func (r *Runtime) applyManifests() error {
@@ -20,8 +22,11 @@ func (r *Runtime) applyManifests() error {
 	output := string(b)
 	if err != nil {
 		glog.Errorf("Cannot apply manifests %v:\n%s", err, output)
+		r.state.SetKubectlApplyLastError(fmt.Sprintf("%v: %s", err, strings.TrimSpace(output)))
 		return err
 	}
+	r.state.SetKubectlApplyLastError("")
+	r.events.Publish(api.EventManifestsApplied, map[string]string{"path": r.env.GetManifestsPathToApply()})
 	glog.V(2).Infof("Successfully applied manifests:\n%s", output)
 	return nil
 }
@@ -56,8 +61,9 @@ func (r *Runtime) checkInClusterDNS() error {
 			return err
 		}
 		if len(resp.Answer) == 0 {
-			r.state.SetDNSLastError("No DNS results for " + query)
-			return err
+			msg := "No DNS results for " + query
+			r.state.SetDNSLastError(msg)
+			return fmt.Errorf("%s", msg)
 		}
 		var dnsResults []string
 		for _, ans := range resp.Answer {
@@ -65,5 +71,8 @@ func (r *Runtime) checkInClusterDNS() error {
 		}
 		glog.V(2).Infof("DNS query: %s", strings.Join(dnsResults, " "))
 	}
+	r.state.SetDNSLastError("")
+	r.state.SetDNSReady()
+	r.events.Publish(api.EventDNSReady, map[string]string{"queries": strings.Join(r.conf.ReadinessDNSQueries, ",")})
 	return nil
 }
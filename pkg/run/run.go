@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -43,6 +44,12 @@ type Config struct {
 
 	// SkipProbes allows to discard any check on the environment to keep running
 	SkipProbes bool
+
+	// APIListeners are the listeners of the pupernetes API
+	APIListeners *api.Listeners
+
+	// APIApplyRoot is the directory the path of an apply request can be in, with the manifests-api directory
+	APIApplyRoot string
 }
 
 // Runtime is the main state to execute a managed pupernetes Run
@@ -51,23 +58,26 @@ type Runtime struct {
 
 	conf *Config
 
-	api *http.Server
+	api *api.API
 
 	SigChan          chan os.Signal
 	httpClient       *http.Client
 	state            *state.State
+	events           *api.EventBus
 	kubeDeleteOption *v1.DeleteOptions
 
 	runTimestamp       time.Time
 	journalTailerMutex sync.RWMutex
 	journalTailers     map[string]*logging.JournalTailer
 
-	ApplyChan chan struct{}
+	certificatesRotated chan struct{}
 }
 
 // NewRunner instantiate a new Runtimer with the given Environment
 func NewRunner(env *setup.Environment, conf *Config) (*Runtime, error) {
 	var zero int64
+	// the dependents of the deleted objects are removed by the garbage collector
+	background := v1.DeletePropagationBackground
 
 	s, err := state.NewState()
 	if err != nil {
@@ -78,6 +88,7 @@ func NewRunner(env *setup.Environment, conf *Config) (*Runtime, error) {
 	run := &Runtime{
 		env:     env,
 		state:   s,
+		events:  api.NewEventBus(),
 		SigChan: make(chan os.Signal, 2),
 		httpClient: &http.Client{
 			Timeout: time.Millisecond * 500,
@@ -85,12 +96,14 @@ func NewRunner(env *setup.Environment, conf *Config) (*Runtime, error) {
 		conf: conf,
 		kubeDeleteOption: &v1.DeleteOptions{
 			GracePeriodSeconds: &zero,
+			PropagationPolicy:  &background,
 		},
 		journalTailers: make(map[string]*logging.JournalTailer),
 		runTimestamp:   time.Now(),
-		ApplyChan:      make(chan struct{}),
+
+		certificatesRotated: make(chan struct{}, 1),
 	}
-	run.api = api.NewAPI(run.SigChan, run.DeleteAPIManifests, run.state.IsReady, run.ApplyChan)
+	run.api = api.NewAPI(conf.APIListeners, run.SigChan, run.DeleteAPIManifests, run.state.IsReady, run.Status, run.events, run.ApplyManifests, run.env.ListCertificates, run.RotateCertificates)
 	return run, nil
 }
 
@@ -99,15 +112,16 @@ func (r *Runtime) Run() error {
 	// the associated signal.Reset is defer in r.Stop method
 	signal.Notify(r.SigChan, syscall.SIGTERM, syscall.SIGINT)
 
-	defer close(r.ApplyChan)
-
 	if r.conf.RunTimeout > 0 {
 		glog.Infof("Timeout for this current run is %s", r.conf.RunTimeout.String())
 	}
 	timeoutTimer := time.NewTimer(r.conf.RunTimeout)
 	defer timeoutTimer.Stop()
 
-	go r.api.ListenAndServe()
+	err := r.api.Serve()
+	if err != nil {
+		return err
+	}
 
 	for _, u := range r.env.GetSystemdUnits() {
 		if strings.Contains(u, "kubelet.service") {
@@ -119,7 +133,9 @@ func (r *Runtime) Run() error {
 		if err != nil {
 			return r.Stop(err)
 		}
+		r.events.Publish(api.EventUnitStarted, map[string]string{"unit": u})
 	}
+	r.state.SetPhase(state.PhaseRunning)
 
 	probeTick := time.NewTicker(time.Second * 2)
 	defer probeTick.Stop()
@@ -153,6 +169,7 @@ func (r *Runtime) Run() error {
 			}
 			_, err := r.probeUnitStatuses()
 			if err != nil {
+				r.events.Publish(api.EventProbeFailed, map[string]string{"probe": "units", "error": err.Error()})
 				r.SigChan <- syscall.SIGTERM
 				continue
 			}
@@ -161,6 +178,12 @@ func (r *Runtime) Run() error {
 				continue
 			}
 			failures := r.state.GetKubeletProbeFail()
+			r.events.Publish(api.EventProbeFailed, map[string]string{
+				"probe":     "kubelet",
+				"error":     err.Error(),
+				"failures":  strconv.Itoa(failures + 1),
+				"threshold": strconv.Itoa(appProbeThreshold),
+			})
 			if failures >= appProbeThreshold {
 				glog.Warningf("Probing failed, stopping ...")
 				// display some helpers to investigate:
@@ -175,15 +198,10 @@ func (r *Runtime) Run() error {
 		case <-displayTick.C:
 			r.runDisplay()
 
-		case <-r.ApplyChan:
-			if !r.state.IsReady() {
-				glog.Warningf("Cannot re-apply when not ready, retry later")
-				continue
-			}
-			// kubectl apply -f manifests-api
-			err := r.applyManifests()
+		case <-r.certificatesRotated:
+			err := r.restartCertificatesConsumers()
 			if err != nil {
-				glog.Errorf("Cannot apply manifests in %s", r.env.GetManifestsPathToApply())
+				glog.Errorf("Cannot restart the components after the certificates rotation: %v", err)
 			}
 
 		case <-sigStopChan:
@@ -213,6 +231,7 @@ func (r *Runtime) Run() error {
 				r.state.SetAPIServerProbeLastError(err.Error())
 				continue
 			}
+			r.state.SetAPIServerProbeLastError("")
 			// kubectl apply -f manifests-api
 			if !r.state.IsKubectlApplied() {
 				err = r.applyManifests()
@@ -230,6 +249,11 @@ func (r *Runtime) Run() error {
 			}
 			// Mark the current state as ready
 			r.state.SetReady()
+			err = r.env.AddHostDNSNameserver()
+			if err != nil {
+				glog.Warningf("Cannot resolve the cluster domain from the host: %v", err)
+			}
+			r.events.Publish(api.EventReady, nil)
 			glog.V(2).Infof("Pupernetes is ready")
 			readinessTick.Stop()
 		}
@@ -2,17 +2,35 @@ package state
 
 import (
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	// PhaseStarting is the phase starting the systemd units
+	PhaseStarting = "starting"
+	// PhaseRunning is the phase waiting for the readiness
+	PhaseRunning = "running"
+	// PhaseReady is the phase after the readiness
+	PhaseReady = "ready"
+	// PhaseStopping is the phase draining the pods and stopping the systemd units
+	PhaseStopping = "stopping"
+	// PhaseStopped is the phase after the stop
+	PhaseStopped = "stopped"
+)
+
 // State keeps track of the current stats
 type State struct {
 	sync.RWMutex
 
+	phase                   string
+	phaseTimestamp          time.Time
 	apiServerProbeLastError string
 	dnsLastError            string
+	dnsReady                bool
+	kubectlApplyLastError   string
 	kubectlApplied          bool
 	ready                   bool
 
@@ -31,7 +49,14 @@ type State struct {
 
 // NewState instantiate a state with the associated prometheus metrics
 func NewState() (*State, error) {
+	return NewStateWithRegisterer(prometheus.DefaultRegisterer)
+}
+
+// NewStateWithRegisterer instantiate a state with the associated prometheus metrics registered in r
+func NewStateWithRegisterer(r prometheus.Registerer) (*State, error) {
 	s := &State{
+		phase:          PhaseStarting,
+		phaseTimestamp: time.Now(),
 		promVersion: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name:        "pupernetes_version",
 			Help:        "Pupernetes version",
@@ -59,7 +84,7 @@ func NewState() (*State, error) {
 			Help: "Total number of dns query failures",
 		}),
 	}
-	err := registerCollectors(s.promVersion, s.promStateReady, s.promKubeletAPIPodRunning, s.promKubeletLogsPodRunning, s.promKubeletProbeFailures, s.promReadyDNSFailures)
+	err := registerCollectors(r, s.promVersion, s.promStateReady, s.promKubeletAPIPodRunning, s.promKubeletLogsPodRunning, s.promKubeletProbeFailures, s.promReadyDNSFailures)
 	if err != nil {
 		return nil, err
 	}
@@ -67,9 +92,9 @@ func NewState() (*State, error) {
 	return s, nil
 }
 
-func registerCollectors(collectors ...prometheus.Collector) error {
+func registerCollectors(r prometheus.Registerer, collectors ...prometheus.Collector) error {
 	for _, c := range collectors {
-		err := prometheus.Register(c)
+		err := r.Register(c)
 		if err != nil {
 			return err
 		}
@@ -89,12 +114,32 @@ func (s *State) IsReady() bool {
 func (s *State) SetReady() {
 	s.Lock()
 	s.ready = true
+	s.phase = PhaseReady
+	s.phaseTimestamp = time.Now()
 	s.Unlock()
 	// Ignore errors
 	notifySystemd()
 	s.promStateReady.Set(1)
 }
 
+// SetPhase records the current phase of the run
+func (s *State) SetPhase(phase string) {
+	s.Lock()
+	if s.phase != phase {
+		glog.V(2).Infof("Entering the phase %s", phase)
+		s.phase = phase
+		s.phaseTimestamp = time.Now()
+	}
+	s.Unlock()
+}
+
+// GetPhase returns the current phase of the run and when it started
+func (s *State) GetPhase() (string, time.Time) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.phase, s.phaseTimestamp
+}
+
 // SetKubectlApplied mark the state when kubectl apply successfully returned
 func (s *State) SetKubectlApplied() {
 	s.Lock()
@@ -109,27 +154,75 @@ func (s *State) IsKubectlApplied() bool {
 	return s.kubectlApplied
 }
 
+// SetKubectlApplyLastError keep track of the latest kubectl apply error, an empty message clears it
+func (s *State) SetKubectlApplyLastError(msg string) {
+	s.Lock()
+	s.kubectlApplyLastError = msg
+	s.Unlock()
+}
+
+// GetKubectlApplyLastError returns the latest kubectl apply error
+func (s *State) GetKubectlApplyLastError() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.kubectlApplyLastError
+}
+
 // SetAPIServerProbeLastError keep track of the latest error message and display only
-// if there is a a diff from the last record
+// if there is a a diff from the last record, an empty message clears it
 func (s *State) SetAPIServerProbeLastError(msg string) {
 	s.Lock()
 	if s.apiServerProbeLastError != msg {
-		glog.Infof("Kubernetes apiserver not ready yet: %s", msg)
+		if msg != "" {
+			glog.Infof("Kubernetes apiserver not ready yet: %s", msg)
+		}
 		s.apiServerProbeLastError = msg
 	}
 	s.Unlock()
 }
 
+// GetAPIServerProbeLastError returns the latest kube-apiserver probe error
+func (s *State) GetAPIServerProbeLastError() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.apiServerProbeLastError
+}
+
 // SetDNSLastError keep track of the latest error message and display only
-// if there is a a diff from the last record
+// if there is a a diff from the last record, an empty message clears it
 func (s *State) SetDNSLastError(msg string) {
 	s.Lock()
 	if s.dnsLastError != msg {
-		glog.Infof("Kubernetes dns not ready yet: %s", msg)
+		if msg != "" {
+			glog.Infof("Kubernetes dns not ready yet: %s", msg)
+		}
 		s.dnsLastError = msg
 	}
 	s.Unlock()
-	s.promReadyDNSFailures.Inc()
+	if msg != "" {
+		s.promReadyDNSFailures.Inc()
+	}
+}
+
+// SetDNSReady mark the state when the dns queries of the readiness succeeded
+func (s *State) SetDNSReady() {
+	s.Lock()
+	s.dnsReady = true
+	s.Unlock()
+}
+
+// IsDNSReady returns true when the dns queries of the readiness already succeeded
+func (s *State) IsDNSReady() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.dnsReady
+}
+
+// GetDNSLastError returns the latest dns error
+func (s *State) GetDNSLastError() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.dnsLastError
 }
 
 // IncKubeletProbeFailures increment the number of kubelet failures
@@ -159,6 +252,13 @@ func (s *State) SetKubeletAPIPodRunning(nb int) {
 	s.promKubeletAPIPodRunning.Set(float64(nb))
 }
 
+// GetKubeletAPIPodRunning returns the number of running pods reported by the kubelet API
+func (s *State) GetKubeletAPIPodRunning() int {
+	s.RLock()
+	defer s.RUnlock()
+	return s.kubeletAPIPodRunning
+}
+
 // SetKubeletLogsPodRunning keep track of the number of kubelet Pods in /var/log/pods and display only
 // if there is a a diff from the last record
 func (s *State) SetKubeletLogsPodRunning(nb int) {
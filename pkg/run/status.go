@@ -0,0 +1,111 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package run
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coreos/go-systemd/dbus"
+
+	"github.com/DataDog/pupernetes/pkg/api"
+	"github.com/DataDog/pupernetes/pkg/run/state"
+	"github.com/DataDog/pupernetes/pkg/util"
+	"github.com/DataDog/pupernetes/version"
+)
+
+func (r *Runtime) getUnitStatuses() ([]api.UnitStatus, error) {
+	units, err := util.GetUnitStates(r.env.GetDBUSClient(), r.env.GetSystemdUnits())
+	if err != nil {
+		return nil, err
+	}
+	return newUnitStatuses(r.env.GetSystemdUnits(), units), nil
+}
+
+// newUnitStatuses returns the statuses of the named units in the same order, the missing ones are not found
+func newUnitStatuses(names []string, units []dbus.UnitStatus) []api.UnitStatus {
+	byName := make(map[string]api.UnitStatus, len(units))
+	for _, u := range units {
+		byName[u.Name] = api.UnitStatus{
+			Name:        u.Name,
+			LoadState:   u.LoadState,
+			ActiveState: u.ActiveState,
+			SubState:    u.SubState,
+		}
+	}
+	var statuses []api.UnitStatus
+	for _, name := range names {
+		s, ok := byName[name]
+		if !ok {
+			// same as systemctl for an unknown unit
+			s = api.UnitStatus{Name: name, LoadState: "not-found", ActiveState: "inactive", SubState: "dead"}
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// getBlockers returns the readiness conditions not met yet
+func (r *Runtime) getBlockers(phase string) []api.Blocker {
+	blockers := []api.Blocker{}
+	if r.state.IsReady() {
+		return blockers
+	}
+	if phase == state.PhaseStarting {
+		blockers = append(blockers, api.Blocker{Name: "units"})
+	}
+	if msg := r.state.GetAPIServerProbeLastError(); msg != "" || phase == state.PhaseStarting {
+		blockers = append(blockers, api.Blocker{Name: "apiserver", LastError: msg})
+	}
+	if !r.state.IsKubectlApplied() {
+		blockers = append(blockers, api.Blocker{Name: "manifests", LastError: r.state.GetKubectlApplyLastError()})
+	}
+	if r.conf.ReadinessDNSQueries != nil && !r.state.IsDNSReady() {
+		blockers = append(blockers, api.Blocker{Name: "dns", LastError: r.state.GetDNSLastError()})
+	}
+	return blockers
+}
+
+// Status returns the current status of the run, used by the API
+func (r *Runtime) Status() *api.Status {
+	phase, _ := r.state.GetPhase()
+	s := &api.Status{
+		Phase:          phase,
+		Ready:          r.state.IsReady(),
+		StateDirectory: r.env.GetRootABSPath(),
+		StartedAt:      r.runTimestamp,
+		Uptime:         time.Since(r.runTimestamp).Round(time.Second).String(),
+		Versions:       r.env.GetVersions(),
+		Endpoints: map[string]string{
+			"kubeAPIServer":  "http://127.0.0.1:8080",
+			"kubeletHealthz": fmt.Sprintf("http://127.0.0.1:%d/healthz", r.env.GetKubeletHealthzPort()),
+			"dns":            r.env.GetDNSClusterIP() + ":53",
+		},
+		Blockers:             r.getBlockers(phase),
+		KubectlApplied:       r.state.IsKubectlApplied(),
+		KubeletProbeFailures: r.state.GetKubeletProbeFail(),
+		Pods: api.PodCounts{
+			KubeletAPIRunning:  r.state.GetKubeletAPIPodRunning(),
+			KubeletLogsRunning: r.state.GetKubeletLogsPodRunning(),
+		},
+	}
+	s.Versions["pupernetes"] = version.Version
+	if r.api.Listeners.Address != "" {
+		s.Endpoints["api"] = r.api.Listeners.Address
+	}
+	if r.api.Listeners.SocketPath != "" {
+		s.Endpoints["apiSocket"] = "unix://" + r.api.Listeners.SocketPath
+	}
+	if r.api.Listeners.TLSAddress != "" {
+		s.Endpoints["apiTLS"] = "https://" + r.api.Listeners.TLSAddress
+	}
+	units, err := r.getUnitStatuses()
+	if err != nil {
+		s.UnitsError = err.Error()
+	}
+	s.Units = units
+	return s
+}
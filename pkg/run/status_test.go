@@ -0,0 +1,153 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package run
+
+import (
+	"testing"
+
+	"github.com/coreos/go-systemd/dbus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/pupernetes/pkg/api"
+	"github.com/DataDog/pupernetes/pkg/run/state"
+)
+
+func TestGetBlockers(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		phase      string
+		dnsQueries []string
+		update     func(s *state.State)
+		expected   []api.Blocker
+	}{
+		{
+			name:  "starting",
+			phase: state.PhaseStarting,
+			expected: []api.Blocker{
+				{Name: "units"},
+				{Name: "apiserver"},
+				{Name: "manifests"},
+			},
+		},
+		{
+			name:  "apiserver probe failing",
+			phase: state.PhaseRunning,
+			update: func(s *state.State) {
+				s.SetAPIServerProbeLastError("connection refused")
+			},
+			expected: []api.Blocker{
+				{Name: "apiserver", LastError: "connection refused"},
+				{Name: "manifests"},
+			},
+		},
+		{
+			name:  "manifests failing",
+			phase: state.PhaseRunning,
+			update: func(s *state.State) {
+				s.SetKubectlApplyLastError("exit status 1")
+			},
+			expected: []api.Blocker{
+				{Name: "manifests", LastError: "exit status 1"},
+			},
+		},
+		{
+			name:       "dns not checked yet",
+			phase:      state.PhaseRunning,
+			dnsQueries: []string{"coredns.kube-system.svc.cluster.local"},
+			update: func(s *state.State) {
+				s.SetKubectlApplied()
+			},
+			expected: []api.Blocker{
+				{Name: "dns"},
+			},
+		},
+		{
+			name:       "dns failing",
+			phase:      state.PhaseRunning,
+			dnsQueries: []string{"coredns.kube-system.svc.cluster.local"},
+			update: func(s *state.State) {
+				s.SetKubectlApplied()
+				s.SetDNSLastError("i/o timeout")
+			},
+			expected: []api.Blocker{
+				{Name: "dns", LastError: "i/o timeout"},
+			},
+		},
+		{
+			name:       "dns ready",
+			phase:      state.PhaseRunning,
+			dnsQueries: []string{"coredns.kube-system.svc.cluster.local"},
+			update: func(s *state.State) {
+				s.SetDNSReady()
+			},
+			expected: []api.Blocker{
+				{Name: "manifests"},
+			},
+		},
+		{
+			name:       "ready",
+			phase:      state.PhaseReady,
+			dnsQueries: []string{"coredns.kube-system.svc.cluster.local"},
+			update: func(s *state.State) {
+				s.SetKubectlApplied()
+				s.SetDNSReady()
+				s.SetReady()
+			},
+			expected: []api.Blocker{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := state.NewStateWithRegisterer(prometheus.NewRegistry())
+			require.NoError(t, err)
+			if tc.update != nil {
+				tc.update(s)
+			}
+			r := &Runtime{state: s, conf: &Config{ReadinessDNSQueries: tc.dnsQueries}}
+			assert.Equal(t, tc.expected, r.getBlockers(tc.phase))
+		})
+	}
+}
+
+func TestNewUnitStatuses(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		names    []string
+		units    []dbus.UnitStatus
+		expected []api.UnitStatus
+	}{
+		{
+			name: "no units",
+		},
+		{
+			name:  "ordered by names",
+			names: []string{"p8s-etcd.service", "p8s-kubelet.service"},
+			units: []dbus.UnitStatus{
+				{Name: "p8s-kubelet.service", LoadState: "loaded", ActiveState: "active", SubState: "running"},
+				{Name: "p8s-etcd.service", LoadState: "loaded", ActiveState: "failed", SubState: "failed"},
+			},
+			expected: []api.UnitStatus{
+				{Name: "p8s-etcd.service", LoadState: "loaded", ActiveState: "failed", SubState: "failed"},
+				{Name: "p8s-kubelet.service", LoadState: "loaded", ActiveState: "active", SubState: "running"},
+			},
+		},
+		{
+			name:  "not found",
+			names: []string{"p8s-etcd.service"},
+			units: []dbus.UnitStatus{
+				{Name: "p8s-kubelet.service", LoadState: "loaded", ActiveState: "active", SubState: "running"},
+			},
+			expected: []api.UnitStatus{
+				{Name: "p8s-etcd.service", LoadState: "not-found", ActiveState: "inactive", SubState: "dead"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, newUnitStatuses(tc.names, tc.units))
+		})
+	}
+}
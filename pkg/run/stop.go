@@ -10,7 +10,9 @@ import (
 	"os"
 	"time"
 
+	"github.com/DataDog/pupernetes/pkg/api"
 	"github.com/DataDog/pupernetes/pkg/logging"
+	"github.com/DataDog/pupernetes/pkg/run/state"
 	"github.com/DataDog/pupernetes/pkg/setup"
 	"github.com/DataDog/pupernetes/pkg/util"
 	"github.com/golang/glog"
@@ -19,10 +21,27 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 )
 
+// stopResources are the workload resources deleted on stop, the other ones like the leases, the roles or the events
+// are left to the control plane
+var stopResources = []string{
+	"deployments.apps",
+	"daemonsets.apps",
+	"replicasets.apps",
+	"replicationcontrollers",
+	"jobs.batch",
+	"pods",
+	"services",
+	"endpoints",
+	"configmaps",
+	"secrets",
+	"serviceaccounts",
+}
+
 func (r *Runtime) getNamespaces() (*corev1.NamespaceList, error) {
 	ns, err := r.env.GetKubernetesClient().CoreV1().Namespaces().List(v1.ListOptions{})
 	if err != nil {
@@ -41,7 +60,8 @@ func (r *Runtime) gracefulDeleteAPIResources() error {
 	glog.Infof("Graceful deleting API resources ...")
 	ns, err := r.getNamespaces()
 	if err == nil {
-		r.DeleteAPIManifests(ns)
+		// the remaining pods are polled below
+		r.deleteAPIResources(ns, &api.ResetOptions{Include: stopResources})
 	}
 
 	stateTicker := time.NewTicker(1 * time.Second)
@@ -66,6 +86,7 @@ func (r *Runtime) gracefulDeleteAPIResources() error {
 				return nil
 			}
 			glog.V(3).Infof("Kubelet still reports %d API Pods", len(stillRunningPods)-len(staticPods))
+			r.events.Publish(api.EventPodsRemaining, map[string]string{"kind": "api", "pods": strconv.Itoa(len(stillRunningPods) - len(staticPods))})
 
 		case <-timeout.C:
 			err := fmt.Errorf("timeout reached during delete API resources")
@@ -82,6 +103,7 @@ func (r *Runtime) drainingPods() error {
 		return nil
 	}
 	glog.Infof("Draining kubelet's pods ...")
+	r.events.Publish(api.EventDrainStarted, nil)
 
 	err := r.gracefulDeleteAPIResources()
 	if err != nil {
@@ -138,6 +160,7 @@ func (r *Runtime) drainingPods() error {
 			}
 			if len(remainStaticPods) != 0 {
 				glog.V(2).Infof("Kubelet still has static pods running: %d", len(remainStaticPods))
+				r.events.Publish(api.EventPodsRemaining, map[string]string{"kind": "static", "pods": strconv.Itoa(len(remainStaticPods))})
 				continue
 			}
 			podLogs, err := ioutil.ReadDir(setup.KubeletCRILogPath)
@@ -147,6 +170,7 @@ func (r *Runtime) drainingPods() error {
 			}
 			if len(podLogs) != 0 {
 				glog.V(2).Infof("Kubelet still has %d pods in %s", len(podLogs), setup.KubeletCRILogPath)
+				r.events.Publish(api.EventPodsRemaining, map[string]string{"kind": "logs", "pods": strconv.Itoa(len(podLogs))})
 				continue
 			}
 			glog.V(2).Infof("Kubelet GC all pods")
@@ -205,21 +229,39 @@ func (r *Runtime) runJournalTailers(failedUnits []string) error {
 	return fmt.Errorf("failed to start journal tailers: %s", strings.Join(errs, ", "))
 }
 
+func (r *Runtime) publishStopped(err error) {
+	if err == nil {
+		r.events.Publish(api.EventStopped, nil)
+		return
+	}
+	r.events.Publish(api.EventStopped, map[string]string{"error": err.Error()})
+}
+
 // Stop drain and tear down the current runtime, if withError is set, this error will be returned
 func (r *Runtime) Stop(withError error) error {
 	// reset run signals
 	signal.Reset(syscall.SIGTERM, syscall.SIGINT)
+	// the event streams end on every path
+	defer r.events.Close()
 
 	if r.env.IsSkippingStop() {
 		glog.Infof("Skipping stop")
+		r.publishStopped(withError)
 		return withError
 	}
+	r.state.SetPhase(state.PhaseStopping)
+	defer r.state.SetPhase(state.PhaseStopped)
 
 	var errs []string
 	if withError != nil {
 		errs = append(errs, withError.Error())
 	}
-	err := r.drainingPods()
+	// CoreDNS is stopped with the pods
+	err := r.env.RemoveHostDNSNameserver()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	err = r.drainingPods()
 	if err != nil {
 		glog.Errorf("Failed to drain the node: %v", err)
 		errs = append(errs, err.Error())
@@ -250,9 +292,11 @@ func (r *Runtime) Stop(withError error) error {
 	// iptables always fail
 	r.cleanIptables()
 	if len(errs) == 0 {
+		r.publishStopped(withError)
 		return withError
 	}
 	err = fmt.Errorf("errors during stop: %s", strings.Join(errs, ", "))
 	glog.Errorf("Unexpected errors: %v", err)
+	r.publishStopped(err)
 	return err
 }
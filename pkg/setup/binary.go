@@ -12,20 +12,45 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
-	"os/signal"
-	"syscall"
+
+	"github.com/DataDog/pupernetes/pkg/cache"
 )
 
 type depBinary struct {
+	// name of the archive in logs and metrics
+	name            string
 	archivePath     string
 	binaryABSPath   string
 	archiveURL      string
 	version         string
 	downloadTimeout time.Duration
+
+	// downloadRetries is the number of retries after a failed download,
+	// the delay between them doubles from downloadRetryDelay
+	downloadRetries    int
+	downloadRetryDelay time.Duration
+
+	// checksum is the user given digest or checksum file URL
+	checksum string
+	// checksumURL is the upstream checksum file of the archive
+	checksumURL string
+	// pinnedChecksum is the built-in digest of the upstream archive, empty if unknown
+	pinnedChecksum string
+	// requireChecksum fails the download when no digest is available
+	requireChecksum bool
+
+	// cache is the host-wide download cache, nil if disabled
+	cache *cache.Cache
+
+	// proxy of the downloads, nil to use the environment
+	proxy *proxyConfig
 }
 
 type exeBinary struct {
@@ -34,8 +59,6 @@ type exeBinary struct {
 	commandVersion    []string
 }
 
-const downloadBinaryRetryDelay = time.Second * 5
-
 func (x *exeBinary) isUpToDate() bool {
 	if x.skipVersionVerify {
 		glog.V(4).Info("Skipping the verification of the version")
@@ -56,74 +79,224 @@ func (x *exeBinary) isUpToDate() bool {
 	return false
 }
 
+// progressWriter records the progress of a download in the metrics
+type progressWriter struct {
+	name    string
+	written int64
+	total   int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	promDownloadBytes.WithLabelValues(p.name).Add(float64(len(b)))
+	if p.total > 0 {
+		promDownloadProgress.WithLabelValues(p.name).Set(float64(p.written) / float64(p.total))
+	}
+	return len(b), nil
+}
+
+// partialPath is where the archive is downloaded before being complete
+func (d *depBinary) partialPath() string {
+	return d.archivePath + ".part"
+}
+
 func (d *depBinary) downloadToFile() error {
+	partialPath := d.partialPath()
+	req, err := http.NewRequest(http.MethodGet, d.archiveURL, nil)
+	if err != nil {
+		glog.Errorf("Cannot create the request for %s: %v", d.archiveURL, err)
+		return err
+	}
+	var offset int64
+	fi, err := os.Stat(partialPath)
+	if err == nil && fi.Size() > 0 {
+		offset = fi.Size()
+		glog.V(2).Infof("Resuming the download of %s from %d bytes", d.archiveURL, offset)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
 	glog.V(2).Infof("Downloading the archive %s to %s with a timeout of %s", d.archiveURL, d.archivePath, d.downloadTimeout.String())
-	client := &http.Client{Timeout: d.downloadTimeout}
-	resp, err := client.Get(d.archiveURL)
+	start := time.Now()
+	resp, err := newDownloadClient(d.downloadTimeout, d.proxy).Do(req)
 	if err != nil {
 		glog.Errorf("Cannot download %s: %v", d.archiveURL, err)
 		return err
 	}
-	if resp.StatusCode != http.StatusOK {
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			glog.V(2).Infof("Range requests not supported for %s, restarting the download", d.archiveURL)
+		}
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial download doesn't match the remote archive anymore
+		_ = os.Remove(partialPath)
+		err = fmt.Errorf("cannot resume the download from %d bytes", offset)
+		glog.Errorf("Cannot download %s: %v", d.archiveURL, err)
+		return err
+	default:
 		err = fmt.Errorf("status code: %d", resp.StatusCode)
 		glog.Errorf("Cannot download %s, status code != 200, %s", d.archiveURL, err)
 		return err
 	}
 
-	f, err := os.OpenFile(d.archivePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0400)
+	f, err := os.OpenFile(partialPath, flags, 0600)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			glog.Errorf("Cannot open %s: %v", d.archivePath, err)
-			return err
-		}
+		glog.Errorf("Cannot open %s: %v", partialPath, err)
+		return err
 	}
 	defer f.Close()
+
+	progress := &progressWriter{name: d.name, written: offset}
+	if resp.ContentLength > 0 {
+		progress.total = offset + resp.ContentLength
+		promDownloadSize.WithLabelValues(d.name).Set(float64(progress.total))
+	}
 	dst := bufio.NewWriter(f)
+	_, err = io.Copy(dst, io.TeeReader(resp.Body, progress))
+	if err != nil {
+		glog.Errorf("Cannot write %s in %s after %d bytes: %v", d.archiveURL, partialPath, progress.written, err)
+		dst.Flush()
+		return err
+	}
+	err = dst.Flush()
+	if err != nil {
+		glog.Errorf("Cannot write %s: %v", partialPath, err)
+		return err
+	}
+	err = os.Chmod(partialPath, 0400)
+	if err != nil {
+		glog.Errorf("Cannot chmod %s: %v", partialPath, err)
+		return err
+	}
+	err = os.Rename(partialPath, d.archivePath)
+	if err != nil {
+		glog.Errorf("Cannot move %s to %s: %v", partialPath, d.archivePath, err)
+		return err
+	}
+	promDownloadDuration.WithLabelValues(d.name).Set(time.Since(start).Seconds())
+	glog.V(2).Infof("Successfully downloaded %s to %s: %d bytes in %s", d.archiveURL, d.archivePath, progress.written, time.Since(start).String())
+	return nil
+}
 
-	_, err = io.Copy(dst, resp.Body)
+func (d *depBinary) downloadAndVerify(expected *checksum) error {
+	err := d.downloadToFile()
 	if err != nil {
-		glog.Errorf("Cannot write %s in %s: %v", resp.Request.URL.RawQuery, f.Name(), err)
+		glog.Errorf("Fail to download %s: %v", d.archiveURL, err)
 		return err
 	}
-	glog.V(2).Infof("Successfully downloaded %s to %s", d.archiveURL, d.archivePath)
-	return dst.Flush()
+	err = d.verifyArchive(expected)
+	if err != nil {
+		_ = d.removeArchive()
+		return err
+	}
+	return nil
+}
+
+func cacheChecksum(c *checksum) string {
+	if c == nil {
+		return ""
+	}
+	return c.String()
+}
+
+// getFromCache returns true when a valid archive has been linked from the cache
+func (d *depBinary) getFromCache(expected *checksum) bool {
+	if d.cache == nil {
+		return false
+	}
+	found, err := d.cache.Get(d.archiveURL, cacheChecksum(expected), d.archivePath)
+	if err != nil || !found {
+		return false
+	}
+	err = d.verifyArchive(expected)
+	if err == nil {
+		return true
+	}
+	glog.Warningf("Evicting the invalid cached archive of %s", d.archiveURL)
+	_ = d.removeArchive()
+	_ = d.cache.Remove(d.archiveURL, cacheChecksum(expected))
+	return false
+}
+
+func (d *depBinary) putInCache(expected *checksum) {
+	if d.cache == nil {
+		return
+	}
+	err := d.cache.Put(d.archiveURL, cacheChecksum(expected), d.archivePath)
+	if err != nil {
+		glog.Warningf("Cannot cache %s: %v", d.archivePath, err)
+	}
 }
 
 func (d *depBinary) download() error {
-	_, err := os.Stat(d.archivePath)
+	expected, err := d.getExpectedChecksum()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(d.archivePath)
 	if err == nil {
-		glog.V(2).Infof("Archive already here: %s", d.archivePath)
+		err = d.verifyArchive(expected)
+		if err == nil {
+			glog.V(2).Infof("Archive already here: %s", d.archivePath)
+			return nil
+		}
+		glog.Warningf("Removing the invalid archive %s", d.archivePath)
+		err = d.removeArchive()
+		if err != nil {
+			return err
+		}
+	}
+	if d.getFromCache(expected) {
 		return nil
 	}
 
-	sigChan := make(chan os.Signal)
+	sigChan := make(chan os.Signal, 1)
 	defer close(sigChan)
 
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
-	defer signal.Reset(syscall.SIGTERM, syscall.SIGINT)
-	errChan := make(chan error)
-	defer close(errChan)
+	// other archives can be downloading concurrently
+	defer signal.Stop(sigChan)
+	errChan := make(chan error, 1)
 
 	go func(ch chan error) {
-		err = d.downloadToFile()
-		if err != nil {
-			glog.Errorf("Fail to download %s: %v", d.archiveURL, err)
-			glog.Infof("Retrying to download in %s ...", downloadBinaryRetryDelay.String())
-			time.Sleep(downloadBinaryRetryDelay)
-			// we don't need to delete the file as we open it with O_TRUNC
-			errChan <- d.downloadToFile()
+		var err error
+		delay := d.downloadRetryDelay
+		for attempt := 0; attempt <= d.downloadRetries; attempt++ {
+			if attempt > 0 {
+				glog.Infof("Retrying to download %s in %s (%d/%d) ...", d.archiveURL, delay.String(), attempt, d.downloadRetries)
+				time.Sleep(delay)
+				delay *= 2
+				promDownloadRetries.WithLabelValues(d.name).Inc()
+			}
+			// a partial download is resumed by the next attempt
+			err = d.downloadAndVerify(expected)
+			if err == nil {
+				ch <- nil
+				return
+			}
 		}
-		errChan <- nil
+		ch <- fmt.Errorf("cannot download a valid archive from %s after %d attempts: %v", d.archiveURL, d.downloadRetries+1, err)
 	}(errChan)
 
 	select {
 	case s := <-sigChan:
-		glog.Warningf("Received signal %q, %s is probably incomplete, removing", s.String(), d.archivePath)
-		_ = d.removeArchive()
+		glog.Warningf("Received signal %q, keeping the incomplete %s to resume the download later", s.String(), d.partialPath())
 		return fmt.Errorf("cannot download, signal received: %q", s.String())
 
 	case err := <-errChan:
-		return err
+		if err != nil {
+			return err
+		}
+		d.putInCache(expected)
+		return nil
 	}
 }
 
@@ -136,3 +309,38 @@ func (d *depBinary) removeArchive() error {
 	glog.V(2).Infof("Removed %s", d.archivePath)
 	return nil
 }
+
+// setupBinaries downloads and extracts the binaries concurrently
+func (e *Environment) setupBinaries() error {
+	setupFns := []func() error{
+		e.setupBinaryCNI,
+		e.setupBinaryEtcd,
+		e.setupBinaryContainerd,
+		e.setupBinaryRunc,
+		e.setupBinaryVault,
+		e.setupBinaryHyperkube,
+	}
+	errs := make([]error, len(setupFns))
+	var wg sync.WaitGroup
+	for i, fn := range setupFns {
+		wg.Add(1)
+		go func(i int, fn func() error) {
+			defer wg.Done()
+			errs[i] = fn()
+		}(i, fn)
+	}
+	wg.Wait()
+
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		err := fmt.Errorf("cannot setup the binaries: %s", strings.Join(msgs, ", "))
+		glog.Errorf("Unexpected error: %v", err)
+		return err
+	}
+	return nil
+}
@@ -8,31 +8,12 @@ package setup
 import (
 	"fmt"
 	"os"
-	"os/exec"
-	"strings"
 
 	"github.com/golang/glog"
 )
 
 func (e *Environment) extractCNI() error {
-	glog.V(2).Infof("Extracting %s", e.binaryCNI.archivePath)
-	b, err := exec.Command("tar", "-C", e.binABSPath, "-xzvf", e.binaryCNI.archivePath).CombinedOutput()
-	output := string(b)
-	if err != nil {
-		glog.Errorf("Cannot untar %s, %s: %v", e.binaryCNI.archivePath, output, err)
-		_ = e.binaryCNI.removeArchive()
-		return err
-	}
-	_, err = os.Stat(e.binaryCNI.binaryABSPath)
-	if err != nil {
-		glog.Errorf("Unexpected error: %v after untar %s", err, output)
-		return err
-	}
-	output = strings.Replace(output, "./", "", -1)
-	output = strings.Replace(output, "\n", " ", -1)
-	output = strings.TrimPrefix(output, " ")
-	glog.V(2).Infof("Successfully untar %s: %s", e.binaryCNI.archivePath, output)
-	return err
+	return e.binaryCNI.extractArchive(e.binABSPath, &extractOptions{})
 }
 
 func (e *Environment) setupBinaryCNI() error {
@@ -8,7 +8,6 @@ package setup
 import (
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/golang/glog"
 
@@ -16,21 +15,7 @@ import (
 )
 
 func (e *Environment) extractContainerd() error {
-	glog.V(2).Infof("Extracting %s", e.binaryContainerd.archivePath)
-	b, err := exec.Command("tar", "-C", e.binABSPath, "-xzvf", e.binaryContainerd.archivePath, "--strip-components=1").CombinedOutput()
-	output := string(b)
-	if err != nil {
-		glog.Errorf("Cannot untar %s, %s: %v", e.binaryEtcd.archivePath, output, err)
-		_ = e.binaryContainerd.removeArchive()
-		return err
-	}
-	_, err = os.Stat(e.binaryContainerd.binaryABSPath)
-	if err != nil {
-		glog.Errorf("Unexpected error: %v after untar %s", err, output)
-		return err
-	}
-	glog.V(2).Infof("Successfully untar %s: %s", e.binaryContainerd.archivePath, output)
-	return err
+	return e.binaryContainerd.extractArchive(e.binABSPath, &extractOptions{stripComponents: 1})
 }
 
 func (e *Environment) setupBinaryContainerd() error {
@@ -8,28 +8,15 @@ package setup
 import (
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/golang/glog"
 )
 
 func (e *Environment) extractEtcd() error {
-	glog.V(2).Infof("Extracting %s", e.binaryEtcd.archivePath)
-	b, err := exec.Command("tar", "-C", e.binABSPath, "-xzvf", e.binaryEtcd.archivePath, "--strip-components=1", "--warning=no-unknown-keyword",
-		fmt.Sprintf("etcd-v%s-linux-amd64/etcd", e.binaryEtcd.version)).CombinedOutput()
-	output := string(b)
-	if err != nil {
-		glog.Errorf("Cannot untar %s, %s: %v", e.binaryEtcd.archivePath, output, err)
-		_ = e.binaryEtcd.removeArchive()
-		return err
-	}
-	_, err = os.Stat(e.binaryEtcd.binaryABSPath)
-	if err != nil {
-		glog.Errorf("Unexpected error: %v after untar %s", err, output)
-		return err
-	}
-	glog.V(2).Infof("Successfully untar %s: %s", e.binaryEtcd.archivePath, output)
-	return err
+	return e.binaryEtcd.extractArchive(e.binABSPath, &extractOptions{
+		stripComponents: 1,
+		files:           []string{"etcd"},
+	})
 }
 
 func (e *Environment) setupBinaryEtcd() error {
@@ -8,7 +8,7 @@ package setup
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"strings"
 	"text/template"
 
 	"github.com/Masterminds/semver"
@@ -16,12 +16,25 @@ import (
 	"github.com/golang/glog"
 )
 
+// hyperkubeArchiveFiles are the binaries used from the kubernetes/server/bin directory of the archive
+var hyperkubeArchiveFiles = []string{
+	"hyperkube",
+	"kube-apiserver",
+	"kube-controller-manager",
+	"kube-proxy",
+	"kube-scheduler",
+	"kubectl",
+	"kubelet",
+}
+
 func (e *Environment) extractHyperkube() error {
 	glog.V(2).Infof("Extracting %s", e.binaryHyperkube.archivePath)
-	b, err := exec.Command("tar", "-C", e.binABSPath, "-xzvf", e.binaryHyperkube.archivePath, "--strip-components=3").CombinedOutput()
-	output := string(b)
+	extracted, err := extractTarGz(e.binaryHyperkube.archivePath, e.binABSPath, &extractOptions{
+		stripComponents: 3,
+		files:           hyperkubeArchiveFiles,
+	})
 	if err != nil {
-		glog.Errorf("Cannot untar %s, %s: %v", e.binaryHyperkube.archivePath, output, err)
+		glog.Errorf("Cannot extract %s: %v", e.binaryHyperkube.archivePath, err)
 		_ = e.binaryHyperkube.removeArchive()
 		return err
 	}
@@ -61,10 +74,10 @@ func (e *Environment) extractHyperkube() error {
 
 	_, err = os.Stat(e.binaryHyperkube.binaryABSPath)
 	if err != nil {
-		glog.Errorf("Unexpected error: %v after untar %s", err, output)
+		glog.Errorf("Unexpected error: %v after extracting %s", err, e.binaryHyperkube.archivePath)
 		return err
 	}
-	glog.V(2).Infof("Successfully untar %s: %s", e.binaryHyperkube.archivePath, output)
+	glog.V(2).Infof("Successfully extracted %s: %s", e.binaryHyperkube.archivePath, strings.Join(extracted, " "))
 	return nil
 }
 
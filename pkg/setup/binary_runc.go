@@ -8,7 +8,6 @@ package setup
 import (
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/golang/glog"
 
@@ -17,25 +16,20 @@ import (
 
 func (e *Environment) extractRunc() error {
 	glog.V(2).Infof("Copying %s", e.binaryRunc.archivePath)
-	b, err := exec.Command("cp", "-v", e.binaryRunc.archivePath, e.binaryRunc.binaryABSPath).CombinedOutput()
-	output := string(b)
+	f, err := os.Open(e.binaryRunc.archivePath)
 	if err != nil {
-		glog.Errorf("Cannot cp %s, %s: %v", e.binaryEtcd.archivePath, output, err)
+		glog.Errorf("Cannot open %s: %v", e.binaryRunc.archivePath, err)
 		_ = e.binaryRunc.removeArchive()
 		return err
 	}
-	_, err = os.Stat(e.binaryRunc.binaryABSPath)
+	defer f.Close()
+	err = writeFile(e.binaryRunc.binaryABSPath, f, 0700)
 	if err != nil {
-		glog.Errorf("Unexpected error: %v after cp %s", err, output)
+		glog.Errorf("Cannot copy %s to %s: %v", e.binaryRunc.archivePath, e.binaryRunc.binaryABSPath, err)
 		return err
 	}
-	err = os.Chmod(e.binaryRunc.binaryABSPath, 0700)
-	if err != nil {
-		glog.Errorf("Cannot chmod +x %s: %v", e.binaryRunc.binaryABSPath, err)
-		return err
-	}
-	glog.V(2).Infof("Successfully cp %s: %s", e.binaryRunc.archivePath, output)
-	return err
+	glog.V(2).Infof("Successfully copied %s to %s", e.binaryRunc.archivePath, e.binaryRunc.binaryABSPath)
+	return nil
 }
 
 func (e *Environment) setupBinaryRunc() error {
@@ -0,0 +1,84 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadToFileResume(t *testing.T) {
+	content := []byte("hello")
+	var ranges []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "archive.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "p8s-binary")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := &depBinary{
+		name:            "etcd",
+		archiveURL:      s.URL + "/archive.tar.gz",
+		archivePath:     path.Join(dir, "archive.tar.gz"),
+		downloadTimeout: time.Second,
+	}
+	require.NoError(t, ioutil.WriteFile(d.partialPath(), content[:2], 0600))
+	require.NoError(t, d.downloadToFile())
+
+	assert.Equal(t, []string{"bytes=2-"}, ranges)
+	b, err := ioutil.ReadFile(d.archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+	_, err = os.Stat(d.partialPath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadRetries(t *testing.T) {
+	calls := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "p8s-binary")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := &depBinary{
+		name:               "etcd",
+		archiveURL:         s.URL + "/archive.tar.gz",
+		archivePath:        path.Join(dir, "archive.tar.gz"),
+		downloadTimeout:    time.Second,
+		checksum:           "sha256:" + helloSHA256,
+		downloadRetries:    1,
+		downloadRetryDelay: time.Millisecond,
+	}
+	assert.Error(t, d.download())
+	assert.Equal(t, 2, calls)
+
+	d.downloadRetries = 2
+	calls = 0
+	require.NoError(t, d.download())
+	assert.Equal(t, 3, calls)
+}
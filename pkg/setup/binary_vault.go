@@ -8,30 +8,21 @@ package setup
 import (
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/golang/glog"
+
+	"github.com/DataDog/pupernetes/pkg/config"
 )
 
 func (e *Environment) extractVault() error {
-	glog.V(2).Infof("Extracting %s", e.binaryVault.archivePath)
-	b, err := exec.Command("unzip", e.binaryVault.archivePath, "-d", e.binABSPath).CombinedOutput()
-	output := string(b)
-	if err != nil {
-		glog.Errorf("Cannot unzip %s, %s: %v", e.binaryVault.archivePath, output, err)
-		_ = e.binaryVault.removeArchive()
-		return err
-	}
-	_, err = os.Stat(e.binaryVault.binaryABSPath)
-	if err != nil {
-		glog.Errorf("Unexpected error: %v after unzip %s", err, output)
-		return err
-	}
-	glog.V(2).Infof("Successfully unzip %s: %s", e.binaryVault.archivePath, output)
-	return err
+	return e.binaryVault.extractArchive(e.binABSPath, &extractOptions{files: []string{"vault"}})
 }
 
 func (e *Environment) setupBinaryVault() error {
+	if e.pkiBackend != config.PKIVault {
+		glog.V(2).Infof("Skipping the download of vault: using the %q pki backend", e.pkiBackend)
+		return nil
+	}
 	_, err := os.Stat(e.binaryVault.binaryABSPath)
 	if err == nil && e.binaryVault.isUpToDate() {
 		glog.V(4).Infof("Vault already setup and up to date: %s", e.binaryVault.binaryABSPath)
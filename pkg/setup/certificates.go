@@ -0,0 +1,371 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	"github.com/DataDog/pupernetes/pkg/api"
+	"github.com/DataDog/pupernetes/pkg/pki"
+)
+
+const (
+	certificateAPIServer              = "apiserver"
+	certificateAPIServerKubeletClient = "apiserver-kubelet-client"
+	certificateFrontProxyClient       = "front-proxy-client"
+	certificateKubelet                = "kubelet"
+	certificateControllerManager      = "kube-controller-manager"
+	certificateScheduler              = "kube-scheduler"
+	certificateAdmin                  = "admin"
+	certificateEtcd                   = "etcd"
+	certificatePupernetesAPI          = "pupernetes-api"
+	certificatePupernetesAPIClient    = "pupernetes-api-client"
+
+	// secretsMountPath is where the secrets directory is mounted in the control plane pods
+	secretsMountPath = "/etc/secrets"
+	kubeconfigSuffix = ".kubeconfig"
+)
+
+// certificateNames are the certificates issued against the pupernetes root CA
+var certificateNames = []string{
+	certificateAPIServer,
+	certificateAPIServerKubeletClient,
+	certificateFrontProxyClient,
+	certificateKubelet,
+	certificateControllerManager,
+	certificateScheduler,
+	certificateAdmin,
+	certificateEtcd,
+	certificatePupernetesAPI,
+	certificatePupernetesAPIClient,
+}
+
+// componentKubeconfigs are the control plane pods authenticating with their certificate
+var componentKubeconfigs = []string{
+	certificateControllerManager,
+	certificateScheduler,
+}
+
+var (
+	serverAuth = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	clientAuth = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+)
+
+// certificateSpec is a certificate written as <name>.certificate, <name>.issuing_ca and <name>.private_key
+type certificateSpec struct {
+	name    string
+	request *pki.Request
+}
+
+// parseSANs splits the subject alternative names between the IP addresses and the DNS names
+func parseSANs(sans []string) ([]string, []net.IP) {
+	var dnsNames []string
+	var ips []net.IP
+	for _, san := range sans {
+		san = strings.TrimSpace(san)
+		if san == "" {
+			continue
+		}
+		ip := net.ParseIP(san)
+		if ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+		dnsNames = append(dnsNames, san)
+	}
+	return dnsNames, ips
+}
+
+// certificateSpecs returns the certificates of each component with their identity and SANs
+func (e *Environment) certificateSpecs() []certificateSpec {
+	localhost := net.ParseIP("127.0.0.1")
+	nodeIP := *e.outboundIP
+
+	specs := []certificateSpec{
+		{
+			name: certificateAPIServer,
+			request: &pki.Request{
+				CommonName: "kube-apiserver",
+				DNSNames: append([]string{
+					"localhost",
+					e.hostname,
+					"kubernetes",
+					"kubernetes.default",
+					"kubernetes.default.svc",
+					"kubernetes.default.svc." + e.clusterDomain,
+				}, e.extraSANsDNSNames...),
+				IPAddresses:  append(append([]net.IP{localhost, nodeIP}, e.kubernetesClusterIPs...), e.extraSANsIPAddresses...),
+				ExtKeyUsages: serverAuth,
+			},
+		},
+		{
+			name: certificateAPIServerKubeletClient,
+			request: &pki.Request{
+				CommonName:   "kube-apiserver-kubelet-client",
+				Organization: []string{"system:masters"},
+				ExtKeyUsages: clientAuth,
+			},
+		},
+		{
+			name: certificateFrontProxyClient,
+			request: &pki.Request{
+				CommonName:   "front-proxy-client",
+				ExtKeyUsages: clientAuth,
+			},
+		},
+		{
+			name: certificateKubelet,
+			request: &pki.Request{
+				CommonName:   "system:node:" + e.hostname,
+				Organization: []string{"system:nodes"},
+				DNSNames:     []string{"localhost", e.hostname},
+				IPAddresses:  []net.IP{localhost, nodeIP},
+				ExtKeyUsages: serverAuth,
+			},
+		},
+		{
+			name: certificateControllerManager,
+			request: &pki.Request{
+				CommonName:   "system:kube-controller-manager",
+				ExtKeyUsages: clientAuth,
+			},
+		},
+		{
+			name: certificateScheduler,
+			request: &pki.Request{
+				CommonName:   "system:kube-scheduler",
+				ExtKeyUsages: clientAuth,
+			},
+		},
+		{
+			name: certificateAdmin,
+			request: &pki.Request{
+				CommonName:   defaultKubectlUserName,
+				Organization: []string{"system:masters"},
+				ExtKeyUsages: clientAuth,
+			},
+		},
+		{
+			name: certificateEtcd,
+			request: &pki.Request{
+				CommonName:  "etcd",
+				DNSNames:    append([]string{"localhost", e.hostname}, e.extraSANsDNSNames...),
+				IPAddresses: append([]net.IP{localhost, nodeIP}, e.extraSANsIPAddresses...),
+			},
+		},
+		{
+			name: certificatePupernetesAPI,
+			request: &pki.Request{
+				CommonName:   "pupernetes-api",
+				DNSNames:     append([]string{"localhost", e.hostname}, e.extraSANsDNSNames...),
+				IPAddresses:  append([]net.IP{localhost, nodeIP}, e.extraSANsIPAddresses...),
+				ExtKeyUsages: serverAuth,
+			},
+		},
+		{
+			name: certificatePupernetesAPIClient,
+			request: &pki.Request{
+				CommonName:   api.TLSClientCommonName,
+				Organization: []string{api.TLSClientOrganization},
+				ExtKeyUsages: clientAuth,
+			},
+		},
+	}
+	for _, spec := range specs {
+		spec.request.TTL = e.certificateTTL
+	}
+	return specs
+}
+
+// isSuppliedRootCA returns false if the root CA ca isn't the one given with --ca-certificate
+func (e *Environment) isSuppliedRootCA(ca *pki.CA) bool {
+	if e.caCertificatePath == "" {
+		return true
+	}
+	supplied, err := pki.LoadCAFiles(e.caCertificatePath, e.caPrivateKeyPath)
+	if err != nil {
+		return false
+	}
+	if !supplied.Certificate.Equal(ca.Certificate) {
+		glog.V(2).Infof("The root CA in %s isn't the one of %s", e.secretsABSPath, e.caCertificatePath)
+		return false
+	}
+	return true
+}
+
+// getRootCA loads the root CA of the secrets directory, imports the supplied one or generates it
+func (e *Environment) getRootCA() (*pki.CA, error) {
+	ca, err := pki.LoadCA(e.secretsABSPath, rootCertificateAuthorityName)
+	if err == nil && e.isSuppliedRootCA(ca) {
+		glog.V(4).Infof("Using the root CA already in %s", e.secretsABSPath)
+		return ca, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Cannot load the root CA in %s: %v", e.secretsABSPath, err)
+		return nil, err
+	}
+	if e.caCertificatePath != "" {
+		glog.V(3).Infof("Importing the root CA %s", e.caCertificatePath)
+		ca, err = pki.LoadCAFiles(e.caCertificatePath, e.caPrivateKeyPath)
+		if err != nil {
+			glog.Errorf("Cannot load the root CA %s: %v", e.caCertificatePath, err)
+			return nil, err
+		}
+	} else {
+		glog.V(3).Infof("Creating %s root CA", rootCertificateAuthorityName)
+		ca, err = pki.NewRootCA(rootCertificateCommonName, e.caTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = ca.WriteFiles(e.secretsABSPath, rootCertificateAuthorityName, 0400)
+	if err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+func (e *Environment) generateNativeSecrets() error {
+	ca, err := e.getRootCA()
+	if err != nil {
+		return err
+	}
+	for _, spec := range e.certificateSpecs() {
+		kp, err := pki.LoadKeyPair(e.secretsABSPath, spec.name)
+		if err == nil {
+			err = ca.Verify(kp, spec.request)
+			if err == nil {
+				glog.V(4).Infof("Certificate of %s already here and valid", spec.name)
+				err = pki.RestrictPrivateKeyFile(e.secretsABSPath, spec.name)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			glog.V(2).Infof("Renewing the certificate of %s: %v", spec.name, err)
+		}
+		kp, err = ca.Issue(spec.request)
+		if err != nil {
+			glog.Errorf("Unexpected error during the secret generation of %s: %v", spec.name, err)
+			return err
+		}
+		err = kp.WriteFiles(e.secretsABSPath, spec.name, 0444)
+		if err != nil {
+			return err
+		}
+		glog.V(3).Infof("Issued the certificate of %s valid until %s", spec.name, kp.Certificate.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// generateComponentKubeconfigs writes the kubeconfig of the control plane pods,
+// the paths are the ones of the secrets directory mounted in the pods
+func (e *Environment) generateComponentKubeconfigs() error {
+	for _, name := range componentKubeconfigs {
+		kubeconfig := &clientcmdapi.Config{
+			APIVersion: "v1",
+			Kind:       "Config",
+			Clusters: []clientcmdapi.NamedCluster{
+				{
+					Name: defaultKubectlClusterName,
+					Cluster: clientcmdapi.Cluster{
+						Server:               "https://127.0.0.1:6443",
+						CertificateAuthority: pki.FilePath(secretsMountPath, rootCertificateAuthorityName, pki.IssuingCASuffix),
+					},
+				},
+			},
+			AuthInfos: []clientcmdapi.NamedAuthInfo{
+				{
+					Name: name,
+					AuthInfo: clientcmdapi.AuthInfo{
+						ClientCertificate: pki.FilePath(secretsMountPath, name, pki.CertificateSuffix),
+						ClientKey:         pki.FilePath(secretsMountPath, name, pki.PrivateKeySuffix),
+					},
+				},
+			},
+			Contexts: []clientcmdapi.NamedContext{
+				{
+					Name: defaultKubectlContextName,
+					Context: clientcmdapi.Context{
+						Cluster:  defaultKubectlClusterName,
+						AuthInfo: name,
+					},
+				},
+			},
+			CurrentContext: defaultKubectlContextName,
+		}
+		b, err := yaml.Marshal(kubeconfig)
+		if err != nil {
+			glog.Errorf("Cannot marshal the kubeconfig of %s: %v", name, err)
+			return err
+		}
+		kubeconfigPath := path.Join(e.secretsABSPath, name+kubeconfigSuffix)
+		err = ioutil.WriteFile(kubeconfigPath, b, 0644)
+		if err != nil {
+			glog.Errorf("Cannot write the kubeconfig of %s: %v", name, err)
+			return err
+		}
+		glog.V(4).Infof("Successfully created %s", kubeconfigPath)
+	}
+	return nil
+}
+
+// ListCertificates describes the root CA and the certificates written in the secrets directory
+func (e *Environment) ListCertificates() ([]pki.Info, error) {
+	var infos []pki.Info
+	for _, name := range append([]string{rootCertificateAuthorityName}, certificateNames...) {
+		certPath := pki.FilePath(e.secretsABSPath, name, pki.CertificateSuffix)
+		b, err := ioutil.ReadFile(certPath)
+		if os.IsNotExist(err) {
+			glog.V(4).Infof("No certificate for %s: %s", name, certPath)
+			continue
+		}
+		if err != nil {
+			glog.Errorf("Cannot read the certificate of %s: %v", name, err)
+			return nil, err
+		}
+		cert, err := pki.ParseCertificatePEM(b)
+		if err != nil {
+			glog.Errorf("Invalid certificate %s: %v", certPath, err)
+			return nil, err
+		}
+		infos = append(infos, *pki.Describe(name, cert))
+	}
+	promCertificateExpiry.set(infos)
+	return infos, nil
+}
+
+// RotateCertificates reissues every leaf certificate from the root CA of the secrets directory
+func (e *Environment) RotateCertificates() ([]pki.Info, error) {
+	ca, err := pki.LoadCA(e.secretsABSPath, rootCertificateAuthorityName)
+	if err != nil {
+		glog.Errorf("Cannot rotate the certificates without the root CA in %s: %v", e.secretsABSPath, err)
+		return nil, err
+	}
+	for _, spec := range e.certificateSpecs() {
+		kp, err := ca.Issue(spec.request)
+		if err != nil {
+			glog.Errorf("Cannot rotate the certificate of %s: %v", spec.name, err)
+			return nil, err
+		}
+		err = kp.WriteFiles(e.secretsABSPath, spec.name, 0444)
+		if err != nil {
+			return nil, err
+		}
+		glog.V(2).Infof("Rotated the certificate of %s valid until %s", spec.name, kp.Certificate.NotAfter.Format(time.RFC3339))
+	}
+	return e.ListCertificates()
+}
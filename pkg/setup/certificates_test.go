@@ -0,0 +1,193 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	"github.com/DataDog/pupernetes/pkg/pki"
+)
+
+func TestSetupSecretsDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := &Environment{secretsABSPath: path.Join(dir, defaultSecretDirName)}
+	require.NoError(t, e.setupSecretsDirectory())
+	fi, err := os.Stat(e.secretsABSPath)
+	require.NoError(t, err)
+	assert.Equal(t, secretsDirPerm, fi.Mode().Perm())
+
+	// created world readable by a previous version
+	require.NoError(t, os.Chmod(e.secretsABSPath, 0777))
+	require.NoError(t, e.setupSecretsDirectory())
+	fi, err = os.Stat(e.secretsABSPath)
+	require.NoError(t, err)
+	assert.Equal(t, secretsDirPerm, fi.Mode().Perm())
+}
+
+func TestGenerateNativeSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	outboundIP := net.ParseIP("192.168.254.1")
+	clusterIPs := []net.IP{net.ParseIP("192.168.254.1"), net.ParseIP("fd00:254::1")}
+	e := &Environment{
+		secretsABSPath:       dir,
+		hostname:             "p8s",
+		outboundIP:           &outboundIP,
+		kubernetesClusterIPs: clusterIPs,
+		caTTL:                time.Hour,
+		certificateTTL:       time.Minute,
+	}
+	require.NoError(t, e.generateNativeSecrets())
+	require.NoError(t, e.generateComponentKubeconfigs())
+
+	kubelet, err := pki.LoadKeyPair(dir, certificateKubelet)
+	require.NoError(t, err)
+	assert.Equal(t, "system:node:p8s", kubelet.Certificate.Subject.CommonName)
+	assert.Equal(t, []string{"system:nodes"}, kubelet.Certificate.Subject.Organization)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, kubelet.Certificate.ExtKeyUsage)
+	assert.NoError(t, kubelet.Certificate.VerifyHostname("192.168.254.1"))
+
+	apiserver, err := pki.LoadKeyPair(dir, certificateAPIServer)
+	require.NoError(t, err)
+	assert.NoError(t, apiserver.Certificate.VerifyHostname("192.168.254.1"))
+	assert.NoError(t, apiserver.Certificate.VerifyHostname("fd00:254::1"))
+
+	admin, err := pki.LoadKeyPair(dir, certificateAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"system:masters"}, admin.Certificate.Subject.Organization)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, admin.Certificate.ExtKeyUsage)
+
+	// the client keys grant the cluster admin and the destructive API endpoints
+	for _, name := range []string{certificateAdmin, certificatePupernetesAPIClient} {
+		fi, err := os.Stat(pki.FilePath(dir, name, pki.PrivateKeySuffix))
+		require.NoError(t, err)
+		assert.Equal(t, pki.PrivateKeyPerm, fi.Mode().Perm(), name)
+	}
+
+	// valid certificates are kept and their keys restricted
+	require.NoError(t, os.Chmod(pki.FilePath(dir, certificateAdmin, pki.PrivateKeySuffix), 0444))
+	require.NoError(t, e.generateNativeSecrets())
+	fi, err := os.Stat(pki.FilePath(dir, certificateAdmin, pki.PrivateKeySuffix))
+	require.NoError(t, err)
+	assert.Equal(t, pki.PrivateKeyPerm, fi.Mode().Perm())
+	again, err := pki.LoadKeyPair(dir, certificateKubelet)
+	require.NoError(t, err)
+	assert.True(t, kubelet.Certificate.Equal(again.Certificate))
+
+	b, err := ioutil.ReadFile(path.Join(dir, certificateScheduler+kubeconfigSuffix))
+	require.NoError(t, err)
+	kubeconfig := &clientcmdapi.Config{}
+	require.NoError(t, yaml.Unmarshal(b, kubeconfig))
+	require.Len(t, kubeconfig.AuthInfos, 1)
+	assert.Equal(t, "/etc/secrets/kube-scheduler.certificate", kubeconfig.AuthInfos[0].AuthInfo.ClientCertificate)
+	require.Len(t, kubeconfig.Clusters, 1)
+	assert.Equal(t, "/etc/secrets/pupernetes.issuing_ca", kubeconfig.Clusters[0].Cluster.CertificateAuthority)
+}
+
+func TestSuppliedRootCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	supplied, err := pki.NewRootCA("dev", time.Hour)
+	require.NoError(t, err)
+	suppliedDir := path.Join(dir, "dev")
+	require.NoError(t, os.Mkdir(suppliedDir, 0700))
+	require.NoError(t, supplied.WriteFiles(suppliedDir, "ca", 0400))
+
+	secretsDir := path.Join(dir, "secrets")
+	require.NoError(t, os.Mkdir(secretsDir, 0700))
+	outboundIP := net.ParseIP("192.168.1.10")
+	clusterIPs := []net.IP{net.ParseIP("192.168.254.1"), net.ParseIP("fd00:254::1")}
+	e := &Environment{
+		secretsABSPath:       secretsDir,
+		hostname:             "p8s",
+		outboundIP:           &outboundIP,
+		kubernetesClusterIPs: clusterIPs,
+		caTTL:                time.Hour,
+		certificateTTL:       time.Minute,
+	}
+	// a generated root CA is already in the secrets directory
+	require.NoError(t, e.generateNativeSecrets())
+
+	e.caCertificatePath = pki.FilePath(suppliedDir, "ca", pki.CertificateSuffix)
+	e.caPrivateKeyPath = pki.FilePath(suppliedDir, "ca", pki.PrivateKeySuffix)
+	e.extraSANsDNSNames, e.extraSANsIPAddresses = parseSANs([]string{"p8s.example.com", " 10.0.2.15", ""})
+	assert.Equal(t, []string{"p8s.example.com"}, e.extraSANsDNSNames)
+	require.Len(t, e.extraSANsIPAddresses, 1)
+	require.NoError(t, e.generateNativeSecrets())
+
+	ca, err := pki.LoadCA(secretsDir, rootCertificateAuthorityName)
+	require.NoError(t, err)
+	assert.True(t, supplied.Certificate.Equal(ca.Certificate))
+
+	for _, name := range []string{certificateAPIServer, certificateEtcd} {
+		kp, err := pki.LoadKeyPair(secretsDir, name)
+		require.NoError(t, err)
+		assert.Equal(t, supplied.CertificatePEM, kp.IssuingCAPEM)
+		assert.NoError(t, kp.Certificate.VerifyHostname("p8s.example.com"))
+		assert.NoError(t, kp.Certificate.VerifyHostname("10.0.2.15"))
+	}
+	kubelet, err := pki.LoadKeyPair(secretsDir, certificateKubelet)
+	require.NoError(t, err)
+	assert.Error(t, kubelet.Certificate.VerifyHostname("p8s.example.com"))
+}
+
+func TestRotateCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	outboundIP := net.ParseIP("192.168.1.10")
+	clusterIPs := []net.IP{net.ParseIP("192.168.254.1"), net.ParseIP("fd00:254::1")}
+	e := &Environment{
+		secretsABSPath:       dir,
+		hostname:             "p8s",
+		outboundIP:           &outboundIP,
+		kubernetesClusterIPs: clusterIPs,
+		caTTL:                time.Hour,
+		certificateTTL:       time.Minute,
+	}
+	infos, err := e.ListCertificates()
+	require.NoError(t, err)
+	assert.Len(t, infos, 0)
+
+	_, err = e.RotateCertificates()
+	assert.Error(t, err)
+
+	require.NoError(t, e.generateNativeSecrets())
+	before, err := e.ListCertificates()
+	require.NoError(t, err)
+	require.Len(t, before, len(certificateNames)+1)
+	assert.Equal(t, rootCertificateAuthorityName, before[0].Name)
+
+	e.certificateTTL = time.Minute * 30
+	after, err := e.RotateCertificates()
+	require.NoError(t, err)
+	require.Len(t, after, len(before))
+	// the root CA is kept
+	assert.Equal(t, before[0], after[0])
+	for i := 1; i < len(after); i++ {
+		assert.Equal(t, before[i].Subject, after[i].Subject)
+		assert.True(t, after[i].NotAfter.After(before[i].NotAfter), after[i].Name)
+	}
+}
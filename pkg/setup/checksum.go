@@ -0,0 +1,225 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/DataDog/pupernetes/pkg/config"
+)
+
+const (
+	checksumSHA256 = "sha256"
+	checksumSHA512 = "sha512"
+)
+
+// pinnedChecksumKey is the key of the pinnedChecksums generated in checksum_pinned.go,
+// they are verified before the sidecar checksum files, and are the only way to verify
+// the archives published without any, like runc
+func pinnedChecksumKey(component, version, arch string) string {
+	return component + "/" + version + "/" + arch
+}
+
+// DefaultArchiveURLs returns the upstream archives of the default versions for every arch, keyed by
+// their pinned checksum key. Run make checksums to pin their digests after bumping a default version
+func DefaultArchiveURLs() (map[string]string, error) {
+	urls := make(map[string]string)
+	for component := range upstreamSources {
+		for _, arch := range supportedArchs {
+			version := config.DefaultVersions[component]
+			archVersion, ok := config.ArchDefaultVersions[arch][component]
+			if ok {
+				version = archVersion
+			}
+			archiveURL, _, err := upstreamURLs(component, version, arch)
+			if err != nil {
+				return nil, err
+			}
+			urls[pinnedChecksumKey(component, version, arch)] = archiveURL
+		}
+	}
+	return urls, nil
+}
+
+type checksum struct {
+	algorithm string
+	sum       string
+}
+
+func (c *checksum) String() string {
+	return c.algorithm + ":" + c.sum
+}
+
+func (c *checksum) newHash() hash.Hash {
+	if c.algorithm == checksumSHA512 {
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+// guessChecksumAlgorithm returns the algorithm matching the length of the given hex digest
+func guessChecksumAlgorithm(sum string) (string, error) {
+	switch len(sum) {
+	case sha256.Size * 2:
+		return checksumSHA256, nil
+	case sha512.Size * 2:
+		return checksumSHA512, nil
+	}
+	return "", fmt.Errorf("invalid digest length %d: %q", len(sum), sum)
+}
+
+// parseChecksum parses a digest like sha256:<hex>, sha512:<hex> or a bare <hex>
+func parseChecksum(s string) (*checksum, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	algorithm := ""
+	sum := s
+	i := strings.Index(s, ":")
+	if i != -1 {
+		algorithm, sum = s[:i], s[i+1:]
+	}
+	_, err := hex.DecodeString(sum)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex digest %q: %v", sum, err)
+	}
+	guessed, err := guessChecksumAlgorithm(sum)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm != "" && algorithm != guessed {
+		return nil, fmt.Errorf("invalid %s digest: %q", algorithm, sum)
+	}
+	return &checksum{algorithm: guessed, sum: sum}, nil
+}
+
+// parseChecksumFile parses the content of a sidecar like .sha256/.sha512 or
+// of a SHA256SUMS file and returns the digest of the given file name
+func parseChecksumFile(b []byte, fileName string) (*checksum, error) {
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for scan.Scan() {
+		fields := strings.Fields(scan.Text())
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			// the sidecar only contains the digest
+			return parseChecksum(fields[0])
+		}
+		// <digest> <file name> or <digest> *<file name>
+		if path.Base(strings.TrimPrefix(fields[1], "*")) != fileName {
+			continue
+		}
+		return parseChecksum(fields[0])
+	}
+	return nil, fmt.Errorf("cannot find a digest for %s", fileName)
+}
+
+func fileChecksum(filePath string, c *checksum) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := c.newHash()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (d *depBinary) downloadChecksum() (*checksum, error) {
+	glog.V(2).Infof("Downloading the checksum of %s from %s", d.archiveURL, d.checksumURL)
+	resp, err := newDownloadClient(d.downloadTimeout, d.proxy).Get(d.checksumURL)
+	if err != nil {
+		glog.Errorf("Cannot download %s: %v", d.checksumURL, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("status code: %d", resp.StatusCode)
+		glog.Errorf("Cannot download %s, status code != 200, %s", d.checksumURL, err)
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		glog.Errorf("Cannot read %s: %v", d.checksumURL, err)
+		return nil, err
+	}
+	return parseChecksumFile(b, path.Base(d.archiveURL))
+}
+
+// getExpectedChecksum returns the wanted digest of the archive from, in order:
+// the user digest, the user checksum file, the pinned digest and the upstream checksum file.
+// nil is returned if there isn't any way to know it, unless the checksums are required
+func (d *depBinary) getExpectedChecksum() (*checksum, error) {
+	if d.checksum != "" && !strings.Contains(d.checksum, "://") {
+		c, err := parseChecksum(d.checksum)
+		if err != nil {
+			glog.Errorf("Invalid checksum for %s: %v", d.archiveURL, err)
+			return nil, err
+		}
+		return c, nil
+	}
+	if d.checksum != "" {
+		// the user overrides the checksum file URL
+		d.checksumURL = d.checksum
+	} else if d.pinnedChecksum != "" {
+		c, err := parseChecksum(d.pinnedChecksum)
+		if err != nil {
+			glog.Errorf("Invalid pinned checksum for %s: %v", d.archiveURL, err)
+			return nil, err
+		}
+		return c, nil
+	}
+	if d.checksumURL == "" {
+		if d.requireChecksum {
+			err := fmt.Errorf("no checksum available for %s, set --%s-checksum", d.archiveURL, d.name)
+			glog.Errorf("Cannot verify the archive: %v", err)
+			return nil, err
+		}
+		glog.Warningf("No checksum available for %s, the archive is NOT verified: set --%s-checksum or --require-checksums", d.archiveURL, d.name)
+		return nil, nil
+	}
+	c, err := d.downloadChecksum()
+	if err != nil {
+		glog.Errorf("Cannot get the checksum of %s: %v", d.archiveURL, err)
+		return nil, err
+	}
+	glog.V(3).Infof("Expected checksum of %s is %s", d.archiveURL, c.String())
+	return c, nil
+}
+
+func (d *depBinary) verifyArchive(expected *checksum) error {
+	if expected == nil {
+		return nil
+	}
+	sum, err := fileChecksum(d.archivePath, expected)
+	if err != nil {
+		glog.Errorf("Cannot compute the %s of %s: %v", expected.algorithm, d.archivePath, err)
+		return err
+	}
+	if sum != expected.sum {
+		err = fmt.Errorf("checksum mismatch for %s: expected %s, got %s:%s", d.archivePath, expected.String(), expected.algorithm, sum)
+		glog.Errorf("Invalid archive: %v", err)
+		return err
+	}
+	glog.V(3).Infof("Archive %s matches %s", d.archivePath, expected.String())
+	return nil
+}
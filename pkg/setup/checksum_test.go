@@ -0,0 +1,127 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	helloSHA512 = "9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043"
+)
+
+func TestParseChecksum(t *testing.T) {
+	testCases := []struct {
+		input     string
+		algorithm string
+		valid     bool
+	}{
+		{"sha256:" + helloSHA256, checksumSHA256, true},
+		{"SHA512:" + helloSHA512, checksumSHA512, true},
+		{helloSHA256, checksumSHA256, true},
+		{helloSHA512, checksumSHA512, true},
+		{"sha512:" + helloSHA256, "", false},
+		{"sha256:" + helloSHA256[1:], "", false},
+		{"sha256:" + helloSHA256[2:] + "zz", "", false},
+		{"", "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			c, err := parseChecksum(tc.input)
+			if !tc.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.algorithm, c.algorithm)
+		})
+	}
+}
+
+func TestParseChecksumFile(t *testing.T) {
+	c, err := parseChecksumFile([]byte(helloSHA512+"\n"), "kubernetes-server-linux-amd64.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "sha512:"+helloSHA512, c.String())
+
+	sums := []byte(helloSHA512 + "  etcd-v3.4.7-darwin-amd64.zip\n" +
+		helloSHA256 + "  etcd-v3.4.7-linux-amd64.tar.gz\n")
+	c, err = parseChecksumFile(sums, "etcd-v3.4.7-linux-amd64.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:"+helloSHA256, c.String())
+
+	c, err = parseChecksumFile([]byte(helloSHA256+" *bin/containerd-1.1.3.linux-amd64.tar.gz\n"), "containerd-1.1.3.linux-amd64.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:"+helloSHA256, c.String())
+
+	_, err = parseChecksumFile(sums, "vault_0.9.5_linux_amd64.zip")
+	assert.Error(t, err)
+}
+
+func TestVerifyArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-checksum")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := &depBinary{archivePath: path.Join(dir, "archive.tar.gz")}
+	require.NoError(t, ioutil.WriteFile(d.archivePath, []byte("hello"), 0400))
+
+	assert.NoError(t, d.verifyArchive(nil))
+	assert.NoError(t, d.verifyArchive(&checksum{algorithm: checksumSHA256, sum: helloSHA256}))
+	assert.NoError(t, d.verifyArchive(&checksum{algorithm: checksumSHA512, sum: helloSHA512}))
+
+	require.NoError(t, ioutil.WriteFile(d.archivePath, []byte("hell"), 0400))
+	assert.Error(t, d.verifyArchive(&checksum{algorithm: checksumSHA256, sum: helloSHA256}))
+}
+
+func TestGetExpectedChecksum(t *testing.T) {
+	d := &depBinary{name: "runc", archiveURL: "https://example.com/runc.amd64"}
+	c, err := d.getExpectedChecksum()
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	d.requireChecksum = true
+	_, err = d.getExpectedChecksum()
+	assert.Error(t, err)
+
+	d.pinnedChecksum = "sha256:" + helloSHA256
+	c, err = d.getExpectedChecksum()
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:"+helloSHA256, c.String())
+
+	// the user digest wins over the pinned one
+	d.checksum = "sha512:" + helloSHA512
+	c, err = d.getExpectedChecksum()
+	require.NoError(t, err)
+	assert.Equal(t, "sha512:"+helloSHA512, c.String())
+}
+
+func TestPinnedChecksums(t *testing.T) {
+	urls, err := DefaultArchiveURLs()
+	require.NoError(t, err)
+	require.NotEmpty(t, urls)
+	// run make checksums when bumping a default version
+	for key, archiveURL := range urls {
+		digest, ok := pinnedChecksums[key]
+		if !assert.True(t, ok, "no pinned digest for %s: %s", key, archiveURL) {
+			continue
+		}
+		_, err = parseChecksum(digest)
+		assert.NoError(t, err, key)
+	}
+	for key := range pinnedChecksums {
+		assert.Len(t, strings.Split(key, "/"), 3, key)
+		_, ok := urls[key]
+		assert.True(t, ok, "%s isn't a default version anymore", key)
+	}
+}
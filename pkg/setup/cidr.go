@@ -0,0 +1,405 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	procNetIPv6Route = "/proc/net/ipv6_route"
+
+	// autoIPRange selects a range free on the host
+	autoIPRange = "auto"
+
+	ipRangesFileName = "ip-ranges.json"
+
+	// kubeIPVSInterface holds the service IP addresses when kube-proxy runs in ipvs mode
+	kubeIPVSInterface = "kube-ipvs0"
+)
+
+// hostNetwork is a network already used on the host by an interface or a route
+type hostNetwork struct {
+	cidr   *net.IPNet
+	source string
+}
+
+// ipRanges are the ranges selected with auto, persisted in the state directory
+type ipRanges struct {
+	KubernetesClusterIPRange string `json:"kubernetesClusterIPRange"`
+	PodIPRange               string `json:"podIPRange"`
+}
+
+// isOwnInterface returns true if the interface is managed by pupernetes
+func isOwnInterface(name string) bool {
+	return name == defaultBridgeName || name == kubeIPVSInterface
+}
+
+func isLinkLocalOrMulticast(ip net.IP) bool {
+	return ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast()
+}
+
+// parseHexIPv4 decodes the little endian hexadecimal addresses of /proc/net/route
+func parseHexIPv4(s string) (net.IP, error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(byte(v), byte(v>>8), byte(v>>16), byte(v>>24)).To4(), nil
+}
+
+// parseRoutes returns the networks routed in the /proc/net/route content, except the default routes
+func parseRoutes(b []byte) []hostNetwork {
+	var networks []hostNetwork
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for scan.Scan() {
+		fields := strings.Fields(scan.Text())
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		if len(fields) < 8 || fields[0] == "Iface" || isOwnInterface(fields[0]) {
+			continue
+		}
+		destination, err := parseHexIPv4(fields[1])
+		if err != nil {
+			continue
+		}
+		mask, err := parseHexIPv4(fields[7])
+		if err != nil {
+			continue
+		}
+		cidr := &net.IPNet{IP: destination, Mask: net.IPMask(mask)}
+		ones, _ := cidr.Mask.Size()
+		if ones == 0 {
+			continue
+		}
+		networks = append(networks, hostNetwork{cidr: cidr, source: "route via " + fields[0]})
+	}
+	return networks
+}
+
+// parseIPv6Routes returns the networks routed in the /proc/net/ipv6_route content,
+// except the default, the link local and the multicast routes
+func parseIPv6Routes(b []byte) []hostNetwork {
+	var networks []hostNetwork
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for scan.Scan() {
+		fields := strings.Fields(scan.Text())
+		// destination prefix_length source source_prefix_length next_hop metric refcnt use flags iface
+		if len(fields) < 10 || isOwnInterface(fields[9]) || fields[9] == "lo" {
+			continue
+		}
+		destination, err := hex.DecodeString(fields[0])
+		if err != nil || len(destination) != net.IPv6len {
+			continue
+		}
+		ones, err := strconv.ParseUint(fields[1], 16, 8)
+		if err != nil || ones == 0 || ones > 128 {
+			continue
+		}
+		ip := net.IP(destination)
+		if isLinkLocalOrMulticast(ip) {
+			continue
+		}
+		cidr := &net.IPNet{IP: ip, Mask: net.CIDRMask(int(ones), 128)}
+		networks = append(networks, hostNetwork{cidr: cidr, source: "route via " + fields[9]})
+	}
+	return networks
+}
+
+// getInterfaceNetworks returns the networks of the addresses of the interfaces, docker0 and the other bridges included
+func getInterfaceNetworks() ([]hostNetwork, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		glog.Errorf("Cannot list the interfaces: %v", err)
+		return nil, err
+	}
+	var networks []hostNetwork
+	for _, iface := range ifaces {
+		if isOwnInterface(iface.Name) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			glog.Warningf("Cannot get the addresses of %s: %v", iface.Name, err)
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || isLinkLocalOrMulticast(ipNet.IP) {
+				continue
+			}
+			cidr := &net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}
+			networks = append(networks, hostNetwork{cidr: cidr, source: "interface " + iface.Name})
+		}
+	}
+	return networks, nil
+}
+
+// getHostNetworks returns the networks of the interfaces and the routes of the host
+func getHostNetworks() ([]hostNetwork, error) {
+	networks, err := getInterfaceNetworks()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(procNetRoute)
+	if err != nil {
+		glog.Errorf("Cannot read %s: %v", procNetRoute, err)
+		return nil, err
+	}
+	networks = append(networks, parseRoutes(b)...)
+	b, err = ioutil.ReadFile(procNetIPv6Route)
+	if err != nil {
+		// IPv6 can be disabled
+		glog.V(4).Infof("Cannot read %s: %v", procNetIPv6Route, err)
+		return networks, nil
+	}
+	return append(networks, parseIPv6Routes(b)...), nil
+}
+
+// parseOwnCIDRs returns the cidrs of the given ranges, ignoring the empty, auto and invalid ones
+func parseOwnCIDRs(ranges ...string) []*net.IPNet {
+	var cidrs []*net.IPNet
+	for _, elt := range ranges {
+		if elt == "" || elt == autoIPRange {
+			continue
+		}
+		parsed, err := parseCIDRs(elt)
+		if err != nil {
+			continue
+		}
+		cidrs = append(cidrs, parsed...)
+	}
+	return cidrs
+}
+
+// excludeOwnNetworks removes the networks strictly inside the pupernetes cidrs,
+// like the addresses and the routes of the ptp veths of the pods
+func excludeOwnNetworks(networks []hostNetwork, cidrs []*net.IPNet) []hostNetwork {
+	var others []hostNetwork
+	for _, n := range networks {
+		own := false
+		nOnes, _ := n.cidr.Mask.Size()
+		for _, cidr := range cidrs {
+			ones, _ := cidr.Mask.Size()
+			if cidr.Contains(n.cidr.IP) && nOnes > ones {
+				own = true
+				break
+			}
+		}
+		if own {
+			glog.V(5).Infof("Ignoring %s of the %s, inside the pupernetes ranges", n.cidr.String(), n.source)
+			continue
+		}
+		others = append(others, n)
+	}
+	return others
+}
+
+func isOverlapping(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// findOverlap returns the first network overlapping the cidr
+func findOverlap(cidr *net.IPNet, networks []hostNetwork) *hostNetwork {
+	for i := range networks {
+		if isOverlapping(cidr, networks[i].cidr) {
+			return &networks[i]
+		}
+	}
+	return nil
+}
+
+// freeCIDRCandidates are the IPv4 private networks /24 tried with auto, starting with the defaults
+func freeCIDRCandidates() []*net.IPNet {
+	var candidates []*net.IPNet
+	for i := 254; i >= 0; i-- {
+		candidates = append(candidates, &net.IPNet{IP: net.IPv4(192, 168, byte(i), 0).To4(), Mask: net.CIDRMask(24, 32)})
+	}
+	for i := 31; i >= 16; i-- {
+		for j := 255; j >= 0; j-- {
+			candidates = append(candidates, &net.IPNet{IP: net.IPv4(172, byte(i), byte(j), 0).To4(), Mask: net.CIDRMask(24, 32)})
+		}
+	}
+	return candidates
+}
+
+// pickFreeCIDR returns the first candidate overlapping neither the networks nor the taken ones
+func pickFreeCIDR(networks []hostNetwork, taken ...string) (*net.IPNet, error) {
+	for _, elt := range taken {
+		cidrs, err := parseCIDRs(elt)
+		if err != nil {
+			return nil, err
+		}
+		for _, cidr := range cidrs {
+			networks = append(networks, hostNetwork{cidr: cidr, source: "pupernetes"})
+		}
+	}
+	for _, candidate := range freeCIDRCandidates() {
+		if findOverlap(candidate, networks) == nil {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("no free IPv4 private range")
+}
+
+// isFreeIPRange returns true if the persisted range is still usable
+func isFreeIPRange(s string, networks []hostNetwork) bool {
+	cidrs, err := parseCIDRs(s)
+	if err != nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		if findOverlap(cidr, networks) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Environment) getIPRangesPath() string {
+	return path.Join(e.rootABSPath, ipRangesFileName)
+}
+
+func (e *Environment) readIPRanges() *ipRanges {
+	persisted := &ipRanges{}
+	b, err := ioutil.ReadFile(e.getIPRangesPath())
+	if err != nil {
+		glog.V(4).Infof("No IP ranges persisted: %v", err)
+		return persisted
+	}
+	err = json.Unmarshal(b, persisted)
+	if err != nil {
+		glog.Warningf("Ignoring the IP ranges persisted in %s: %v", e.getIPRangesPath(), err)
+		return &ipRanges{}
+	}
+	return persisted
+}
+
+func (e *Environment) writeIPRanges(selected *ipRanges) error {
+	b, err := json.Marshal(selected)
+	if err != nil {
+		glog.Errorf("Cannot marshal the IP ranges: %v", err)
+		return err
+	}
+	err = os.MkdirAll(e.rootABSPath, os.ModePerm)
+	if err != nil {
+		glog.Errorf("Cannot create %s: %v", e.rootABSPath, err)
+		return err
+	}
+	err = ioutil.WriteFile(e.getIPRangesPath(), b, 0644)
+	if err != nil {
+		glog.Errorf("Cannot write the IP ranges in %s: %v", e.getIPRangesPath(), err)
+		return err
+	}
+	glog.V(4).Infof("Persisted the IP ranges in %s", e.getIPRangesPath())
+	return nil
+}
+
+// selectIPRanges replaces the auto ranges by the ones persisted in the state directory,
+// or by free ranges of the host persisted for the next runs
+func (e *Environment) selectIPRanges(kubernetesClusterIPRange, podIPRange string, networks []hostNetwork) (string, string, error) {
+	if kubernetesClusterIPRange != autoIPRange && podIPRange != autoIPRange {
+		return kubernetesClusterIPRange, podIPRange, nil
+	}
+	persisted := e.readIPRanges()
+	selected := &ipRanges{}
+	for _, elt := range []struct {
+		flag      string
+		given     string
+		persisted string
+		selected  *string
+	}{
+		{"kubernetes-cluster-ip-range", kubernetesClusterIPRange, persisted.KubernetesClusterIPRange, &selected.KubernetesClusterIPRange},
+		{"pod-ip-range", podIPRange, persisted.PodIPRange, &selected.PodIPRange},
+	} {
+		if elt.given != autoIPRange {
+			*elt.selected = elt.given
+			continue
+		}
+		if elt.persisted != "" && isFreeIPRange(elt.persisted, networks) {
+			glog.V(3).Infof("Using the %s %s persisted in %s", elt.flag, elt.persisted, e.getIPRangesPath())
+			*elt.selected = elt.persisted
+			continue
+		}
+		if elt.persisted != "" {
+			glog.Warningf("The %s %s persisted in %s is now used on the host, selecting another one", elt.flag, elt.persisted, e.getIPRangesPath())
+		}
+		var taken []string
+		for _, other := range []string{selected.KubernetesClusterIPRange, selected.PodIPRange, kubernetesClusterIPRange, podIPRange} {
+			if other != "" && other != autoIPRange {
+				taken = append(taken, other)
+			}
+		}
+		cidr, err := pickFreeCIDR(networks, taken...)
+		if err != nil {
+			glog.Errorf("Cannot select the %s: %v", elt.flag, err)
+			return "", "", err
+		}
+		glog.V(2).Infof("Selected the %s %s", elt.flag, cidr.String())
+		*elt.selected = cidr.String()
+	}
+	if *persisted != *selected {
+		err := e.writeIPRanges(selected)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return selected.KubernetesClusterIPRange, selected.PodIPRange, nil
+}
+
+// checkIPRanges returns an error if the selected ranges conflict with the networks of the host,
+// it runs during the setup, after the clean removed the links of the previous pods
+func (e *Environment) checkIPRanges() error {
+	networks, err := getHostNetworks()
+	if err != nil {
+		glog.Errorf("Cannot inspect the networks of the host: %v", err)
+		return err
+	}
+	networks = excludeOwnNetworks(networks, append(append([]*net.IPNet{}, e.kubernetesClusterCIDRs...), e.podCIDRs...))
+	err = e.checkIPRangeConflicts(networks)
+	if err != nil {
+		glog.Errorf("Cannot use the IP ranges: %v", err)
+		return err
+	}
+	return nil
+}
+
+// checkIPRangeConflicts returns an error if the pod or the kubernetes cluster ranges overlap each other or a network of the host
+func (e *Environment) checkIPRangeConflicts(networks []hostNetwork) error {
+	for _, elt := range []struct {
+		flag  string
+		cidrs []*net.IPNet
+	}{
+		{"kubernetes-cluster-ip-range", e.kubernetesClusterCIDRs},
+		{"pod-ip-range", e.podCIDRs},
+	} {
+		for _, cidr := range elt.cidrs {
+			n := findOverlap(cidr, networks)
+			if n != nil {
+				return fmt.Errorf("the --%s %s overlaps %s of the %s, use another range or %s", elt.flag, cidr.String(), n.cidr.String(), n.source, autoIPRange)
+			}
+		}
+	}
+	for _, podCIDR := range e.podCIDRs {
+		for _, kubernetesClusterCIDR := range e.kubernetesClusterCIDRs {
+			if isOverlapping(podCIDR, kubernetesClusterCIDR) {
+				return fmt.Errorf("the --pod-ip-range %s overlaps the --kubernetes-cluster-ip-range %s", podCIDR.String(), kubernetesClusterCIDR.String())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hostNetworksOf(t *testing.T, cidrs ...string) []hostNetwork {
+	var networks []hostNetwork
+	for _, elt := range cidrs {
+		_, cidr, err := net.ParseCIDR(elt)
+		require.NoError(t, err)
+		networks = append(networks, hostNetwork{cidr: cidr, source: "interface eth0"})
+	}
+	return networks
+}
+
+func TestParseRoutes(t *testing.T) {
+	networks := parseRoutes([]byte(`Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+ens33	00000000	0202A8C0	0003	0	0	100	00000000	0	0	0
+docker0	000011AC	00000000	0001	0	0	0	0000FFFF	0	0	0
+ens33	00FEA8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+cni-p8s	00FDA8C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+`))
+	require.Len(t, networks, 2)
+	assert.Equal(t, "172.17.0.0/16", networks[0].cidr.String())
+	assert.Equal(t, "route via docker0", networks[0].source)
+	assert.Equal(t, "192.168.254.0/24", networks[1].cidr.String())
+}
+
+func TestParseIPv6Routes(t *testing.T) {
+	networks := parseIPv6Routes([]byte(`fd000000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+fe800000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000002 00000000 00000001     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fd000000000000000000000000000001 00000400 00000001 00000000 00000003     eth0
+00000000000000000000000000000001 80 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000002 00000000 80200001       lo
+`))
+	require.Len(t, networks, 1)
+	assert.Equal(t, "fd00::/64", networks[0].cidr.String())
+}
+
+func TestPickFreeCIDR(t *testing.T) {
+	cidr, err := pickFreeCIDR(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.254.0/24", cidr.String())
+
+	cidr, err = pickFreeCIDR(hostNetworksOf(t, "192.168.254.0/24", "192.168.252.0/23"), "192.168.251.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.250.0/24", cidr.String())
+
+	cidr, err = pickFreeCIDR(hostNetworksOf(t, "192.168.0.0/16"))
+	require.NoError(t, err)
+	assert.Equal(t, "172.31.255.0/24", cidr.String())
+}
+
+func TestCheckIPRangeConflicts(t *testing.T) {
+	services, err := parseCIDRs("192.168.254.0/24")
+	require.NoError(t, err)
+	pods, err := parseCIDRs("192.168.253.0/24")
+	require.NoError(t, err)
+	e := &Environment{kubernetesClusterCIDRs: services, podCIDRs: pods}
+
+	assert.NoError(t, e.checkIPRangeConflicts(hostNetworksOf(t, "172.17.0.0/16", "10.0.0.0/8")))
+	err = e.checkIPRangeConflicts(hostNetworksOf(t, "192.168.0.0/16"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "192.168.0.0/16 of the interface eth0")
+
+	e.podCIDRs = services
+	assert.Error(t, e.checkIPRangeConflicts(nil))
+}
+
+func TestExcludeOwnNetworks(t *testing.T) {
+	own := parseOwnCIDRs("192.168.254.0/24", autoIPRange, "", "invalid", "192.168.253.0/24")
+	require.Len(t, own, 2)
+
+	// the ptp veths of the pods hold /32 addresses and routes inside the pod range
+	networks := excludeOwnNetworks(hostNetworksOf(t, "192.168.253.1/32", "192.168.253.5/32", "172.17.0.0/16", "192.168.253.0/24", "192.168.0.0/16"), own)
+	require.Len(t, networks, 3)
+	assert.Equal(t, "172.17.0.0/16", networks[0].cidr.String())
+	assert.Equal(t, "192.168.253.0/24", networks[1].cidr.String())
+	assert.Equal(t, "192.168.0.0/16", networks[2].cidr.String())
+
+	assert.Len(t, excludeOwnNetworks(hostNetworksOf(t, "192.168.253.1/32"), nil), 1)
+}
+
+func TestSelectIPRanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-ip-ranges")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	e := &Environment{rootABSPath: dir}
+
+	services, pods, err := e.selectIPRanges("192.168.254.0/24", "192.168.253.0/24", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.254.0/24", services)
+	assert.Equal(t, "192.168.253.0/24", pods)
+	_, err = os.Stat(e.getIPRangesPath())
+	assert.True(t, os.IsNotExist(err))
+
+	services, pods, err = e.selectIPRanges(autoIPRange, autoIPRange, hostNetworksOf(t, "192.168.254.0/24"))
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.253.0/24", services)
+	assert.Equal(t, "192.168.252.0/24", pods)
+
+	// the persisted ranges are reused while they are free
+	services, pods, err = e.selectIPRanges(autoIPRange, autoIPRange, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.253.0/24", services)
+	assert.Equal(t, "192.168.252.0/24", pods)
+
+	services, pods, err = e.selectIPRanges(autoIPRange, "192.168.254.0/24", hostNetworksOf(t, "192.168.253.0/24"))
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.252.0/24", services)
+	assert.Equal(t, "192.168.254.0/24", pods)
+	assert.Equal(t, &ipRanges{KubernetesClusterIPRange: "192.168.252.0/24", PodIPRange: "192.168.254.0/24"}, e.readIPRanges())
+}
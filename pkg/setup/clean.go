@@ -96,6 +96,12 @@ func (e *Environment) Clean() error {
 		b, err := exec.Command(e.GetHyperkubePath(), "kube-proxy", "--cleanup").CombinedOutput()
 		glog.V(5).Infof("Iptables clean: %s, %v", string(b), err)
 	}
+	if e.cleanOptions.Iptables {
+		err = e.cleanIptables()
+		if err != nil {
+			return err
+		}
+	}
 
 	if e.cleanOptions.Etcd {
 		toRemove = append(toRemove, e.etcdDataABSPath)
@@ -146,6 +152,27 @@ func (e *Environment) Clean() error {
 	if e.cleanOptions.Mounts {
 		e.cleanMounts()
 	}
+	if e.cleanOptions.DNS {
+		// before the bridge removal
+		err = e.cleanHostDNS()
+		if err != nil {
+			return err
+		}
+	}
+	if e.cleanOptions.Network {
+		// the pods are stopped with the systemd units
+		err = e.cleanNetworkLinks()
+		if err != nil {
+			return err
+		}
+	}
+	if e.cleanOptions.Kernel {
+		// after the bridge removal
+		err = e.cleanKernel()
+		if err != nil {
+			return err
+		}
+	}
 	if e.cleanOptions.Kubelet {
 		// don't do it twice
 		if !e.cleanOptions.Mounts {
@@ -0,0 +1,159 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/golang/glog"
+)
+
+const (
+	cniConfListFileName = "cni.conflist"
+	cniConfigVersion    = "0.3.1"
+)
+
+type cniPtpConfig struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	CniVersion string `json:"cniVersion"`
+	IPMasq     bool   `json:"ipMasq"`
+	Ipam       *ipam  `json:"ipam"`
+}
+
+// cniChainedPlugin is a plugin of a conflist called after the one creating the interface
+type cniChainedPlugin struct {
+	Type         string          `json:"type"`
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+	SNAT         bool            `json:"snat,omitempty"`
+}
+
+type cniConfigList struct {
+	Name       string        `json:"name"`
+	CniVersion string        `json:"cniVersion"`
+	Plugins    []interface{} `json:"plugins"`
+}
+
+// rawCNIConfigList is the part of a given conflist needed to check it
+type rawCNIConfigList struct {
+	Name    string `json:"name"`
+	Plugins []struct {
+		Type string `json:"type"`
+		Ipam *struct {
+			Type string `json:"type"`
+		} `json:"ipam"`
+	} `json:"plugins"`
+}
+
+func (e *Environment) newCNIPtpConfig() *cniPtpConfig {
+	return &cniPtpConfig{
+		Name:       "p8s",
+		Type:       "ptp",
+		CniVersion: cniConfigVersion,
+		IPMasq:     true,
+		Ipam:       e.newCNIIPAM(),
+	}
+}
+
+// newCNIChainedConfig returns the bridge config chained with the portmap plugin for the hostPorts,
+// the bandwidth plugin for the kubernetes.io/ingress-bandwidth and egress-bandwidth annotations and the firewall plugin
+func (e *Environment) newCNIChainedConfig(bridgeName string) *cniConfigList {
+	return &cniConfigList{
+		Name:       "p8s",
+		CniVersion: cniConfigVersion,
+		Plugins: []interface{}{
+			e.newCNIBridgeConfig(bridgeName),
+			&cniChainedPlugin{
+				Type:         "portmap",
+				Capabilities: map[string]bool{"portMappings": true},
+				SNAT:         true,
+			},
+			&cniChainedPlugin{
+				Type:         "bandwidth",
+				Capabilities: map[string]bool{"bandwidth": true},
+			},
+			&cniChainedPlugin{
+				Type: "firewall",
+			},
+		},
+	}
+}
+
+// checkCNIPlugins returns an error if a plugin isn't in the binaries of the CNI archive
+func (e *Environment) checkCNIPlugins(pluginTypes ...string) error {
+	for _, pluginType := range pluginTypes {
+		_, err := os.Stat(path.Join(e.binABSPath, pluginType))
+		if err != nil {
+			err = fmt.Errorf("the cni plugin %s isn't in %s with the cni version %s: %v", pluginType, e.binABSPath, e.binaryCNI.version, err)
+			glog.Errorf("Cannot use the CNI config: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCNIConfList returns the plugin types of the conflist content
+func parseCNIConfList(b []byte) ([]string, error) {
+	raw := &rawCNIConfigList{}
+	err := json.Unmarshal(b, raw)
+	if err != nil {
+		return nil, err
+	}
+	if raw.Name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	if len(raw.Plugins) == 0 {
+		return nil, fmt.Errorf("missing plugins")
+	}
+	var pluginTypes []string
+	for _, plugin := range raw.Plugins {
+		if plugin.Type == "" {
+			return nil, fmt.Errorf("missing type of a plugin")
+		}
+		pluginTypes = append(pluginTypes, plugin.Type)
+		if plugin.Ipam != nil && plugin.Ipam.Type != "" {
+			pluginTypes = append(pluginTypes, plugin.Ipam.Type)
+		}
+	}
+	return pluginTypes, nil
+}
+
+// copyCNIConfList writes the given conflist as is
+func (e *Environment) copyCNIConfList() error {
+	b, err := ioutil.ReadFile(e.cniConfListPath)
+	if err != nil {
+		glog.Errorf("Cannot read the CNI conflist %s: %v", e.cniConfListPath, err)
+		return err
+	}
+	pluginTypes, err := parseCNIConfList(b)
+	if err != nil {
+		glog.Errorf("Invalid CNI conflist %s: %v", e.cniConfListPath, err)
+		return err
+	}
+	err = e.checkCNIPlugins(pluginTypes...)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path.Join(e.networkConfigABSPath, cniFileName))
+	if err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Cannot remove the CNI config %s: %v", cniFileName, err)
+		return err
+	}
+	cniConfPath := path.Join(e.networkConfigABSPath, cniConfListFileName)
+	// the file is read only
+	_ = os.Remove(cniConfPath)
+	err = ioutil.WriteFile(cniConfPath, b, 0444)
+	if err != nil {
+		glog.Errorf("Cannot write CNI conf to %s: %v", cniConfPath, err)
+		return err
+	}
+	glog.V(4).Infof("Using the CNI conflist %s", e.cniConfListPath)
+	return nil
+}
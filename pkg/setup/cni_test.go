@@ -0,0 +1,95 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/pupernetes/pkg/config"
+)
+
+func TestParseCNIConfList(t *testing.T) {
+	pluginTypes, err := parseCNIConfList([]byte(`{
+  "cniVersion": "0.3.1",
+  "name": "dev",
+  "plugins": [
+    {"type": "macvlan", "master": "eth0", "ipam": {"type": "dhcp"}},
+    {"type": "tuning", "sysctl": {"net.core.somaxconn": "500"}}
+  ]
+}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"macvlan", "dhcp", "tuning"}, pluginTypes)
+
+	for _, conflist := range []string{
+		`{"name": "dev", "plugins": []}`,
+		`{"plugins": [{"type": "bridge"}]}`,
+		`{"name": "dev", "plugins": [{"bridge": "br0"}]}`,
+		`{"name": "dev", "type": "bridge"}`,
+		`name: dev`,
+	} {
+		_, err = parseCNIConfList([]byte(conflist))
+		assert.Error(t, err, conflist)
+	}
+}
+
+func TestGenerateCNIConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-cni")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	podCIDRs, err := parseCIDRs("192.168.253.0/24")
+	require.NoError(t, err)
+	e := &Environment{
+		binABSPath:           path.Join(dir, "bin"),
+		networkConfigABSPath: path.Join(dir, "net.d"),
+		networkStateABSPath:  path.Join(dir, "networks"),
+		binaryCNI:            &depBinary{version: "0.7.5"},
+		podCIDRs:             podCIDRs,
+		podBridgeGatewayIPs:  []net.IP{net.ParseIP("192.168.253.1")},
+		cniPlugin:            config.CNIChained,
+	}
+	require.NoError(t, os.Mkdir(e.binABSPath, 0755))
+	require.NoError(t, os.Mkdir(e.networkConfigABSPath, 0755))
+	for _, plugin := range []string{"bridge", "host-local", "ptp", "portmap", "bandwidth"} {
+		require.NoError(t, ioutil.WriteFile(path.Join(e.binABSPath, plugin), nil, 0755))
+	}
+
+	// the firewall plugin is missing in this version
+	assert.Error(t, e.generateCNIConf(defaultBridgeName))
+	require.NoError(t, ioutil.WriteFile(path.Join(e.binABSPath, "firewall"), nil, 0755))
+
+	require.NoError(t, e.generateCNIConf(defaultBridgeName))
+	b, err := ioutil.ReadFile(path.Join(e.networkConfigABSPath, cniConfListFileName))
+	require.NoError(t, err)
+	pluginTypes, err := parseCNIConfList(b)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bridge", "host-local", "portmap", "bandwidth", "firewall"}, pluginTypes)
+
+	e.cniPlugin = config.CNIPtp
+	require.NoError(t, e.generateCNIConf(defaultBridgeName))
+	b, err = ioutil.ReadFile(path.Join(e.networkConfigABSPath, cniFileName))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"type": "ptp"`)
+	_, err = os.Stat(path.Join(e.networkConfigABSPath, cniConfListFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	e.cniConfListPath = path.Join(dir, "dev.conflist")
+	conflist := []byte(`{"cniVersion": "0.3.1", "name": "dev", "plugins": [{"type": "ptp", "ipam": {"type": "host-local"}}, {"type": "portmap"}]}`)
+	require.NoError(t, ioutil.WriteFile(e.cniConfListPath, conflist, 0644))
+	require.NoError(t, e.generateCNIConf(defaultBridgeName))
+	b, err = ioutil.ReadFile(path.Join(e.networkConfigABSPath, cniConfListFileName))
+	require.NoError(t, err)
+	assert.Equal(t, conflist, b)
+	_, err = os.Stat(path.Join(e.networkConfigABSPath, cniFileName))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,121 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var dnsLabelRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// dnsStubDomain is a zone of the Corefile forwarded to its own nameservers
+type dnsStubDomain struct {
+	Domain     string `json:"domain"`
+	Forwarders string `json:"forwarders"`
+}
+
+// dnsHost is a static entry of the hosts plugin of the Corefile
+type dnsHost struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+// normalizeDomain returns the lower case domain without the trailing dot or an error if it isn't a valid DNS name
+func normalizeDomain(domain string) (string, error) {
+	normalized := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if normalized == "" || len(normalized) > 253 {
+		return "", fmt.Errorf("invalid domain %q", domain)
+	}
+	for _, label := range strings.Split(normalized, ".") {
+		if len(label) > 63 || !dnsLabelRegexp.MatchString(label) {
+			return "", fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	return normalized, nil
+}
+
+// splitDNSEntry returns the two parts of a name=value entry
+func splitDNSEntry(entry string) (string, string, error) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid entry %q, must be name=value", entry)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+// parseDNSForwarder validates a ip[:port] or [ipv6]:port forwarder
+func parseDNSForwarder(forwarder string) (string, error) {
+	if net.ParseIP(forwarder) != nil {
+		return forwarder, nil
+	}
+	host, port, err := net.SplitHostPort(forwarder)
+	if err != nil {
+		return "", fmt.Errorf("invalid forwarder %q, must be ip[:port]", forwarder)
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid forwarder %q, must be ip[:port]", forwarder)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("invalid port in the forwarder %q", forwarder)
+	}
+	return forwarder, nil
+}
+
+// parseDNSStubDomains parses the domain=ip[:port] entries, the forwarders of a repeated domain are grouped
+func parseDNSStubDomains(entries []string, clusterDomain string) ([]dnsStubDomain, error) {
+	var stubDomains []dnsStubDomain
+	index := make(map[string]int)
+	for _, entry := range entries {
+		domain, forwarder, err := splitDNSEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		domain, err = normalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		if domain == clusterDomain {
+			return nil, fmt.Errorf("the stub domain %q is the cluster domain", domain)
+		}
+		forwarder, err = parseDNSForwarder(forwarder)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := index[domain]
+		if ok {
+			stubDomains[i].Forwarders += " " + forwarder
+			continue
+		}
+		index[domain] = len(stubDomains)
+		stubDomains = append(stubDomains, dnsStubDomain{Domain: domain, Forwarders: forwarder})
+	}
+	return stubDomains, nil
+}
+
+// parseDNSHosts parses the name=ip entries
+func parseDNSHosts(entries []string) ([]dnsHost, error) {
+	var hosts []dnsHost
+	for _, entry := range entries {
+		name, ip, err := splitDNSEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		name, err = normalizeDomain(name)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid IP address %q for the host %s", ip, name)
+		}
+		hosts = append(hosts, dnsHost{Name: name, IP: ip})
+	}
+	return hosts, nil
+}
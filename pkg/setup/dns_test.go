@@ -0,0 +1,109 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	defaultTemplates "github.com/DataDog/pupernetes/pkg/setup/templates"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	for given, expected := range map[string]string{
+		"cluster.local":  "cluster.local",
+		"Cluster.Local.": "cluster.local",
+		"k8s-1.example":  "k8s-1.example",
+	} {
+		domain, err := normalizeDomain(given)
+		require.NoError(t, err, given)
+		assert.Equal(t, expected, domain)
+	}
+	for _, given := range []string{"", ".", "cluster..local", "-cluster.local", "cluster_local", strings.Repeat("a", 64) + ".local"} {
+		_, err := normalizeDomain(given)
+		assert.Error(t, err, given)
+	}
+}
+
+func TestParseDNSStubDomains(t *testing.T) {
+	stubDomains, err := parseDNSStubDomains([]string{
+		"intake.example.=10.0.0.10",
+		"consul=10.0.0.11:8600",
+		"intake.example=[fd00::10]:53",
+	}, "cluster.local")
+	require.NoError(t, err)
+	assert.Equal(t, []dnsStubDomain{
+		{Domain: "intake.example", Forwarders: "10.0.0.10 [fd00::10]:53"},
+		{Domain: "consul", Forwarders: "10.0.0.11:8600"},
+	}, stubDomains)
+
+	for _, entry := range []string{
+		"consul",
+		"consul=",
+		"consul=consul.example",
+		"consul=10.0.0.11:0",
+		"consul=10.0.0.11:dns",
+		"cluster.local=10.0.0.11",
+	} {
+		_, err = parseDNSStubDomains([]string{entry}, "cluster.local")
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestParseDNSHosts(t *testing.T) {
+	hosts, err := parseDNSHosts([]string{"intake.example=127.0.0.1", "api.intake.example=fd00::1"})
+	require.NoError(t, err)
+	assert.Equal(t, []dnsHost{
+		{Name: "intake.example", IP: "127.0.0.1"},
+		{Name: "api.intake.example", IP: "fd00::1"},
+	}, hosts)
+
+	for _, entry := range []string{"intake.example", "intake.example=localhost", "intake_example=127.0.0.1"} {
+		_, err = parseDNSHosts([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestRenderCorefile(t *testing.T) {
+	metadata := &templateMetadata{
+		ServiceClusterIPRange: "192.168.254.0/24",
+		DNSClusterIP:          "192.168.254.2",
+		UpstreamNameservers:   new(string),
+		Hostname:              new(string),
+		RootABSPath:           new(string),
+		NodeIP:                new(string),
+		ClusterDomain:         "k8s.test",
+		DNSStubDomains:        []dnsStubDomain{{Domain: "consul", Forwarders: "10.0.0.11:8600"}},
+		DNSHosts:              []dnsHost{{Name: "intake.example", IP: "10.0.0.12"}},
+	}
+	*metadata.UpstreamNameservers = "8.8.8.8"
+
+	for version, manifests := range defaultTemplates.Manifests {
+		found := false
+		for _, manifest := range manifests {
+			if manifest.Name != "coredns.yaml" {
+				continue
+			}
+			found = true
+			tmpl, err := template.New(manifest.Name).Parse(string(manifest.Content))
+			require.NoError(t, err, version)
+			b := &bytes.Buffer{}
+			require.NoError(t, tmpl.Execute(b, metadata), version)
+			corefile := b.String()
+			assert.Contains(t, corefile, "        kubernetes k8s.test 192.168.254.0/24 {\n", version)
+			assert.Contains(t, corefile, "        hosts {\n          10.0.0.12 intake.example\n          fallthrough\n        }\n", version)
+			assert.Contains(t, corefile, "    }\n    consul:53 {\n        errors\n", version)
+			assert.Contains(t, corefile, " . 10.0.0.11:8600\n        cache 30\n    }\n---\n", version)
+			assert.NotContains(t, corefile, "cluster.local", version)
+		}
+		assert.True(t, found, version)
+	}
+}
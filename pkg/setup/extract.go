@@ -0,0 +1,221 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// zipCreatorUnix is the host system of the zip archives carrying unix permissions
+const zipCreatorUnix = 3
+
+// extractOptions selects the content of an archive to extract
+type extractOptions struct {
+	// stripComponents removes the given number of leading path elements, like tar --strip-components
+	stripComponents int
+	// files are the paths to extract after the strip, everything if empty
+	files []string
+}
+
+// destination returns the path of the archive member inside destDir, empty if the member is skipped
+func (o *extractOptions) destination(destDir, name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	parts := strings.Split(name, "/")
+	if name == "" || len(parts) <= o.stripComponents {
+		return "", nil
+	}
+	name = strings.Join(parts[o.stripComponents:], "/")
+	if len(o.files) > 0 {
+		wanted := false
+		for _, f := range o.files {
+			if f == name {
+				wanted = true
+				break
+			}
+		}
+		if !wanted {
+			return "", nil
+		}
+	}
+	dest := filepath.Join(destDir, name)
+	if !strings.HasPrefix(dest, filepath.Clean(destDir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid path %q outside of %s", name, destDir)
+	}
+	return dest, nil
+}
+
+// writeFile writes the content in a temporary file renamed over dest,
+// this allows to replace an executable currently running
+func writeFile(dest string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(path.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := dest + ".extract"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// the umask may have altered the mode
+	err = os.Chmod(tmp, mode.Perm())
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func writeSymlink(destDir, dest, target string) error {
+	resolved := target
+	if !path.IsAbs(target) {
+		resolved = filepath.Join(path.Dir(dest), target)
+	}
+	if !strings.HasPrefix(resolved, filepath.Clean(destDir)+string(os.PathSeparator)) {
+		return fmt.Errorf("invalid link %s -> %s outside of %s", dest, target, destDir)
+	}
+	err := os.MkdirAll(path.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(dest)
+	return os.Symlink(target, dest)
+}
+
+// extractTarGz extracts the gzip compressed tarball in destDir and returns the extracted paths
+func extractTarGz(archivePath, destDir string, opts *extractOptions) ([]string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var extracted []string
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return extracted, err
+		}
+		dest, err := opts.destination(destDir, hdr.Name)
+		if err != nil {
+			return extracted, err
+		}
+		if dest == "" {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dest, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(dest, tr, os.FileMode(hdr.Mode))
+		case tar.TypeSymlink:
+			err = writeSymlink(destDir, dest, hdr.Linkname)
+		default:
+			glog.V(4).Infof("Skipping %s of type %q in %s", hdr.Name, hdr.Typeflag, archivePath)
+			continue
+		}
+		if err != nil {
+			return extracted, err
+		}
+		extracted = append(extracted, dest)
+	}
+	return extracted, nil
+}
+
+// extractZip extracts the zip archive in destDir and returns the extracted paths
+func extractZip(archivePath, destDir string, opts *extractOptions) ([]string, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var extracted []string
+	for _, zf := range zr.File {
+		dest, err := opts.destination(destDir, zf.Name)
+		if err != nil {
+			return extracted, err
+		}
+		if dest == "" {
+			continue
+		}
+		if zf.FileInfo().IsDir() {
+			err = os.MkdirAll(dest, 0755)
+			if err != nil {
+				return extracted, err
+			}
+			extracted = append(extracted, dest)
+			continue
+		}
+		mode := zf.Mode()
+		if zf.CreatorVersion>>8 != zipCreatorUnix || mode.Perm() == 0 {
+			// archives created without unix attributes
+			mode = 0755
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return extracted, err
+		}
+		err = writeFile(dest, r, mode)
+		r.Close()
+		if err != nil {
+			return extracted, err
+		}
+		extracted = append(extracted, dest)
+	}
+	return extracted, nil
+}
+
+// extractArchive extracts the archive of d in destDir, the archive is removed on failure
+func (d *depBinary) extractArchive(destDir string, opts *extractOptions) error {
+	glog.V(2).Infof("Extracting %s", d.archivePath)
+	extractFn := extractTarGz
+	if strings.HasSuffix(d.archivePath, ".zip") {
+		extractFn = extractZip
+	}
+	extracted, err := extractFn(d.archivePath, destDir, opts)
+	if err != nil {
+		glog.Errorf("Cannot extract %s: %v", d.archivePath, err)
+		_ = d.removeArchive()
+		return err
+	}
+	_, err = os.Stat(d.binaryABSPath)
+	if err != nil {
+		glog.Errorf("Unexpected error: %v after extracting %s", err, d.archivePath)
+		return err
+	}
+	glog.V(2).Infof("Successfully extracted %s: %s", d.archivePath, strings.Join(extracted, " "))
+	return nil
+}
@@ -0,0 +1,150 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type archiveMember struct {
+	name     string
+	content  string
+	mode     int64
+	typeflag byte
+	linkname string
+}
+
+func writeTarGz(t *testing.T, p string, members []archiveMember) {
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, m := range members {
+		hdr := &tar.Header{
+			Name:     m.name,
+			Mode:     m.mode,
+			Size:     int64(len(m.content)),
+			Typeflag: m.typeflag,
+			Linkname: m.linkname,
+		}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write([]byte(m.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-extract")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	archive := path.Join(dir, "etcd.tar.gz")
+	writeTarGz(t, archive, []archiveMember{
+		{name: "etcd-v3.4.7-linux-amd64/", mode: 0755, typeflag: tar.TypeDir},
+		{name: "etcd-v3.4.7-linux-amd64/etcd", content: "etcd", mode: 0755},
+		{name: "etcd-v3.4.7-linux-amd64/etcdctl", content: "etcdctl", mode: 0755},
+		{name: "etcd-v3.4.7-linux-amd64/README.md", content: "readme", mode: 0644},
+	})
+
+	bin := path.Join(dir, "bin")
+	extracted, err := extractTarGz(archive, bin, &extractOptions{stripComponents: 1, files: []string{"etcd"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{path.Join(bin, "etcd")}, extracted)
+
+	st, err := os.Stat(path.Join(bin, "etcd"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), st.Mode().Perm())
+	_, err = os.Stat(path.Join(bin, "etcdctl"))
+	assert.True(t, os.IsNotExist(err))
+
+	extracted, err = extractTarGz(archive, bin, &extractOptions{stripComponents: 1})
+	require.NoError(t, err)
+	assert.Len(t, extracted, 3)
+	st, err = os.Stat(path.Join(bin, "README.md"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), st.Mode().Perm())
+}
+
+func TestExtractTarGzTraversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-extract")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bin := path.Join(dir, "bin")
+
+	// the leading ../ are cleaned and kept inside the destination
+	archive := path.Join(dir, "dotdot.tar.gz")
+	writeTarGz(t, archive, []archiveMember{
+		{name: "../../escape", content: "x", mode: 0644},
+	})
+	extracted, err := extractTarGz(archive, bin, &extractOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{path.Join(bin, "escape")}, extracted)
+	_, err = os.Stat(path.Join(dir, "escape"))
+	assert.True(t, os.IsNotExist(err))
+
+	archive = path.Join(dir, "symlink.tar.gz")
+	writeTarGz(t, archive, []archiveMember{
+		{name: "./passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+	})
+	_, err = extractTarGz(archive, bin, &extractOptions{})
+	assert.Error(t, err)
+
+	archive = path.Join(dir, "relative-symlink.tar.gz")
+	writeTarGz(t, archive, []archiveMember{
+		{name: "./up", typeflag: tar.TypeSymlink, linkname: "../.."},
+	})
+	_, err = extractTarGz(archive, bin, &extractOptions{})
+	assert.Error(t, err)
+}
+
+func TestExtractZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-extract")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	archive := path.Join(dir, "vault.zip")
+	f, err := os.Create(archive)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, content := range map[string]string{"vault": "vault", "LICENSE": "license"} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	bin := path.Join(dir, "bin")
+	extracted, err := extractZip(archive, bin, &extractOptions{files: []string{"vault"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{path.Join(bin, "vault")}, extracted)
+
+	b, err := ioutil.ReadFile(path.Join(bin, "vault"))
+	require.NoError(t, err)
+	assert.Equal(t, "vault", string(b))
+	st, err := os.Stat(path.Join(bin, "vault"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), st.Mode().Perm())
+}
@@ -6,13 +6,18 @@
 package setup
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path"
 
+	"github.com/Masterminds/semver"
 	"github.com/coreos/go-systemd/dbus"
 	"github.com/golang/glog"
 	"k8s.io/client-go/kubernetes"
+
+	"github.com/DataDog/pupernetes/pkg/config"
+	"github.com/DataDog/pupernetes/pkg/pki"
 )
 
 // GetHyperkubePath returns the hyperkube binary abstract path
@@ -138,3 +143,67 @@ func (e *Environment) GetDNSClusterIP() string {
 	}
 	return e.dnsClusterIP.String()
 }
+
+// GetAPICertificatePaths returns the certificate and the private key of the pupernetes API
+// and the root CA verifying its clients
+func (e *Environment) GetAPICertificatePaths() (string, string, string) {
+	return pki.FilePath(e.secretsABSPath, certificatePupernetesAPI, pki.CertificateSuffix),
+		pki.FilePath(e.secretsABSPath, certificatePupernetesAPI, pki.PrivateKeySuffix),
+		pki.FilePath(e.secretsABSPath, rootCertificateAuthorityName, pki.CertificateSuffix)
+}
+
+// GetKubectlServerDryRunFlags returns the kubectl apply flags of a server-side dry-run
+func (e *Environment) GetKubectlServerDryRunFlags() ([]string, error) {
+	c, err := semver.NewConstraint(">=1.18.0")
+	if err != nil {
+		return nil, err
+	}
+	if c.Check(e.kubeVersion) {
+		return []string{"--dry-run=server"}, nil
+	}
+	c, err = semver.NewConstraint(">=1.13.0")
+	if err != nil {
+		return nil, err
+	}
+	if c.Check(e.kubeVersion) {
+		return []string{"--server-dry-run"}, nil
+	}
+	return nil, fmt.Errorf("server-side dry-run requires Kubernetes 1.13 or later, got %s", e.kubeVersion.String())
+}
+
+// GetRootABSPath returns the state directory
+func (e *Environment) GetRootABSPath() string {
+	return e.rootABSPath
+}
+
+// GetVersions returns the versions of the components in use
+func (e *Environment) GetVersions() map[string]string {
+	versions := map[string]string{
+		"hyperkube": e.binaryHyperkube.version,
+		"etcd":      e.binaryEtcd.version,
+		"cni":       e.binaryCNI.version,
+	}
+	if e.containerRuntimeInterface == config.CRIContainerd {
+		versions["containerd"] = e.binaryContainerd.version
+		versions["runc"] = e.binaryRunc.version
+	}
+	if e.pkiBackend == config.PKIVault {
+		versions["vault"] = e.binaryVault.version
+	}
+	return versions
+}
+
+// GetClusterDomain returns the dns domain of the cluster
+func (e *Environment) GetClusterDomain() string {
+	return e.clusterDomain
+}
+
+// GetCertificateUnits returns the systemd units to restart after a rotation of the certificates
+func (e *Environment) GetCertificateUnits() []string {
+	return []string{e.etcdUnitName, e.kubeAPIServerUnitName, e.kubeletUnitName}
+}
+
+// GetCertificatePods returns the app label of the kube-system pods to recreate after a rotation of the certificates
+func (e *Environment) GetCertificatePods() []string {
+	return componentKubeconfigs
+}
@@ -0,0 +1,283 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/DataDog/pupernetes/pkg/config"
+)
+
+const (
+	hostDNSFileName = "host-dns.json"
+	hostResolvConf  = "/etc/resolv.conf"
+
+	resolvConfBlockBegin = "# BEGIN pupernetes, removed by the clean option dns"
+	resolvConfBlockEnd   = "# END pupernetes"
+)
+
+// hostDNSState is persisted in the state directory to undo the DNS setup of the host
+type hostDNSState struct {
+	Mode       string `json:"mode"`
+	Interface  string `json:"interface,omitempty"`
+	ResolvConf string `json:"resolvConf,omitempty"`
+}
+
+func isSystemdResolvedRunning() bool {
+	return exec.Command("systemd-resolve", "--status", "--no-pager").Run() == nil
+}
+
+func systemdResolve(args ...string) (string, error) {
+	b, err := exec.Command("systemd-resolve", args...).CombinedOutput()
+	return string(b), err
+}
+
+// isBridgeNetwork returns true if the pods are connected to the bridge cni-p8s
+func (e *Environment) isBridgeNetwork() bool {
+	return e.cniConfListPath == "" && (e.cniPlugin == config.CNIBridge || e.cniPlugin == config.CNIChained)
+}
+
+// selectHostDNSMode returns the mode to use for --host-dns
+func (e *Environment) selectHostDNSMode(resolvedRunning bool) (string, error) {
+	switch e.hostDNS {
+	case config.HostDNSAuto:
+		if resolvedRunning && e.isBridgeNetwork() {
+			return config.HostDNSResolved, nil
+		}
+		return config.HostDNSResolvConf, nil
+	case config.HostDNSResolved:
+		if !resolvedRunning {
+			return "", fmt.Errorf("systemd-resolved isn't running, use --host-dns=%s", config.HostDNSResolvConf)
+		}
+		if !e.isBridgeNetwork() {
+			return "", fmt.Errorf("the pods aren't connected to the bridge %s, use --host-dns=%s", defaultBridgeName, config.HostDNSResolvConf)
+		}
+	}
+	return e.hostDNS, nil
+}
+
+// withoutDNSClusterIPs removes the nameservers of the cluster from the ones discovered on the host with --host-dns,
+// CoreDNS would forward the queries to itself
+func (e *Environment) withoutDNSClusterIPs(nameservers []string) []string {
+	var upstreams []string
+	for _, ns := range nameservers {
+		isDNSClusterIP := false
+		for _, dnsClusterIP := range e.dnsClusterIPs {
+			if dnsClusterIP.Equal(net.ParseIP(ns)) {
+				isDNSClusterIP = true
+				break
+			}
+		}
+		if !isDNSClusterIP {
+			upstreams = append(upstreams, ns)
+		}
+	}
+	return upstreams
+}
+
+// removeResolvConfBlock returns the resolv.conf content without the block added by the setup
+func removeResolvConfBlock(content string) string {
+	var lines []string
+	inBlock := false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		switch strings.TrimSpace(line) {
+		case resolvConfBlockBegin:
+			inBlock = true
+			continue
+		case resolvConfBlockEnd:
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// addResolvConfBlock returns the resolv.conf content starting with the given nameservers
+func addResolvConfBlock(content string, nameservers []string) string {
+	block := resolvConfBlockBegin + "\n"
+	for _, ns := range nameservers {
+		block += fmt.Sprintf("nameserver %s\n", ns)
+	}
+	block += resolvConfBlockEnd + "\n"
+	return block + removeResolvConfBlock(content)
+}
+
+func (e *Environment) getHostDNSStatePath() string {
+	return path.Join(e.rootABSPath, hostDNSFileName)
+}
+
+func (e *Environment) readHostDNSState() (*hostDNSState, error) {
+	state := &hostDNSState{}
+	b, err := ioutil.ReadFile(e.getHostDNSStatePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		glog.Errorf("Cannot read the host dns state %s: %v", e.getHostDNSStatePath(), err)
+		return nil, err
+	}
+	err = json.Unmarshal(b, state)
+	if err != nil {
+		glog.Errorf("Cannot parse the host dns state %s: %v", e.getHostDNSStatePath(), err)
+		return nil, err
+	}
+	return state, nil
+}
+
+func (e *Environment) writeHostDNSState(state *hostDNSState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		glog.Errorf("Cannot marshal the host dns state: %v", err)
+		return err
+	}
+	err = ioutil.WriteFile(e.getHostDNSStatePath(), b, 0644)
+	if err != nil {
+		glog.Errorf("Cannot write the host dns state %s: %v", e.getHostDNSStatePath(), err)
+		return err
+	}
+	return nil
+}
+
+func (e *Environment) setupResolvedLink() error {
+	// the bridge plugin uses the existing bridge, created before the first pod to configure its link in systemd-resolved
+	err := setupBridge()
+	if err != nil {
+		return err
+	}
+	args := []string{"--interface", defaultBridgeName, "--set-domain", "~" + e.clusterDomain}
+	for _, dnsClusterIP := range e.dnsClusterIPs {
+		args = append(args, "--set-dns", dnsClusterIP.String())
+	}
+	output, err := systemdResolve(args...)
+	if err != nil {
+		glog.Errorf("Cannot configure the link %s in systemd-resolved: %s, %v", defaultBridgeName, output, err)
+		return err
+	}
+	glog.Infof("Routing the domain %s to %s with systemd-resolved on the link %s", e.clusterDomain, joinIPs(e.dnsClusterIPs), defaultBridgeName)
+	return nil
+}
+
+// AddHostDNSNameserver adds the DNS cluster IP as first nameserver of the resolv.conf of the host with --host-dns=resolv-conf,
+// only once CoreDNS is ready: the host lookups would time out on it before.
+// A single nameserver is added to keep the ones of the host in the three used by the resolver
+func (e *Environment) AddHostDNSNameserver() error {
+	state, err := e.readHostDNSState()
+	if err != nil {
+		return err
+	}
+	if state == nil || state.Mode != config.HostDNSResolvConf {
+		return nil
+	}
+	b, err := ioutil.ReadFile(state.ResolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Cannot read %s: %v", state.ResolvConf, err)
+		return err
+	}
+	nameserver := e.GetDNSClusterIP()
+	err = ioutil.WriteFile(state.ResolvConf, []byte(addResolvConfBlock(string(b), []string{nameserver})), 0644)
+	if err != nil {
+		glog.Errorf("Cannot write %s: %v", state.ResolvConf, err)
+		return err
+	}
+	glog.Infof("Added %s as first nameserver in %s", nameserver, state.ResolvConf)
+	return nil
+}
+
+// RemoveHostDNSNameserver removes the DNS cluster IP from the resolv.conf of the host before CoreDNS is stopped
+func (e *Environment) RemoveHostDNSNameserver() error {
+	state, err := e.readHostDNSState()
+	if err != nil {
+		return err
+	}
+	if state == nil || state.Mode != config.HostDNSResolvConf {
+		return nil
+	}
+	b, err := ioutil.ReadFile(state.ResolvConf)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		glog.Errorf("Cannot read %s: %v", state.ResolvConf, err)
+		return err
+	}
+	content := removeResolvConfBlock(string(b))
+	if content == string(b) {
+		return nil
+	}
+	err = ioutil.WriteFile(state.ResolvConf, []byte(content), 0644)
+	if err != nil {
+		glog.Errorf("Cannot write %s: %v", state.ResolvConf, err)
+		return err
+	}
+	glog.Infof("Removed the nameserver of the cluster from %s", state.ResolvConf)
+	return nil
+}
+
+// setupHostDNS makes the cluster domain resolvable from the host with --host-dns
+func (e *Environment) setupHostDNS() error {
+	if e.hostDNS == config.HostDNSNone {
+		glog.V(4).Infof("Host DNS unchanged")
+		return nil
+	}
+	mode, err := e.selectHostDNSMode(isSystemdResolvedRunning())
+	if err != nil {
+		glog.Errorf("Cannot setup the host DNS: %v", err)
+		return err
+	}
+	state := &hostDNSState{Mode: mode}
+	switch mode {
+	case config.HostDNSResolved:
+		state.Interface = defaultBridgeName
+		err = e.setupResolvedLink()
+	case config.HostDNSResolvConf:
+		// the nameserver is added once CoreDNS is ready
+		state.ResolvConf = hostResolvConf
+	}
+	if err != nil {
+		return err
+	}
+	return e.writeHostDNSState(state)
+}
+
+// cleanHostDNS undoes the DNS setup of the host recorded in the state directory
+func (e *Environment) cleanHostDNS() error {
+	state, err := e.readHostDNSState()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		glog.V(4).Infof("No host DNS to clean")
+		return nil
+	}
+	switch state.Mode {
+	case config.HostDNSResolved:
+		output, err := systemdResolve("--interface", state.Interface, "--revert")
+		if err != nil {
+			// the link is reset when the bridge is deleted
+			glog.Warningf("Cannot revert the link %s in systemd-resolved: %s, %v", state.Interface, output, err)
+		} else {
+			glog.Infof("Reverted the link %s in systemd-resolved", state.Interface)
+		}
+	case config.HostDNSResolvConf:
+		err = e.RemoveHostDNSNameserver()
+		if err != nil {
+			return err
+		}
+	}
+	return remove(e.getHostDNSStatePath())
+}
@@ -0,0 +1,97 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/pupernetes/pkg/config"
+)
+
+func TestResolvConfBlock(t *testing.T) {
+	const original = "search example.com\nnameserver 10.0.0.2\n"
+
+	content := addResolvConfBlock(original, []string{"192.168.254.2"})
+	assert.Equal(t, resolvConfBlockBegin+"\nnameserver 192.168.254.2\n"+resolvConfBlockEnd+"\n"+original, content)
+	assert.Equal(t, []string{"192.168.254.2", "10.0.0.2"}, getNameserverFromResolvConf([]byte(content)))
+
+	// the block is replaced by the next setup
+	content = addResolvConfBlock(content, []string{"192.168.252.2", "fd00:254::2"})
+	assert.Equal(t, resolvConfBlockBegin+"\nnameserver 192.168.252.2\nnameserver fd00:254::2\n"+resolvConfBlockEnd+"\n"+original, content)
+
+	assert.Equal(t, original, removeResolvConfBlock(content))
+	assert.Equal(t, original, removeResolvConfBlock(original))
+}
+
+func TestSelectHostDNSMode(t *testing.T) {
+	e := &Environment{hostDNS: config.HostDNSAuto, cniPlugin: config.CNIBridge}
+	mode, err := e.selectHostDNSMode(true)
+	require.NoError(t, err)
+	assert.Equal(t, config.HostDNSResolved, mode)
+	mode, err = e.selectHostDNSMode(false)
+	require.NoError(t, err)
+	assert.Equal(t, config.HostDNSResolvConf, mode)
+
+	e.cniPlugin = config.CNIPtp
+	mode, err = e.selectHostDNSMode(true)
+	require.NoError(t, err)
+	assert.Equal(t, config.HostDNSResolvConf, mode)
+
+	e.hostDNS = config.HostDNSResolved
+	_, err = e.selectHostDNSMode(true)
+	assert.Error(t, err)
+	e.cniPlugin = config.CNIChained
+	_, err = e.selectHostDNSMode(false)
+	assert.Error(t, err)
+	mode, err = e.selectHostDNSMode(true)
+	require.NoError(t, err)
+	assert.Equal(t, config.HostDNSResolved, mode)
+}
+
+func TestWithoutDNSClusterIPs(t *testing.T) {
+	e := &Environment{dnsClusterIPs: []net.IP{net.ParseIP("192.168.254.2"), net.ParseIP("fd00:254::2")}}
+	assert.Equal(t, []string{"10.0.0.2", "fd00::1"}, e.withoutDNSClusterIPs([]string{"192.168.254.2", "10.0.0.2", "fd00:254:0::2", "fd00::1"}))
+	assert.Nil(t, e.withoutDNSClusterIPs([]string{"192.168.254.2"}))
+}
+
+func TestHostDNSNameserver(t *testing.T) {
+	const original = "nameserver 10.0.0.2\nnameserver 10.0.0.3\nnameserver 10.0.0.4\n"
+	dir, err := ioutil.TempDir("", "p8s-hostdns")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	resolvConf := path.Join(dir, "resolv.conf")
+	require.NoError(t, ioutil.WriteFile(resolvConf, []byte(original), 0644))
+
+	dnsClusterIP := net.ParseIP("192.168.254.2")
+	e := &Environment{
+		rootABSPath:   dir,
+		dnsClusterIP:  &dnsClusterIP,
+		dnsClusterIPs: []net.IP{dnsClusterIP, net.ParseIP("fd00:254::2")},
+	}
+	// nothing to do without the setup of --host-dns
+	require.NoError(t, e.AddHostDNSNameserver())
+	b, err := ioutil.ReadFile(resolvConf)
+	require.NoError(t, err)
+	assert.Equal(t, original, string(b))
+
+	require.NoError(t, e.writeHostDNSState(&hostDNSState{Mode: config.HostDNSResolvConf, ResolvConf: resolvConf}))
+	require.NoError(t, e.AddHostDNSNameserver())
+	b, err = ioutil.ReadFile(resolvConf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.254.2", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, getNameserverFromResolvConf(b))
+
+	require.NoError(t, e.RemoveHostDNSNameserver())
+	b, err = ioutil.ReadFile(resolvConf)
+	require.NoError(t, err)
+	assert.Equal(t, original, string(b))
+}
@@ -12,24 +12,36 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 	"unicode"
 
 	"github.com/golang/glog"
+
+	"github.com/DataDog/pupernetes/pkg/config"
 )
 
 const (
 	validHostnameRegex     = `[a-z0-9]([-a-z0-9]*[a-z0-9])?`
 	invalidHostnameMessage = `a DNS-1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character (e.g. 'example.com', regex used for validation is ` + validHostnameRegex
 
-	awsMetadataEndpoint = "http://169.254.169.254/latest/meta-data"
-	awsLocalHostname    = awsMetadataEndpoint + "/local-hostname"
-	awsPublicHostname   = awsMetadataEndpoint + "/public-hostname"
-	awsLocalIpv4        = awsMetadataEndpoint + "/local-ipv4"
+	metadataTimeout = time.Second
 )
 
 var hostnameRegex = regexp.MustCompile(validHostnameRegex)
 
+// lookupHost and lookupAddr are replaced in the tests
+var (
+	lookupHost = net.LookupHost
+	lookupAddr = net.LookupAddr
+)
+
+// hostnameProvider returns a hostname candidate, checked before being used
+type hostnameProvider struct {
+	name        string
+	getHostname func() (string, error)
+}
+
 func isValidHostname(h string) bool {
 	runes := []rune(h)
 	for c := 0; c < len(runes); c++ {
@@ -54,7 +66,7 @@ func checkHostname(hostname string) error {
 		glog.Errorf("Invalid hostname: %q", hostname)
 		return fmt.Errorf("invalid hostname: %q, %s", hostname, invalidHostnameMessage)
 	}
-	_, err := net.LookupHost(hostname)
+	_, err := lookupHost(hostname)
 	if err == nil {
 		glog.V(2).Infof("Using hostname: %q", hostname)
 		return nil
@@ -63,52 +75,130 @@ func checkHostname(hostname string) error {
 	return err
 }
 
-func getAWSHostname(url string) (string, error) {
-	glog.V(2).Infof("Trying AWS hostname on %s ...", url)
-	c := &http.Client{Timeout: time.Second}
-	resp, err := c.Get(url)
+// getMetadata returns the body of a metadata endpoint of a cloud provider
+func getMetadata(url string, header map[string]string) (string, error) {
+	glog.V(2).Infof("Trying the metadata endpoint %s ...", url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		glog.Errorf("Fail to reach AWS metadata: %v", err)
 		return "", err
 	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	c := &http.Client{Timeout: metadataTimeout}
+	resp, err := c.Do(req)
+	if err != nil {
+		glog.V(2).Infof("Fail to reach the metadata endpoint: %v", err)
+		return "", err
+	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		err = fmt.Errorf("unexpected status code on %s: %d", url, resp.StatusCode)
-		glog.Errorf("Cannot GET AWS endpoint: %v", err)
+		glog.V(2).Infof("Cannot GET the metadata endpoint: %v", err)
 		return "", err
 	}
-
-	defer resp.Body.Close()
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		glog.Errorf("Cannot read the AWS metadata response: %v", err)
+		glog.Errorf("Cannot read the metadata response of %s: %v", url, err)
 		return "", err
 	}
-	hostname := string(b)
-	return hostname, checkHostname(hostname)
+	return strings.TrimSpace(string(b)), nil
 }
 
-func (e *Environment) setupHostname() error {
-	hostname, err := os.Hostname()
+// getAWSHostname tries the local hostname, the public hostname and the local IPv4 of the instance
+func getAWSHostname(endpoint string) (string, error) {
+	var err error
+	for _, p := range []string{"/local-hostname", "/public-hostname", "/local-ipv4"} {
+		var hostname string
+		hostname, err = getMetadata(endpoint+p, nil)
+		if err == nil && hostname != "" {
+			return hostname, nil
+		}
+	}
+	return "", fmt.Errorf("no AWS hostname: %v", err)
+}
+
+// getGCEHostname returns the fully qualified hostname of the instance
+func getGCEHostname(endpoint string) (string, error) {
+	return getMetadata(endpoint+"/instance/hostname", map[string]string{"Metadata-Flavor": "Google"})
+}
+
+// getAzureHostname returns the name of the virtual machine, the Azure names are case insensitive
+func getAzureHostname(endpoint string) (string, error) {
+	name, err := getMetadata(endpoint+"/compute/name?api-version=2017-08-01&format=text", map[string]string{"Metadata": "true"})
+	return strings.ToLower(name), err
+}
+
+// getReverseDNSHostname returns the first name of the node IP
+func (e *Environment) getReverseDNSHostname() (string, error) {
+	ip, err := e.getNodeIP()
 	if err != nil {
-		return err
+		return "", err
+	}
+	names, err := lookupAddr(ip.String())
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no name for %s", ip.String())
 	}
+	return strings.TrimSuffix(names[0], "."), nil
+}
 
-	if checkHostname(hostname) == nil {
-		e.hostname = hostname
-		return nil
+// hostnameProviders returns the providers of --hostname-providers in the given order
+func (e *Environment) hostnameProviders() ([]hostnameProvider, error) {
+	var providers []hostnameProvider
+	for _, name := range e.hostnameProviderNames {
+		p := hostnameProvider{name: name}
+		switch name {
+		case config.HostnameProviderOverride:
+			p.getHostname = func() (string, error) { return e.hostnameOverride, nil }
+		case config.HostnameProviderOS:
+			p.getHostname = os.Hostname
+		case config.HostnameProviderAWS:
+			p.getHostname = func() (string, error) { return getAWSHostname(e.awsMetadataEndpoint) }
+		case config.HostnameProviderGCE:
+			p.getHostname = func() (string, error) { return getGCEHostname(e.gceMetadataEndpoint) }
+		case config.HostnameProviderAzure:
+			p.getHostname = func() (string, error) { return getAzureHostname(e.azureMetadataEndpoint) }
+		case config.HostnameProviderReverseDNS:
+			p.getHostname = e.getReverseDNSHostname
+		default:
+			return nil, fmt.Errorf("unsupported hostname provider %q, must be in %s", name, strings.Join(config.HostnameProviders, ","))
+		}
+		providers = append(providers, p)
 	}
+	return providers, nil
+}
 
-	for _, ep := range []string{
-		awsLocalHostname,
-		awsPublicHostname,
-		awsLocalIpv4,
-	} {
-		hostname, err = getAWSHostname(ep)
-		if err == nil {
-			e.hostname = hostname
-			return nil
+// setupHostname uses the first valid hostname of the providers
+func (e *Environment) setupHostname() error {
+	providers, err := e.hostnameProviders()
+	if err != nil {
+		glog.Errorf("Cannot get the hostname: %v", err)
+		return err
+	}
+	for _, p := range providers {
+		hostname, err := p.getHostname()
+		if err != nil {
+			glog.V(2).Infof("No hostname from the provider %s: %v", p.name, err)
+			continue
+		}
+		if hostname == "" {
+			glog.V(4).Infof("No hostname from the provider %s", p.name)
+			continue
+		}
+		err = checkHostname(hostname)
+		if err != nil {
+			glog.Warningf("Skipping the hostname %q of the provider %s: %v", hostname, p.name, err)
+			continue
 		}
+		glog.Infof("Using the hostname %q from the provider %s", hostname, p.name)
+		e.hostname = hostname
+		return nil
 	}
+	err = fmt.Errorf("no valid hostname from the providers %s", strings.Join(e.hostnameProviderNames, ","))
+	glog.Errorf("Cannot get the hostname: %v", err)
 	return err
 }
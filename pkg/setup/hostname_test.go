@@ -6,8 +6,15 @@
 package setup
 
 import (
-	"github.com/stretchr/testify/assert"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/pupernetes/pkg/config"
 )
 
 func TestCheckHostname(t *testing.T) {
@@ -42,3 +49,94 @@ func TestCheckHostname(t *testing.T) {
 		})
 	}
 }
+
+// fakeLookup resolves the given hostnames and 10.0.0.12 to node-12.example
+func fakeLookup(resolvable ...string) func() {
+	host, addr := lookupHost, lookupAddr
+	lookupHost = func(h string) ([]string, error) {
+		for _, r := range resolvable {
+			if h == r {
+				return []string{"10.0.0.12"}, nil
+			}
+		}
+		return nil, fmt.Errorf("no such host %s", h)
+	}
+	lookupAddr = func(addr string) ([]string, error) {
+		if addr == "10.0.0.12" {
+			return []string{"node-12.example."}, nil
+		}
+		return nil, fmt.Errorf("no name for %s", addr)
+	}
+	return func() {
+		lookupHost, lookupAddr = host, addr
+	}
+}
+
+func TestSetupHostname(t *testing.T) {
+	defer fakeLookup("ip-10-0-0-12.ec2.internal", "node-12.c.project.internal", "node-12", "node-12.example", "p8s")()
+
+	aws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/meta-data/local-hostname":
+			w.WriteHeader(http.StatusNotFound)
+		case "/latest/meta-data/public-hostname":
+			fmt.Fprint(w, "ip-10-0-0-12.ec2.internal\n")
+		}
+	}))
+	defer aws.Close()
+	gce := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/hostname" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "node-12.c.project.internal")
+	}))
+	defer gce.Close()
+	azure := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Path != "/metadata/instance/compute/name" || r.URL.Query().Get("format") != "text" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "Node-12")
+	}))
+	defer azure.Close()
+
+	e := &Environment{
+		awsMetadataEndpoint:   aws.URL + "/latest/meta-data",
+		gceMetadataEndpoint:   gce.URL + "/computeMetadata/v1",
+		azureMetadataEndpoint: azure.URL + "/metadata/instance",
+		nodeIPOverride:        "10.0.0.12",
+	}
+	for _, tc := range []struct {
+		providers []string
+		override  string
+		expected  string
+	}{
+		{[]string{config.HostnameProviderAWS}, "", "ip-10-0-0-12.ec2.internal"},
+		{[]string{config.HostnameProviderGCE}, "", "node-12.c.project.internal"},
+		{[]string{config.HostnameProviderAzure}, "", "node-12"},
+		{[]string{config.HostnameProviderReverseDNS}, "", "node-12.example"},
+		{[]string{config.HostnameProviderOverride, config.HostnameProviderAzure}, "", "node-12"},
+		{[]string{config.HostnameProviderOverride, config.HostnameProviderAzure}, "p8s", "p8s"},
+		// not resolvable
+		{[]string{config.HostnameProviderOverride, config.HostnameProviderGCE}, "unknown", "node-12.c.project.internal"},
+	} {
+		e.hostnameProviderNames = tc.providers
+		e.hostnameOverride = tc.override
+		require.NoError(t, e.setupHostname(), tc.providers)
+		assert.Equal(t, tc.expected, e.hostname, tc.providers)
+	}
+
+	aws.Close()
+	e.hostnameProviderNames = []string{config.HostnameProviderAWS}
+	assert.Error(t, e.setupHostname())
+
+	e.hostnameProviderNames = []string{"digitalocean"}
+	_, err := e.hostnameProviders()
+	assert.Error(t, err)
+	for _, name := range config.HostnameProviders {
+		e.hostnameProviderNames = []string{name}
+		_, err = e.hostnameProviders()
+		assert.NoError(t, err, name)
+	}
+}
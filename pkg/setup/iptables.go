@@ -0,0 +1,157 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// iptablesChain holds the rules of pupernetes, jumped to from the FORWARD chain
+const iptablesChain = "P8S-FORWARD"
+
+// maxDuplicatedRules bounds the removal of the rules piled up by the previous versions
+const maxDuplicatedRules = 100
+
+// iptablesCommands are the commands managing the IPv4 and the IPv6 rules
+var iptablesCommands = []string{"iptables", "ip6tables"}
+
+func iptables(command string, args ...string) (string, error) {
+	b, err := exec.Command(command, append([]string{"-w"}, args...)...).CombinedOutput()
+	return string(b), err
+}
+
+// isIptablesRule returns true if the rule exists in the chain
+func isIptablesRule(command, chain string, rule ...string) bool {
+	_, err := iptables(command, append([]string{"-C", chain}, rule...)...)
+	return err == nil
+}
+
+// iptablesCommandsInUse returns the commands matching the IP families of the pods
+func (e *Environment) iptablesCommandsInUse() []string {
+	var commands []string
+	for _, cidr := range e.podCIDRs {
+		if isIPv6CIDR(cidr) {
+			commands = append(commands, "ip6tables")
+			continue
+		}
+		commands = append(commands, "iptables")
+	}
+	return commands
+}
+
+func (e *Environment) iptablesRules(command string) [][]string {
+	// docker set an iptables rule to drop by default
+	rules := [][]string{
+		{"--in-interface", defaultBridgeName, "-j", "ACCEPT"},
+		{"--out-interface", defaultBridgeName, "-j", "ACCEPT"},
+	}
+	// the ptp plugin and the given conflists don't use the bridge
+	for _, cidr := range e.podCIDRs {
+		if isIPv6CIDR(cidr) != (command == "ip6tables") {
+			continue
+		}
+		rules = append(rules,
+			[]string{"--source", cidr.String(), "-j", "ACCEPT"},
+			[]string{"--destination", cidr.String(), "-j", "ACCEPT"},
+		)
+	}
+	return rules
+}
+
+// setupIptables creates the pupernetes chain with its rules, once per IP family
+func (e *Environment) setupIptables() error {
+	for _, command := range e.iptablesCommandsInUse() {
+		err := e.setupIptablesChain(command)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Environment) setupIptablesChain(command string) error {
+	_, err := iptables(command, "-n", "-L", iptablesChain)
+	if err != nil {
+		glog.V(4).Infof("Creating %s chain %s", command, iptablesChain)
+		output, err := iptables(command, "-N", iptablesChain)
+		if err != nil {
+			glog.Errorf("Cannot create %s chain %s: %s, %v", command, iptablesChain, output, err)
+			return err
+		}
+	}
+	for _, rule := range e.iptablesRules(command) {
+		if isIptablesRule(command, iptablesChain, rule...) {
+			glog.V(5).Infof("%s rule already in %s: %s", command, iptablesChain, strings.Join(rule, " "))
+			continue
+		}
+		glog.V(4).Infof("Adding %s rule in %s: %s", command, iptablesChain, strings.Join(rule, " "))
+		output, err := iptables(command, append([]string{"-A", iptablesChain}, rule...)...)
+		if err != nil {
+			glog.Errorf("Cannot add %s rule %s: %s, %v", command, strings.Join(rule, " "), output, err)
+			return err
+		}
+	}
+	if isIptablesRule(command, "FORWARD", "-j", iptablesChain) {
+		return nil
+	}
+	glog.V(4).Infof("Jumping to %s from FORWARD with %s", iptablesChain, command)
+	output, err := iptables(command, "-I", "FORWARD", "1", "-j", iptablesChain)
+	if err != nil {
+		glog.Errorf("Cannot jump to %s from FORWARD with %s: %s, %v", iptablesChain, command, output, err)
+		return err
+	}
+	return nil
+}
+
+// deleteIptablesRule deletes every occurrence of the rule in the chain
+func deleteIptablesRule(command, chain string, rule ...string) {
+	for i := 0; i < maxDuplicatedRules && isIptablesRule(command, chain, rule...); i++ {
+		output, err := iptables(command, append([]string{"-D", chain}, rule...)...)
+		if err != nil {
+			glog.Warningf("Cannot delete %s rule %s in %s: %s, %v", command, strings.Join(rule, " "), chain, output, err)
+			return
+		}
+	}
+}
+
+// cleanIptables removes the pupernetes chains and the rules appended in FORWARD by the previous versions
+func (e *Environment) cleanIptables() error {
+	// the IP families of a previous run can differ from the current ones
+	for _, command := range iptablesCommands {
+		err := e.cleanIptablesChain(command)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Environment) cleanIptablesChain(command string) error {
+	for _, rule := range e.iptablesRules(command) {
+		deleteIptablesRule(command, "FORWARD", rule...)
+	}
+	deleteIptablesRule(command, "FORWARD", "-j", iptablesChain)
+	_, err := iptables(command, "-n", "-L", iptablesChain)
+	if err != nil {
+		glog.V(4).Infof("No %s chain %s", command, iptablesChain)
+		return nil
+	}
+	output, err := iptables(command, "-F", iptablesChain)
+	if err != nil {
+		glog.Errorf("Cannot flush %s chain %s: %s, %v", command, iptablesChain, output, err)
+		return err
+	}
+	output, err = iptables(command, "-X", iptablesChain)
+	if err != nil {
+		glog.Errorf("Cannot delete %s chain %s: %s, %v", command, iptablesChain, output, err)
+		return err
+	}
+	glog.Infof("Removed %s chain %s", command, iptablesChain)
+	return nil
+}
@@ -0,0 +1,283 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/DataDog/pupernetes/pkg/config"
+	"github.com/DataDog/pupernetes/pkg/setup/requirements"
+)
+
+const (
+	procSys     = "/proc/sys"
+	procModules = "/proc/modules"
+
+	preflightFileName = "preflight.json"
+
+	preflightKindModule = "module"
+	preflightKindSysctl = "sysctl"
+
+	moduleLoaded   = "loaded"
+	moduleUnloaded = "unloaded"
+)
+
+// kernelSysctl is a sysctl value required by the pods
+type kernelSysctl struct {
+	name  string
+	value string
+}
+
+// preflightChange is a kernel setting changed during the setup, restored by the clean
+type preflightChange struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Original string `json:"original"`
+	Applied  string `json:"applied"`
+}
+
+// preflightReport is persisted in the state directory with the original values
+type preflightReport struct {
+	Changes []preflightChange `json:"changes"`
+}
+
+// kernelModules returns the modules required by the network of the pods and kube-proxy
+func (e *Environment) kernelModules() []string {
+	modules := []string{"br_netfilter"}
+	if e.isDualStack() {
+		// kube-proxy runs in ipvs mode
+		modules = append(modules, "ip_vs", "ip_vs_rr", "ip_vs_wrr", "ip_vs_sh", "nf_conntrack")
+	}
+	return modules
+}
+
+// kernelSysctls returns the sysctls required by the network of the pods, the bridge ones need br_netfilter
+func (e *Environment) kernelSysctls() []kernelSysctl {
+	sysctls := []kernelSysctl{
+		{"net.ipv4.ip_forward", "1"},
+		{"net.bridge.bridge-nf-call-iptables", "1"},
+	}
+	for _, cidr := range e.podCIDRs {
+		if isIPv6CIDR(cidr) {
+			sysctls = append(sysctls,
+				kernelSysctl{"net.ipv6.conf.all.forwarding", "1"},
+				kernelSysctl{"net.bridge.bridge-nf-call-ip6tables", "1"},
+			)
+		}
+	}
+	return sysctls
+}
+
+func sysctlPath(procSysPath, name string) string {
+	return path.Join(procSysPath, strings.Replace(name, ".", "/", -1))
+}
+
+func readSysctl(procSysPath, name string) (string, error) {
+	b, err := ioutil.ReadFile(sysctlPath(procSysPath, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeSysctl(procSysPath, name, value string) error {
+	return ioutil.WriteFile(sysctlPath(procSysPath, name), []byte(value+"\n"), 0644)
+}
+
+// parseLoadedModules returns the names of the modules in the /proc/modules content
+func parseLoadedModules(b []byte) map[string]bool {
+	modules := make(map[string]bool)
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for scan.Scan() {
+		fields := strings.Fields(scan.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		modules[fields[0]] = true
+	}
+	return modules
+}
+
+func getLoadedModules() (map[string]bool, error) {
+	b, err := ioutil.ReadFile(procModules)
+	if err != nil {
+		glog.Errorf("Cannot read %s: %v", procModules, err)
+		return nil, err
+	}
+	return parseLoadedModules(b), nil
+}
+
+// isBuiltinModule returns true if the module is in /sys/module without being in /proc/modules
+func isBuiltinModule(name string) bool {
+	_, err := os.Stat(path.Join("/sys/module", name))
+	return err == nil
+}
+
+func (e *Environment) getPreflightReportPath() string {
+	return path.Join(e.rootABSPath, preflightFileName)
+}
+
+// readPreflightReport returns the changes of the previous setups, their original values are kept
+func (e *Environment) readPreflightReport() (*preflightReport, error) {
+	report := &preflightReport{}
+	b, err := ioutil.ReadFile(e.getPreflightReportPath())
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		glog.Errorf("Cannot read the preflight report %s: %v", e.getPreflightReportPath(), err)
+		return nil, err
+	}
+	err = json.Unmarshal(b, report)
+	if err != nil {
+		glog.Errorf("Cannot parse the preflight report %s: %v", e.getPreflightReportPath(), err)
+		return nil, err
+	}
+	return report, nil
+}
+
+func (e *Environment) writePreflightReport(report *preflightReport) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		glog.Errorf("Cannot marshal the preflight report: %v", err)
+		return err
+	}
+	err = ioutil.WriteFile(e.getPreflightReportPath(), b, 0644)
+	if err != nil {
+		glog.Errorf("Cannot write the preflight report %s: %v", e.getPreflightReportPath(), err)
+		return err
+	}
+	return nil
+}
+
+// record adds the change unless the setting was already changed by a previous setup
+func (r *preflightReport) record(change preflightChange) {
+	for i := range r.Changes {
+		if r.Changes[i].Kind == change.Kind && r.Changes[i].Name == change.Name {
+			r.Changes[i].Applied = change.Applied
+			return
+		}
+	}
+	r.Changes = append(r.Changes, change)
+}
+
+// setupKernel checks the kernel modules and the sysctls of the pods, with --kernel-preflight=apply
+// the missing ones are applied and their original values recorded in the state directory
+func (e *Environment) setupKernel() error {
+	if e.kernelPreflight == config.KernelPreflightNone {
+		glog.V(4).Infof("Kernel preflight skipped")
+		return nil
+	}
+	apply := e.kernelPreflight == config.KernelPreflightApply
+	if apply {
+		err := requirements.CheckModprobe()
+		if err != nil {
+			return err
+		}
+	}
+	report, err := e.readPreflightReport()
+	if err != nil {
+		return err
+	}
+	var failures []string
+
+	loaded, err := getLoadedModules()
+	if err != nil {
+		return err
+	}
+	for _, module := range e.kernelModules() {
+		if loaded[module] || isBuiltinModule(module) {
+			continue
+		}
+		if !apply {
+			failures = append(failures, fmt.Sprintf("module %s isn't loaded", module))
+			continue
+		}
+		b, err := exec.Command("modprobe", module).CombinedOutput()
+		if err != nil {
+			glog.Errorf("Cannot load the module %s: %s, %v", module, string(b), err)
+			return err
+		}
+		glog.Infof("Preflight: loaded the module %s", module)
+		report.record(preflightChange{Kind: preflightKindModule, Name: module, Original: moduleUnloaded, Applied: moduleLoaded})
+	}
+
+	for _, sysctl := range e.kernelSysctls() {
+		value, err := readSysctl(procSys, sysctl.name)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("sysctl %s is missing: %v", sysctl.name, err))
+			continue
+		}
+		if value == sysctl.value {
+			continue
+		}
+		if !apply {
+			failures = append(failures, fmt.Sprintf("sysctl %s is %s instead of %s", sysctl.name, value, sysctl.value))
+			continue
+		}
+		err = writeSysctl(procSys, sysctl.name, sysctl.value)
+		if err != nil {
+			glog.Errorf("Cannot set the sysctl %s to %s: %v", sysctl.name, sysctl.value, err)
+			return err
+		}
+		glog.Infof("Preflight: changed the sysctl %s from %s to %s", sysctl.name, value, sysctl.value)
+		report.record(preflightChange{Kind: preflightKindSysctl, Name: sysctl.name, Original: value, Applied: sysctl.value})
+	}
+
+	if len(failures) > 0 {
+		err = fmt.Errorf("kernel preflight failed: %s, use --kernel-preflight=%s", strings.Join(failures, ", "), config.KernelPreflightApply)
+		glog.Errorf("%v", err)
+		return err
+	}
+	if len(report.Changes) == 0 {
+		glog.V(3).Infof("Kernel preflight: nothing to change")
+		return nil
+	}
+	return e.writePreflightReport(report)
+}
+
+// cleanKernel restores the kernel settings changed during the setup, in the reverse order
+func (e *Environment) cleanKernel() error {
+	report, err := e.readPreflightReport()
+	if err != nil {
+		return err
+	}
+	for i := len(report.Changes) - 1; i >= 0; i-- {
+		change := report.Changes[i]
+		switch change.Kind {
+		case preflightKindSysctl:
+			err = writeSysctl(procSys, change.Name, change.Original)
+			if os.IsNotExist(err) {
+				glog.Warningf("Cannot restore the sysctl %s, its module isn't loaded anymore", change.Name)
+				continue
+			}
+			if err != nil {
+				glog.Errorf("Cannot restore the sysctl %s to %s: %v", change.Name, change.Original, err)
+				return err
+			}
+			glog.Infof("Restored the sysctl %s to %s", change.Name, change.Original)
+		case preflightKindModule:
+			b, err := exec.Command("modprobe", "-r", change.Name).CombinedOutput()
+			if err != nil {
+				// the module can be used by another program since the setup
+				glog.Warningf("Cannot unload the module %s: %s, %v", change.Name, string(b), err)
+				continue
+			}
+			glog.Infof("Unloaded the module %s", change.Name)
+		}
+	}
+	return remove(e.getPreflightReportPath())
+}
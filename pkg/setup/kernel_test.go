@@ -0,0 +1,68 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoadedModules(t *testing.T) {
+	modules := parseLoadedModules([]byte(`br_netfilter 24576 0 - Live 0x0000000000000000
+bridge 155648 1 br_netfilter, Live 0x0000000000000000
+overlay 106496 0 - Live 0x0000000000000000
+`))
+	assert.True(t, modules["br_netfilter"])
+	assert.True(t, modules["overlay"])
+	assert.False(t, modules["ip_vs"])
+}
+
+func TestSysctl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-sysctl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, path.Join(dir, "net/bridge/bridge-nf-call-iptables"), sysctlPath(dir, "net.bridge.bridge-nf-call-iptables"))
+	require.NoError(t, os.MkdirAll(path.Join(dir, "net/ipv4"), 0755))
+	require.NoError(t, ioutil.WriteFile(sysctlPath(dir, "net.ipv4.ip_forward"), []byte("0\n"), 0644))
+	value, err := readSysctl(dir, "net.ipv4.ip_forward")
+	require.NoError(t, err)
+	assert.Equal(t, "0", value)
+
+	require.NoError(t, writeSysctl(dir, "net.ipv4.ip_forward", "1"))
+	value, err = readSysctl(dir, "net.ipv4.ip_forward")
+	require.NoError(t, err)
+	assert.Equal(t, "1", value)
+}
+
+func TestPreflightReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-preflight")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	e := &Environment{rootABSPath: dir}
+
+	report, err := e.readPreflightReport()
+	require.NoError(t, err)
+	assert.Empty(t, report.Changes)
+
+	report.record(preflightChange{Kind: preflightKindModule, Name: "br_netfilter", Original: moduleUnloaded, Applied: moduleLoaded})
+	report.record(preflightChange{Kind: preflightKindSysctl, Name: "net.ipv4.ip_forward", Original: "0", Applied: "1"})
+	require.NoError(t, e.writePreflightReport(report))
+
+	// the original value of a previous setup is kept
+	report, err = e.readPreflightReport()
+	require.NoError(t, err)
+	report.record(preflightChange{Kind: preflightKindSysctl, Name: "net.ipv4.ip_forward", Original: "1", Applied: "1"})
+	assert.Equal(t, []preflightChange{
+		{Kind: preflightKindModule, Name: "br_netfilter", Original: moduleUnloaded, Applied: moduleLoaded},
+		{Kind: preflightKindSysctl, Name: "net.ipv4.ip_forward", Original: "0", Applied: "1"},
+	}, report.Changes)
+}
@@ -19,6 +19,8 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+
+	"github.com/DataDog/pupernetes/pkg/pki"
 )
 
 func getHome() string {
@@ -40,7 +42,7 @@ func (e *Environment) setupKubectl() error {
 		"set-cluster",
 		defaultKubectlClusterName,
 		"--server=https://127.0.0.1:6443",
-		"--certificate-authority="+path.Join(e.secretsABSPath, "kubernetes.issuing_ca"),
+		"--certificate-authority="+pki.FilePath(e.secretsABSPath, rootCertificateAuthorityName, pki.IssuingCASuffix),
 	).CombinedOutput()
 	output := string(b)
 	if err != nil {
@@ -57,8 +59,8 @@ func (e *Environment) setupKubectl() error {
 		"set-credentials",
 		defaultKubectlUserName,
 		"--username="+defaultKubectlUserName,
-		"--client-certificate="+path.Join(e.secretsABSPath, "kubernetes.certificate"),
-		"--client-key="+path.Join(e.secretsABSPath, "kubernetes.private_key"),
+		"--client-certificate="+pki.FilePath(e.secretsABSPath, certificateAdmin, pki.CertificateSuffix),
+		"--client-key="+pki.FilePath(e.secretsABSPath, certificateAdmin, pki.PrivateKeySuffix),
 	).CombinedOutput()
 	output = string(b)
 	if err != nil {
@@ -152,13 +154,13 @@ func (e *Environment) createKubectlLink() error {
 
 func (e *Environment) setupKubeletClient() error {
 	glog.V(4).Infof("Building kubelet client ...")
-	cert, err := tls.LoadX509KeyPair(path.Join(e.secretsABSPath, "kubernetes.certificate"), path.Join(e.secretsABSPath, "kubernetes.private_key"))
+	cert, err := tls.LoadX509KeyPair(pki.FilePath(e.secretsABSPath, certificateAdmin, pki.CertificateSuffix), pki.FilePath(e.secretsABSPath, certificateAdmin, pki.PrivateKeySuffix))
 	if err != nil {
 		glog.Errorf("Cannot load x509 key pair: %v", err)
 		return err
 	}
 
-	caCertBytes, err := ioutil.ReadFile(path.Join(e.secretsABSPath, "kubernetes.issuing_ca"))
+	caCertBytes, err := ioutil.ReadFile(pki.FilePath(e.secretsABSPath, rootCertificateAuthorityName, pki.IssuingCASuffix))
 	if err != nil {
 		glog.Errorf("Cannot read CA: %v", err)
 		return err
@@ -0,0 +1,177 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/golang/glog"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// namedNetnsDir contains the bind mounts of the named network namespaces, like the ones of the CNI
+const namedNetnsDir = "/var/run/netns"
+
+// isAddressInCIDRs returns true if one of the addresses is in one of the cidrs
+func isAddressInCIDRs(addrs []netlink.Addr, cidrs []*net.IPNet) bool {
+	for _, addr := range addrs {
+		if addr.IPNet == nil {
+			continue
+		}
+		for _, cidr := range cidrs {
+			if cidr.Contains(addr.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPodLink returns true if the link is an interface of the CNI bridge
+// or a veth holding an address of the pods, like the ptp ones
+func isPodLink(link netlink.Link, bridgeIndex int, addrs []netlink.Addr, podCIDRs []*net.IPNet) bool {
+	attrs := link.Attrs()
+	if bridgeIndex != 0 && attrs.MasterIndex == bridgeIndex {
+		return true
+	}
+	return link.Type() == "veth" && isAddressInCIDRs(addrs, podCIDRs)
+}
+
+// setupBridge creates the bridge of the pods if missing and sets it up
+func setupBridge() error {
+	link, err := netlink.LinkByName(defaultBridgeName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			glog.Errorf("Cannot get the bridge %s: %v", defaultBridgeName, err)
+			return err
+		}
+		bridge := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: defaultBridgeName}}
+		err = netlink.LinkAdd(bridge)
+		if err != nil {
+			glog.Errorf("Cannot create the bridge %s: %v", defaultBridgeName, err)
+			return err
+		}
+		glog.V(3).Infof("Created the bridge %s", defaultBridgeName)
+		link = bridge
+	}
+	err = netlink.LinkSetUp(link)
+	if err != nil {
+		glog.Errorf("Cannot set up the bridge %s: %v", defaultBridgeName, err)
+		return err
+	}
+	return nil
+}
+
+// isPodNetns returns true if the network namespace holds an address of the pods
+func isPodNetns(nsPath string, podCIDRs []*net.IPNet) (bool, error) {
+	ns, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		return false, err
+	}
+	defer ns.Close()
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return false, err
+	}
+	defer h.Delete()
+	addrs, err := h.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return false, err
+	}
+	return isAddressInCIDRs(addrs, podCIDRs), nil
+}
+
+// cleanNamedNetns removes the named network namespaces of the pods,
+// the unnamed ones are released with the processes of the pods
+func (e *Environment) cleanNamedNetns() error {
+	files, err := ioutil.ReadDir(namedNetnsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		glog.Errorf("Cannot list the network namespaces in %s: %v", namedNetnsDir, err)
+		return err
+	}
+	for _, f := range files {
+		nsPath := path.Join(namedNetnsDir, f.Name())
+		isPod, err := isPodNetns(nsPath, e.podCIDRs)
+		if err != nil {
+			glog.V(4).Infof("Cannot inspect the network namespace %s: %v", nsPath, err)
+			continue
+		}
+		if !isPod {
+			continue
+		}
+		err = syscall.Unmount(nsPath, syscall.MNT_DETACH)
+		if err != nil {
+			glog.Errorf("Cannot unmount the network namespace %s: %v", nsPath, err)
+			return err
+		}
+		err = os.Remove(nsPath)
+		if err != nil {
+			glog.Errorf("Cannot remove the network namespace %s: %v", nsPath, err)
+			return err
+		}
+		glog.Infof("Removed network namespace %s", nsPath)
+	}
+	return nil
+}
+
+// cleanNetworkLinks removes the pod network namespaces, their veths and the CNI bridge
+func (e *Environment) cleanNetworkLinks() error {
+	err := e.cleanNamedNetns()
+	if err != nil {
+		return err
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		glog.Errorf("Cannot list the network links: %v", err)
+		return err
+	}
+	var bridge netlink.Link
+	for _, link := range links {
+		if link.Attrs().Name == defaultBridgeName {
+			bridge = link
+			break
+		}
+	}
+	bridgeIndex := 0
+	if bridge != nil {
+		bridgeIndex = bridge.Attrs().Index
+	}
+	for _, link := range links {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			glog.Warningf("Cannot list the addresses of %s: %v", link.Attrs().Name, err)
+		}
+		if !isPodLink(link, bridgeIndex, addrs, e.podCIDRs) {
+			continue
+		}
+		// the peer in the pod is removed with it
+		err = netlink.LinkDel(link)
+		if err != nil {
+			glog.Errorf("Cannot delete the interface %s: %v", link.Attrs().Name, err)
+			return err
+		}
+		glog.V(4).Infof("Removed interface %s", link.Attrs().Name)
+	}
+	if bridge == nil {
+		glog.V(4).Infof("No bridge %s", defaultBridgeName)
+		return nil
+	}
+	err = netlink.LinkDel(bridge)
+	if err != nil {
+		glog.Errorf("Cannot delete the bridge %s: %v", defaultBridgeName, err)
+		return err
+	}
+	glog.Infof("Removed bridge %s", defaultBridgeName)
+	return nil
+}
@@ -0,0 +1,50 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func newTestAddrs(t *testing.T, cidrs ...string) []netlink.Addr {
+	var addrs []netlink.Addr
+	for _, cidr := range cidrs {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ipNet.IP = ip
+		addrs = append(addrs, netlink.Addr{IPNet: ipNet})
+	}
+	return addrs
+}
+
+func TestIsAddressInCIDRs(t *testing.T) {
+	podCIDRs, err := parseCIDRs("192.168.253.0/24,fd00:253::/64")
+	require.NoError(t, err)
+	assert.True(t, isAddressInCIDRs(newTestAddrs(t, "127.0.0.1/8", "192.168.253.5/24"), podCIDRs))
+	assert.True(t, isAddressInCIDRs(newTestAddrs(t, "fd00:253::5/64"), podCIDRs))
+	assert.False(t, isAddressInCIDRs(newTestAddrs(t, "127.0.0.1/8", "10.0.0.5/24"), podCIDRs))
+	assert.False(t, isAddressInCIDRs([]netlink.Addr{{}}, podCIDRs))
+}
+
+func TestIsPodLink(t *testing.T) {
+	podCIDRs, err := parseCIDRs("192.168.253.0/24")
+	require.NoError(t, err)
+	bridgeVeth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth6c7b2f1e", MasterIndex: 4}}
+	ptpVeth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth1a2b3c4d"}}
+	otherVeth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth9f8e7d6c"}}
+	eth0 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}
+
+	assert.True(t, isPodLink(bridgeVeth, 4, nil, podCIDRs))
+	assert.False(t, isPodLink(bridgeVeth, 0, nil, podCIDRs))
+	assert.True(t, isPodLink(ptpVeth, 0, newTestAddrs(t, "192.168.253.1/32"), podCIDRs))
+	assert.False(t, isPodLink(otherVeth, 4, newTestAddrs(t, "172.17.0.1/16"), podCIDRs))
+	assert.False(t, isPodLink(eth0, 4, newTestAddrs(t, "192.168.253.1/24"), podCIDRs))
+}
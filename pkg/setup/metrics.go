@@ -0,0 +1,94 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DataDog/pupernetes/pkg/pki"
+)
+
+const (
+	archiveLabel     = "archive"
+	certificateLabel = "certificate"
+)
+
+// archiveNames are the downloaded archives, used as metrics label
+var archiveNames = []string{"hyperkube", "vault", "etcd", "cni", "containerd", "runc"}
+
+var (
+	promDownloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pupernetes_download_bytes_total",
+		Help: "Total number of downloaded bytes per archive",
+	}, []string{archiveLabel})
+	promDownloadSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pupernetes_download_size_bytes",
+		Help: "Size of the archive being downloaded",
+	}, []string{archiveLabel})
+	promDownloadProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pupernetes_download_progress_ratio",
+		Help: "Progress of the archive download between 0 and 1",
+	}, []string{archiveLabel})
+	promDownloadDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pupernetes_download_duration_seconds",
+		Help: "Duration of the last successful archive download",
+	}, []string{archiveLabel})
+	promDownloadRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pupernetes_download_retries_total",
+		Help: "Total number of download retries per archive",
+	}, []string{archiveLabel})
+)
+
+// certificateExpiryCollector reports the seconds until the expiration of the certificates at collection time
+type certificateExpiryCollector struct {
+	mu       sync.RWMutex
+	notAfter map[string]time.Time
+	desc     *prometheus.Desc
+}
+
+var promCertificateExpiry = &certificateExpiryCollector{
+	notAfter: make(map[string]time.Time),
+	desc: prometheus.NewDesc(
+		"pupernetes_certificate_expiry_seconds",
+		"Seconds until the expiration of the certificate, negative when expired",
+		[]string{certificateLabel}, nil,
+	),
+}
+
+func (c *certificateExpiryCollector) set(infos []pki.Info) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notAfter = make(map[string]time.Time, len(infos))
+	for _, info := range infos {
+		c.notAfter[info.Name] = info.NotAfter
+	}
+}
+
+func (c *certificateExpiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *certificateExpiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for name, notAfter := range c.notAfter {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Until(notAfter).Seconds(), name)
+	}
+}
+
+func init() {
+	prometheus.MustRegister(promDownloadBytes, promDownloadSize, promDownloadProgress, promDownloadDuration, promDownloadRetries, promCertificateExpiry)
+	for _, name := range archiveNames {
+		promDownloadBytes.WithLabelValues(name)
+		promDownloadSize.WithLabelValues(name)
+		promDownloadProgress.WithLabelValues(name)
+		promDownloadDuration.WithLabelValues(name)
+		promDownloadRetries.WithLabelValues(name)
+	}
+}
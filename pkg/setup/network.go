@@ -17,7 +17,10 @@ import (
 	"path"
 	"strings"
 
+	"github.com/Masterminds/semver"
 	"github.com/golang/glog"
+
+	"github.com/DataDog/pupernetes/pkg/config"
 )
 
 const (
@@ -51,13 +54,118 @@ func pickInCIDR(cidr string, index int) (*net.IP, error) {
 	return &IP, nil
 }
 
-func (e *Environment) writeCNIConfig(c *cniConfig) error {
-	cniConfPath := path.Join(e.networkConfigABSPath, cniFileName)
+func isIPv6CIDR(cidr *net.IPNet) bool {
+	return cidr.IP.To4() == nil
+}
+
+// parseCIDRs parses the comma separated CIDRs, at most one per IP family, the first one is the primary family
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var cidrs []*net.IPNet
+	for _, elt := range strings.Split(s, ",") {
+		elt = strings.TrimSpace(elt)
+		if elt == "" {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(elt)
+		if err != nil {
+			return nil, err
+		}
+		for _, parsed := range cidrs {
+			if isIPv6CIDR(parsed) == isIPv6CIDR(cidr) {
+				return nil, fmt.Errorf("more than one CIDR of the same IP family in %q", s)
+			}
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("no CIDR in %q", s)
+	}
+	return cidrs, nil
+}
+
+func joinCIDRs(cidrs []*net.IPNet) string {
+	var elts []string
+	for _, cidr := range cidrs {
+		elts = append(elts, cidr.String())
+	}
+	return strings.Join(elts, ",")
+}
+
+func joinIPs(ips []net.IP) string {
+	var elts []string
+	for _, ip := range ips {
+		elts = append(elts, ip.String())
+	}
+	return strings.Join(elts, ",")
+}
+
+// isDualStack returns true if the pods and the services have an IPv4 and an IPv6 address
+func (e *Environment) isDualStack() bool {
+	return len(e.podCIDRs) > 1
+}
+
+// isIPv6 returns true if the primary IP family is IPv6
+func (e *Environment) isIPv6() bool {
+	return isIPv6CIDR(e.podCIDRs[0])
+}
+
+// validateIPFamilies checks the pod and the service CIDRs against each other and against the Kubernetes version
+func (e *Environment) validateIPFamilies() error {
+	if len(e.podCIDRs) != len(e.kubernetesClusterCIDRs) {
+		return fmt.Errorf("the pod IP range %s and the kubernetes cluster IP range %s must have the same IP families", joinCIDRs(e.podCIDRs), joinCIDRs(e.kubernetesClusterCIDRs))
+	}
+	for i := range e.podCIDRs {
+		if isIPv6CIDR(e.podCIDRs[i]) != isIPv6CIDR(e.kubernetesClusterCIDRs[i]) {
+			return fmt.Errorf("the pod IP range %s and the kubernetes cluster IP range %s must be given in the same IP family order", joinCIDRs(e.podCIDRs), joinCIDRs(e.kubernetesClusterCIDRs))
+		}
+	}
+	if e.isDualStack() {
+		c, err := semver.NewConstraint(">=1.16.0")
+		if err != nil {
+			return err
+		}
+		if !c.Check(e.kubeVersion) {
+			return fmt.Errorf("dual-stack requires Kubernetes 1.16 or later, got %s", e.kubeVersion.String())
+		}
+	}
+	if !e.isIPv6() && !e.isDualStack() {
+		return nil
+	}
+	c, err := semver.NewConstraint(">=1.10.0")
+	if err != nil {
+		return err
+	}
+	if !c.Check(e.kubeVersion) {
+		return fmt.Errorf("IPv6 requires Kubernetes 1.10 or later, got %s", e.kubeVersion.String())
+	}
+	// the node IP discovery only looks for IPv4 addresses
+	nodeIP := net.ParseIP(e.nodeIPOverride)
+	if e.isIPv6() && (nodeIP == nil || nodeIP.To4() != nil) {
+		return fmt.Errorf("IPv6 as primary IP family requires an IPv6 --node-ip")
+	}
+	return nil
+}
+
+// writeCNIConfig writes the CNI config in the file name and removes the other one,
+// the kubelet loads the first config of its directory
+func (e *Environment) writeCNIConfig(c interface{}, fileName string) error {
+	for _, name := range []string{cniFileName, cniConfListFileName} {
+		if name == fileName {
+			continue
+		}
+		err := os.Remove(path.Join(e.networkConfigABSPath, name))
+		if err != nil && !os.IsNotExist(err) {
+			glog.Errorf("Cannot remove the CNI config %s: %v", name, err)
+			return err
+		}
+	}
+	cniConfPath := path.Join(e.networkConfigABSPath, fileName)
 	f, err := os.OpenFile(cniConfPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0444)
 	if err != nil {
 		glog.Errorf("Cannot create %s: %v", cniConfPath, err)
 		return err
 	}
+	defer f.Close()
 	b, err := json.Marshal(c)
 	if err != nil {
 		glog.Errorf("Cannot marshal CNI conf: %v", err)
@@ -91,13 +199,18 @@ type cniConfig struct {
 }
 
 type ipam struct {
-	Type       string  `json:"type"`
-	Subnet     string  `json:"subnet"`
-	RangeStart string  `json:"rangeStart,omitempty"`
-	RangeEnd   string  `json:"rangeEnd,omitempty"`
-	Gateway    string  `json:"gateway,omitempty"`
-	Routes     []route `json:"routes,omitempty"`
-	DataDir    string  `json:"dataDir"`
+	Type    string      `json:"type"`
+	Ranges  [][]ipRange `json:"ranges"`
+	Routes  []route     `json:"routes,omitempty"`
+	DataDir string      `json:"dataDir"`
+}
+
+// ipRange is a range set of the host-local IPAM, one per IP family
+type ipRange struct {
+	Subnet     string `json:"subnet"`
+	RangeStart string `json:"rangeStart,omitempty"`
+	RangeEnd   string `json:"rangeEnd,omitempty"`
+	Gateway    string `json:"gateway,omitempty"`
 }
 
 type route struct {
@@ -105,66 +218,76 @@ type route struct {
 	Gateway     string `json:"gw,omitempty"`
 }
 
-func getOutboundIP() (*net.IP, error) {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		glog.Errorf("Cannot dial: %v", err)
-		return nil, err
-	}
-	defer conn.Close()
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return &localAddr.IP, nil
-}
-
 func (e *Environment) generateResolvConf() error {
-	_, err := os.Stat(e.GetResolvConfPath())
-	if err == nil {
-		glog.V(4).Infof("Already created: %s", e.GetResolvConfPath())
-		return nil
-	}
 	glog.V(3).Infof("Setting %s as first nameserver", e.dnsClusterIP.String())
 	nameservers := []string{e.dnsClusterIP.String()}
 
-	discoveredNameservers, err := e.getNameservers()
-	if err != nil {
-		glog.Errorf("Cannot get nameservers: %v", err)
-		return err
+	upstreamNameservers := e.upstreamNameservers
+	if len(upstreamNameservers) == 0 {
+		discoveredNameservers, err := e.getNameservers()
+		if err != nil {
+			glog.Errorf("Cannot get nameservers: %v", err)
+			return err
+		}
+		upstreamNameservers = e.withoutDNSClusterIPs(discoveredNameservers)
 	}
-	if len(discoveredNameservers) == 0 {
-		glog.V(2).Infof("No nameserver discovered, adding default 8.8.8.8, 8.8.4.4") // TODO remove this
-		discoveredNameservers = append(discoveredNameservers, "8.8.8.8", "8.8.4.4")
+	if len(upstreamNameservers) == 0 {
+		// the public resolvers are usually unreachable from the air-gapped or proxied hosts
+		err := fmt.Errorf("no upstream nameserver discovered on the host, the loopback ones and the DNS cluster IPs of --host-dns are skipped")
+		glog.Errorf("Cannot generate the resolv.conf of the pods: %v, set --upstream-nameservers", err)
+		return err
 	}
-	nameservers = append(nameservers, discoveredNameservers...)
+	nameservers = append(nameservers, upstreamNameservers...)
+	e.resolvedNameservers = strings.Join(upstreamNameservers, " ")
 
-	f, err := os.OpenFile(e.GetResolvConfPath(), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0444)
+	content := ""
+	for _, ns := range nameservers {
+		content += fmt.Sprintf("nameserver %s\n", ns)
+	}
+	b, err := ioutil.ReadFile(e.GetResolvConfPath())
+	if err == nil && string(b) == content {
+		glog.V(4).Infof("Already created: %s", e.GetResolvConfPath())
+		return nil
+	}
+	// the file is read only
+	_ = os.Remove(e.GetResolvConfPath())
+	err = ioutil.WriteFile(e.GetResolvConfPath(), []byte(content), 0444)
 	if err != nil {
+		glog.Errorf("Cannot write resolv.conf: %v", err)
 		return err
 	}
-	for _, ns := range nameservers {
-		_, err = f.WriteString(fmt.Sprintf("nameserver %s\n", ns))
-		if err != nil {
-			glog.Errorf("Cannot write resolv.conf: %v", err)
-			return err
-		}
-	}
 	glog.V(4).Infof("Created %s", e.GetResolvConfPath())
 	return nil
 }
 
+// newCNIIPAM returns the host-local IPAM with a range set and a default route per IP family
+func (e *Environment) newCNIIPAM() *ipam {
+	i := &ipam{
+		Type:    "host-local",
+		DataDir: e.networkStateABSPath,
+	}
+	for n, podCIDR := range e.podCIDRs {
+		gateway := e.podBridgeGatewayIPs[n].String()
+		i.Ranges = append(i.Ranges, []ipRange{{Subnet: podCIDR.String(), Gateway: gateway}})
+		destination := "0.0.0.0/0"
+		if isIPv6CIDR(podCIDR) {
+			destination = "::/0"
+		}
+		i.Routes = append(i.Routes, route{Destination: destination, Gateway: gateway})
+	}
+	return i
+}
+
 func (e *Environment) newCNIBridgeConfig(bridgeName string) *cniConfig {
 	return &cniConfig{
-		Name:             "p8s",
-		CniVersion:       "0.1.0",
+		Name: "p8s",
+		// the ranges of the host-local IPAM and the results with several IP addresses
+		CniVersion:       cniConfigVersion,
 		Type:             "bridge",
 		Bridge:           bridgeName,
 		IsDefaultGateway: true,
 		IPMasq:           true,
-		Ipam: &ipam{
-			Type:    "host-local",
-			Subnet:  e.podCIDR.String(),
-			DataDir: e.networkStateABSPath,
-			Routes:  []route{{Destination: "0.0.0.0/0", Gateway: e.podBridgeGatewayIP.String()}},
-		},
+		Ipam:             e.newCNIIPAM(),
 	}
 }
 
@@ -236,8 +359,28 @@ func (e *Environment) getNameservers() ([]string, error) {
 }
 
 func (e *Environment) generateCNIConf(bridgeName string) error {
-	c := e.newCNIBridgeConfig(bridgeName)
-	err := e.writeCNIConfig(c)
+	if e.cniConfListPath != "" {
+		return e.copyCNIConfList()
+	}
+	var c interface{}
+	fileName := cniFileName
+	pluginTypes := []string{"bridge", "host-local"}
+	switch e.cniPlugin {
+	case config.CNIPtp:
+		c = e.newCNIPtpConfig()
+		pluginTypes = []string{"ptp", "host-local"}
+	case config.CNIChained:
+		c = e.newCNIChainedConfig(bridgeName)
+		fileName = cniConfListFileName
+		pluginTypes = append(pluginTypes, "portmap", "bandwidth", "firewall")
+	default:
+		c = e.newCNIBridgeConfig(bridgeName)
+	}
+	err := e.checkCNIPlugins(pluginTypes...)
+	if err != nil {
+		return err
+	}
+	err = e.writeCNIConfig(c, fileName)
 	if err != nil {
 		glog.Errorf("Cannot write CNI config: %v", err)
 		return err
@@ -248,14 +391,18 @@ func (e *Environment) generateCNIConf(bridgeName string) error {
 func (e *Environment) setupNetwork() error {
 	var err error
 
-	e.outboundIP, err = getOutboundIP()
+	e.outboundIP, err = e.getNodeIP()
 	if err != nil {
-		glog.Errorf("Cannot get outboundIP: %v", err)
+		glog.Errorf("Cannot get the node IP: %v", err)
 		return err
 	}
 	e.nodeIP = e.outboundIP.String()
 	glog.V(4).Infof("Outbound IP is: %v", e.outboundIP.String())
 	glog.V(4).Infof("Node IP is: %v", e.nodeIP)
+	if e.proxy.isEnabled() {
+		e.noProxy = e.componentsNoProxy()
+		glog.V(4).Infof("No proxy of the components is: %s", e.noProxy)
+	}
 
 	err = e.generateResolvConf()
 	if err != nil {
@@ -267,18 +414,5 @@ func (e *Environment) setupNetwork() error {
 		return err
 	}
 
-	// docker set an iptables rule to drop by default
-	iptablesRules := [][]string{
-		{"iptables", "-A", "FORWARD", "--in-interface", defaultBridgeName, "-j", "ACCEPT"},
-		{"iptables", "-A", "FORWARD", "--out-interface", defaultBridgeName, "-j", "ACCEPT"},
-	}
-	for _, rule := range iptablesRules {
-		glog.V(4).Infof("Adding iptables rule: %s", strings.Join(rule, " "))
-		b, err := exec.Command(rule[0], rule[1:]...).CombinedOutput()
-		if err != nil {
-			glog.Errorf("Cannot run %s: %s", strings.Join(rule, " "), string(b))
-			return err
-		}
-	}
-	return nil
+	return e.setupIptables()
 }
@@ -6,9 +6,12 @@
 package setup
 
 import (
+	"net"
+	"testing"
+
+	"github.com/Masterminds/semver"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"testing"
 )
 
 func TestGetNameservers(t *testing.T) {
@@ -79,3 +82,86 @@ func TestEnvironment_GetKubernetesClusterIP(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "192.168.254.2", dnsIP.String())
 }
+
+func TestPickInCIDRIPv6(t *testing.T) {
+	kubeIP, err := pickInCIDR("fd00:254::/108", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "fd00:254::1", kubeIP.String())
+}
+
+func TestParseCIDRs(t *testing.T) {
+	cidrs, err := parseCIDRs("192.168.253.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.253.0/24", joinCIDRs(cidrs))
+
+	cidrs, err = parseCIDRs("fd00:253::/64, 192.168.253.0/24")
+	require.NoError(t, err)
+	require.Len(t, cidrs, 2)
+	assert.True(t, isIPv6CIDR(cidrs[0]))
+	assert.False(t, isIPv6CIDR(cidrs[1]))
+
+	for _, s := range []string{"", "192.168.253.0", "192.168.253.0/24,10.0.0.0/8", "fd00:253::/64,fd00:254::/64"} {
+		_, err = parseCIDRs(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestNewCNIBridgeConfig(t *testing.T) {
+	podCIDRs, err := parseCIDRs("192.168.253.0/24,fd00:253::/64")
+	require.NoError(t, err)
+	e := &Environment{
+		networkStateABSPath: "/opt/sandbox/net.d/state",
+		podCIDRs:            podCIDRs,
+		podBridgeGatewayIPs: []net.IP{net.ParseIP("192.168.253.1"), net.ParseIP("fd00:253::1")},
+	}
+	c := e.newCNIBridgeConfig(defaultBridgeName)
+	assert.Equal(t, "0.3.1", c.CniVersion)
+	assert.Equal(t, [][]ipRange{
+		{{Subnet: "192.168.253.0/24", Gateway: "192.168.253.1"}},
+		{{Subnet: "fd00:253::/64", Gateway: "fd00:253::1"}},
+	}, c.Ipam.Ranges)
+	assert.Equal(t, []route{
+		{Destination: "0.0.0.0/0", Gateway: "192.168.253.1"},
+		{Destination: "::/0", Gateway: "fd00:253::1"},
+	}, c.Ipam.Routes)
+}
+
+func TestValidateIPFamilies(t *testing.T) {
+	v4, err := parseCIDRs("192.168.254.0/24")
+	require.NoError(t, err)
+	v6, err := parseCIDRs("fd00:254::/108")
+	require.NoError(t, err)
+	dual, err := parseCIDRs("192.168.253.0/24,fd00:253::/64")
+	require.NoError(t, err)
+	dualServices, err := parseCIDRs("192.168.254.0/24,fd00:254::/108")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		version  string
+		services []*net.IPNet
+		pods     []*net.IPNet
+		nodeIP   string
+		valid    bool
+	}{
+		{"1.9.11", v4, v4, "", true},
+		{"1.16.3", dualServices, dual, "", true},
+		{"1.15.6", dualServices, dual, "", false},
+		{"1.16.3", v4, dual, "", false},
+		{"1.12.0", v6, v6, "fd00::10", true},
+		{"1.12.0", v6, v6, "", false},
+		{"1.9.11", v6, v6, "fd00::10", false},
+	} {
+		e := &Environment{
+			kubeVersion:            semver.MustParse(tc.version),
+			kubernetesClusterCIDRs: tc.services,
+			podCIDRs:               tc.pods,
+			nodeIPOverride:         tc.nodeIP,
+		}
+		err = e.validateIPFamilies()
+		if tc.valid {
+			assert.NoError(t, err, tc.version)
+			continue
+		}
+		assert.Error(t, err, tc.version)
+	}
+}
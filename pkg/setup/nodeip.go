@@ -0,0 +1,146 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	procNetRoute = "/proc/net/route"
+
+	// routeFlagUp is RTF_UP in the flags of /proc/net/route
+	routeFlagUp = 0x1
+)
+
+// parseDefaultRouteInterface returns the interface of the default route with the lowest metric in the /proc/net/route content
+func parseDefaultRouteInterface(b []byte) (string, error) {
+	iface := ""
+	lowestMetric := -1
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for scan.Scan() {
+		fields := strings.Fields(scan.Text())
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		if len(fields) < 8 || fields[0] == "Iface" {
+			continue
+		}
+		if fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&routeFlagUp == 0 {
+			continue
+		}
+		metric, err := strconv.Atoi(fields[6])
+		if err != nil {
+			continue
+		}
+		if lowestMetric == -1 || metric < lowestMetric {
+			iface, lowestMetric = fields[0], metric
+		}
+	}
+	if iface == "" {
+		return "", fmt.Errorf("no default route")
+	}
+	return iface, nil
+}
+
+// pickInterfaceIP returns the first global unicast IPv4 of the addresses
+func pickInterfaceIP(addrs []net.Addr) *net.IP {
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		if ip == nil || !ip.IsGlobalUnicast() {
+			continue
+		}
+		return &ip
+	}
+	return nil
+}
+
+func getInterfaceIP(name string) (*net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		glog.Errorf("Cannot get the interface %s: %v", name, err)
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		glog.Errorf("Cannot get the addresses of %s: %v", name, err)
+		return nil, err
+	}
+	ip := pickInterfaceIP(addrs)
+	if ip == nil {
+		err = fmt.Errorf("no global unicast IPv4 address on %s", name)
+		glog.Errorf("Cannot use the interface %s: %v", name, err)
+		return nil, err
+	}
+	return ip, nil
+}
+
+// getFirstInterfaceIP returns the address of the first interface up, for the hosts without default route
+func getFirstInterfaceIP() (*net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		glog.Errorf("Cannot list the interfaces: %v", err)
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Name == defaultBridgeName {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		ip := pickInterfaceIP(addrs)
+		if ip != nil {
+			glog.V(4).Infof("Using the interface %s", iface.Name)
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("no interface with a global unicast IPv4 address, use --node-ip")
+}
+
+// getNodeIP returns the --node-ip, the address of the --node-interface,
+// the address of the interface of the default route or the address of the first interface up
+func (e *Environment) getNodeIP() (*net.IP, error) {
+	if e.nodeIPOverride != "" {
+		ip := net.ParseIP(e.nodeIPOverride)
+		if ip == nil {
+			err := fmt.Errorf("invalid node IP %q", e.nodeIPOverride)
+			glog.Errorf("Cannot use the given node IP: %v", err)
+			return nil, err
+		}
+		return &ip, nil
+	}
+	if e.nodeInterface != "" {
+		return getInterfaceIP(e.nodeInterface)
+	}
+	b, err := ioutil.ReadFile(procNetRoute)
+	if err != nil {
+		glog.Errorf("Cannot read %s: %v", procNetRoute, err)
+		return nil, err
+	}
+	iface, err := parseDefaultRouteInterface(b)
+	if err != nil {
+		glog.Warningf("Cannot find the interface of the default route: %v, using the first interface up", err)
+		return getFirstInterfaceIP()
+	}
+	glog.V(4).Infof("Default route via %s", iface)
+	return getInterfaceIP(iface)
+}
@@ -0,0 +1,54 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDefaultRouteInterface(t *testing.T) {
+	iface, err := parseDefaultRouteInterface([]byte(`Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+docker0	000011AC	00000000	0001	0	0	0	0000FFFF	0	0	0
+wlp2s0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0
+ens33	00000000	0202A8C0	0003	0	0	100	00000000	0	0	0
+ens34	00000000	0303A8C0	0002	0	0	0	00000000	0	0	0
+ens33	0002A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "ens33", iface)
+
+	_, err = parseDefaultRouteInterface([]byte(`Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+ens33	0002A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+`))
+	assert.Error(t, err)
+}
+
+func TestPickInterfaceIP(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.1/8")
+	_, linkLocal, _ := net.ParseCIDR("fe80::1/64")
+	global := &net.IPNet{IP: net.ParseIP("192.168.2.10"), Mask: net.CIDRMask(24, 32)}
+
+	ip := pickInterfaceIP([]net.Addr{loopback, linkLocal, global})
+	require.NotNil(t, ip)
+	assert.Equal(t, "192.168.2.10", ip.String())
+
+	assert.Nil(t, pickInterfaceIP([]net.Addr{loopback, linkLocal}))
+}
+
+func TestGetNodeIPOverride(t *testing.T) {
+	e := &Environment{nodeIPOverride: "10.0.2.15"}
+	ip, err := e.getNodeIP()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.2.15", ip.String())
+
+	e.nodeIPOverride = "p8s"
+	_, err = e.getNodeIP()
+	assert.Error(t, err)
+}
@@ -0,0 +1,169 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyConfig is the outbound HTTP proxy of the downloads and the components
+type proxyConfig struct {
+	httpProxy  string
+	httpsProxy string
+	noProxy    []string
+}
+
+// getenvAny returns the first non empty environment variable
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		v := os.Getenv(name)
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseProxyURL validates the proxy, the scheme defaults to http
+func parseProxyURL(rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", nil
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid proxy %q: %v", rawURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return "", fmt.Errorf("unsupported scheme %q in the proxy %s, must be http, https or socks5", u.Scheme, rawURL)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("missing host in the proxy %q", rawURL)
+	}
+	return u.String(), nil
+}
+
+// newProxyConfig returns the given proxy settings, the empty ones default to the environment
+func newProxyConfig(httpProxy, httpsProxy string, noProxy []string) (*proxyConfig, error) {
+	var err error
+	p := &proxyConfig{}
+	p.httpProxy, err = parseProxyURL(firstNonEmpty(httpProxy, getenvAny("HTTP_PROXY", "http_proxy")))
+	if err != nil {
+		return nil, err
+	}
+	p.httpsProxy, err = parseProxyURL(firstNonEmpty(httpsProxy, getenvAny("HTTPS_PROXY", "https_proxy")))
+	if err != nil {
+		return nil, err
+	}
+	if len(noProxy) == 0 {
+		noProxy = strings.Split(getenvAny("NO_PROXY", "no_proxy"), ",")
+	}
+	for _, elt := range noProxy {
+		elt = strings.TrimSpace(elt)
+		if elt != "" {
+			p.noProxy = append(p.noProxy, elt)
+		}
+	}
+	return p, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (p *proxyConfig) isEnabled() bool {
+	return p.httpProxy != "" || p.httpsProxy != ""
+}
+
+// isNoProxy returns true if the host matches an entry of the no proxy list:
+// *, an IP address, a CIDR or a domain matching its subdomains
+func isNoProxy(host string, noProxy []string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, elt := range noProxy {
+		elt = strings.ToLower(elt)
+		if elt == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(elt); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(elt); err == nil {
+			elt = h
+		}
+		if eltIP := net.ParseIP(elt); eltIP != nil {
+			if eltIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(strings.TrimPrefix(elt, "*"), ".")
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyFunc is the Proxy of the http.Transport of the downloads
+func (p *proxyConfig) proxyFunc(req *http.Request) (*url.URL, error) {
+	proxy := p.httpProxy
+	if req.URL.Scheme == "https" {
+		proxy = p.httpsProxy
+	}
+	if proxy == "" || isNoProxy(req.URL.Host, p.noProxy) {
+		return nil, nil
+	}
+	return url.Parse(proxy)
+}
+
+// componentsNoProxy returns the no proxy list of the components,
+// the cluster traffic doesn't go through the proxy
+func (e *Environment) componentsNoProxy() string {
+	entries := append([]string{}, e.proxy.noProxy...)
+	entries = append(entries, "localhost", "127.0.0.1")
+	for _, cidr := range append(append([]*net.IPNet{}, e.kubernetesClusterCIDRs...), e.podCIDRs...) {
+		entries = append(entries, cidr.String())
+	}
+	entries = append(entries, e.nodeIP, e.hostname, e.clusterDomain)
+
+	var noProxy []string
+	seen := make(map[string]bool)
+	for _, elt := range entries {
+		if elt == "" || seen[elt] {
+			continue
+		}
+		seen[elt] = true
+		noProxy = append(noProxy, elt)
+	}
+	return strings.Join(noProxy, ",")
+}
@@ -0,0 +1,123 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	defaultTemplates "github.com/DataDog/pupernetes/pkg/setup/templates"
+)
+
+func TestIsNoProxy(t *testing.T) {
+	noProxy := []string{"10.0.0.0/8", "192.168.1.10", "internal.example", ".corp.example", "fd00::1"}
+	for _, host := range []string{
+		"localhost:8080",
+		"127.0.0.1",
+		"10.1.2.3:443",
+		"192.168.1.10",
+		"internal.example",
+		"dl.internal.example:443",
+		"dl.corp.example",
+		"[fd00::1]:443",
+	} {
+		assert.True(t, isNoProxy(host, noProxy), host)
+	}
+	for _, host := range []string{"dl.k8s.io", "192.168.1.11", "notinternal.example", "[fd00::2]:443"} {
+		assert.False(t, isNoProxy(host, noProxy), host)
+	}
+	assert.True(t, isNoProxy("dl.k8s.io", []string{"*"}))
+}
+
+func TestNewProxyConfig(t *testing.T) {
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	p, err := newProxyConfig("", "", nil)
+	require.NoError(t, err)
+	assert.False(t, p.isEnabled())
+
+	os.Setenv("https_proxy", "proxy.example:3128")
+	os.Setenv("NO_PROXY", "internal.example, 10.0.0.0/8")
+	p, err = newProxyConfig("http://proxy.example:8080", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, &proxyConfig{
+		httpProxy:  "http://proxy.example:8080",
+		httpsProxy: "http://proxy.example:3128",
+		noProxy:    []string{"internal.example", "10.0.0.0/8"},
+	}, p)
+
+	req, err := http.NewRequest(http.MethodGet, "https://dl.k8s.io/v1.18.0/kubernetes-server-linux-amd64.tar.gz", nil)
+	require.NoError(t, err)
+	u, err := p.proxyFunc(req)
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.example:3128", u.String())
+
+	req, err = http.NewRequest(http.MethodGet, "http://mirror.internal.example/kubernetes-server-linux-amd64.tar.gz", nil)
+	require.NoError(t, err)
+	u, err = p.proxyFunc(req)
+	require.NoError(t, err)
+	assert.Nil(t, u)
+
+	_, err = newProxyConfig("ftp://proxy.example", "", nil)
+	assert.Error(t, err)
+	_, err = newProxyConfig("", "http://", nil)
+	assert.Error(t, err)
+}
+
+func TestComponentsNoProxy(t *testing.T) {
+	services, err := parseCIDRs("192.168.254.0/24")
+	require.NoError(t, err)
+	pods, err := parseCIDRs("192.168.253.0/24")
+	require.NoError(t, err)
+	e := &Environment{
+		proxy:                  &proxyConfig{httpsProxy: "http://proxy.example:3128", noProxy: []string{"internal.example", "localhost"}},
+		kubernetesClusterCIDRs: services,
+		podCIDRs:               pods,
+		nodeIP:                 "10.0.0.12",
+		hostname:               "p8s",
+		clusterDomain:          "cluster.local",
+	}
+	assert.Equal(t, "internal.example,localhost,127.0.0.1,192.168.254.0/24,192.168.253.0/24,10.0.0.12,p8s,cluster.local", e.componentsNoProxy())
+}
+
+func TestRenderProxyEnvironment(t *testing.T) {
+	noProxy := "127.0.0.1,cluster.local"
+	metadata := &templateMetadata{
+		Hostname:    new(string),
+		RootABSPath: new(string),
+		NodeIP:      new(string),
+		HTTPSProxy:  "http://proxy.example:3128",
+		NoProxy:     &noProxy,
+	}
+
+	for version, manifests := range defaultTemplates.Manifests {
+		for _, manifest := range manifests {
+			if manifest.Name != "kubelet.service" && manifest.Name != "containerd.service" {
+				continue
+			}
+			tmpl, err := template.New(manifest.Name).Parse(string(manifest.Content))
+			require.NoError(t, err, version)
+
+			b := &bytes.Buffer{}
+			require.NoError(t, tmpl.Execute(b, metadata), version)
+			assert.Contains(t, b.String(), "[Service]\nEnvironment=HTTPS_PROXY=http://proxy.example:3128\nEnvironment=NO_PROXY=127.0.0.1,cluster.local\n", version)
+			assert.NotContains(t, b.String(), "HTTP_PROXY", version)
+
+			b.Reset()
+			require.NoError(t, tmpl.Execute(b, &templateMetadata{Hostname: new(string), RootABSPath: new(string), NodeIP: new(string), NoProxy: new(string)}), version)
+			assert.NotContains(t, b.String(), "PROXY", version)
+		}
+	}
+}
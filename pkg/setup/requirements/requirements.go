@@ -45,6 +45,12 @@ func checkResources() error {
 	return err
 }
 
+// CheckModprobe returns an error if modprobe isn't available, it loads the missing kernel modules
+// with --kernel-preflight=apply
+func CheckModprobe() error {
+	return checkCommand("modprobe", "--version")
+}
+
 // CheckRequirements returns an error if the hard coded requirements are not satisfied
 // TODO configure this
 func CheckRequirements() error {
@@ -57,14 +63,6 @@ func CheckRequirements() error {
 	if err != nil {
 		return err
 	}
-	err = checkCommand("tar", "--version")
-	if err != nil {
-		return err
-	}
-	err = checkCommand("unzip")
-	if err != nil {
-		return err
-	}
 	err = checkCommand("systemctl", "--version")
 	if err != nil {
 		return err
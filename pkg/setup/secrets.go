@@ -16,19 +16,22 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/golang/glog"
 	vault "github.com/hashicorp/vault/api"
+
+	"github.com/DataDog/pupernetes/pkg/config"
+	"github.com/DataDog/pupernetes/pkg/pki"
 )
 
 const (
 	rootCertificateAuthorityName = "pupernetes"
+	rootCertificateCommonName    = "p8s"
 )
 
-var pemParts = []string{"certificate", "issuing_ca", "private_key"}
-
 func tearDownCommand(cmd *exec.Cmd, originalErr error) error {
 	glog.V(4).Infof("Stopping vault")
 	cmd.Process.Signal(syscall.SIGTERM)
@@ -88,13 +91,13 @@ func (e *Environment) generateVaultPKI() error {
 
 	/*
 		The ROOT CA is pupernetes.
-		The certificates for etcd and kubernetes are issued against the pupernetes ROOT CA.
+		The certificates of each component are issued against the pupernetes ROOT CA.
 	*/
 
 	// ROOT CA - pupernetes
 	glog.V(3).Infof("Mounting pupernetes root CA")
 	err = vRaw.Sys().Mount(rootCertificateAuthorityName, &vault.MountInput{
-		Config:     vault.MountConfigInput{MaxLeaseTTL: "87600h"},
+		Config:     vault.MountConfigInput{MaxLeaseTTL: e.caTTL.String()},
 		Type:       "pki",
 		PluginName: "pki",
 	})
@@ -102,76 +105,96 @@ func (e *Environment) generateVaultPKI() error {
 		glog.Errorf("Cannot mount pki: %v", err)
 		return err
 	}
-	glog.V(3).Infof("Creating %s root CA", rootCertificateAuthorityName)
-	rootCAConf := make(map[string]interface{})
-	rootCAConf["common_name"] = "p8s"
-	rootCAConf["ttl"] = "87600h"
-	sec, err := vClient.Write(rootCertificateAuthorityName+"/root/generate/exported", rootCAConf)
-	if err != nil {
-		glog.Errorf("Cannot write: %v", err)
-		return err
-	}
-
-	privateKey := []byte(sec.Data["private_key"].(string))
-	certificate := []byte(sec.Data["certificate"].(string))
-	issuingCA := []byte(sec.Data["issuing_ca"].(string))
-
-	err = ioutil.WriteFile(path.Join(e.secretsABSPath, rootCertificateAuthorityName+".private_key"), privateKey, 0400)
-	if err != nil {
-		glog.Errorf("Cannot write secret file: %v", err)
-		return err
-	}
-	err = ioutil.WriteFile(path.Join(e.secretsABSPath, rootCertificateAuthorityName+".certificate"), certificate, 0400)
-	if err != nil {
-		glog.Errorf("Cannot write secret file: %v", err)
-		return err
+	var ca *pki.CA
+	if e.caCertificatePath != "" {
+		ca, err = pki.LoadCAFiles(e.caCertificatePath, e.caPrivateKeyPath)
+		if err != nil {
+			return err
+		}
+		glog.V(3).Infof("Importing the root CA %s", e.caCertificatePath)
+		caConf := make(map[string]interface{})
+		caConf["pem_bundle"] = string(ca.PrivateKeyPEM) + string(ca.CertificatePEM)
+		_, err = vClient.Write(rootCertificateAuthorityName+"/config/ca", caConf)
+		if err != nil {
+			glog.Errorf("Cannot import the root CA: %v", err)
+			return err
+		}
+	} else {
+		glog.V(3).Infof("Creating %s root CA", rootCertificateAuthorityName)
+		rootCAConf := make(map[string]interface{})
+		rootCAConf["common_name"] = rootCertificateCommonName
+		rootCAConf["ttl"] = e.caTTL.String()
+		sec, err := vClient.Write(rootCertificateAuthorityName+"/root/generate/exported", rootCAConf)
+		if err != nil {
+			glog.Errorf("Cannot write: %v", err)
+			return err
+		}
+		ca, err = pki.ParseCA([]byte(sec.Data["certificate"].(string)), []byte(sec.Data["private_key"].(string)))
+		if err != nil {
+			return err
+		}
 	}
-	err = ioutil.WriteFile(path.Join(e.secretsABSPath, rootCertificateAuthorityName+".issuing_ca"), issuingCA, 0400)
+	err = ca.WriteFiles(e.secretsABSPath, rootCertificateAuthorityName, 0400)
 	if err != nil {
-		glog.Errorf("Cannot write secret file: %v", err)
 		return err
 	}
 
-	// Prepare the role / issue configuration
-	roleConf := make(map[string]interface{})
-	roleConf["allow_any_name"] = "true"
-	roleConf["max_ttl"] = "43800h"
-	issueConf := make(map[string]interface{})
-	issueConf["common_name"] = "p8s"
-	issueConf["alt_names"] = fmt.Sprintf("localhost,%s", e.hostname)
-	issueConf["ip_sans"] = fmt.Sprintf("127.0.0.1,%s,%s", e.outboundIP.String(), e.kubernetesClusterIP.String())
-
 	// Generate secrets - certificates for each component:
-	for _, component := range []string{"kubernetes", "etcd"} {
-		err = e.generateSecretFor(vRaw, vClient, roleConf, issueConf, component)
+	for _, spec := range e.certificateSpecs() {
+		err = e.generateSecretFor(vRaw, vClient, spec)
 		if err != nil {
-			glog.Errorf("Unexpected error during the secret generation of %s: %v", component, err)
+			glog.Errorf("Unexpected error during the secret generation of %s: %v", spec.name, err)
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *Environment) generateSecretFor(vRaw *vault.Client, vClient *vault.Logical, roleConf, issueConf map[string]interface{}, component string) error {
-	_, err := vClient.Write(fmt.Sprintf("%s/roles/%s", rootCertificateAuthorityName, component), roleConf)
+func (e *Environment) generateSecretFor(vRaw *vault.Client, vClient *vault.Logical, spec certificateSpec) error {
+	req := spec.request
+	serverFlag, clientFlag := false, false
+	for _, usage := range req.ExtKeyUsages {
+		serverFlag = serverFlag || usage == x509.ExtKeyUsageServerAuth
+		clientFlag = clientFlag || usage == x509.ExtKeyUsageClientAuth
+	}
+	roleConf := make(map[string]interface{})
+	roleConf["allow_any_name"] = "true"
+	// the common names like system:node:<hostname> aren't hostnames
+	roleConf["enforce_hostnames"] = "false"
+	roleConf["organization"] = strings.Join(req.Organization, ",")
+	roleConf["server_flag"] = serverFlag
+	roleConf["client_flag"] = clientFlag
+	roleConf["max_ttl"] = e.caTTL.String()
+	_, err := vClient.Write(fmt.Sprintf("%s/roles/%s", rootCertificateAuthorityName, spec.name), roleConf)
 	if err != nil {
 		glog.Errorf("Cannot write role: %v", err)
 		return err
 	}
-	err = vRaw.Sys().PutPolicy(fmt.Sprintf("%s/%s", rootCertificateAuthorityName, component), fmt.Sprintf(`path "%s/issue/%s" { policy = "write" }`, rootCertificateAuthorityName, component))
+	err = vRaw.Sys().PutPolicy(fmt.Sprintf("%s/%s", rootCertificateAuthorityName, spec.name), fmt.Sprintf(`path "%s/issue/%s" { policy = "write" }`, rootCertificateAuthorityName, spec.name))
 	if err != nil {
 		glog.Errorf("Cannot write policy: %v", err)
 		return err
 	}
-	sec, err := vClient.Write(fmt.Sprintf("%s/issue/%s", rootCertificateAuthorityName, component), issueConf)
+	var ipSANs []string
+	for _, ip := range req.IPAddresses {
+		ipSANs = append(ipSANs, ip.String())
+	}
+	issueConf := make(map[string]interface{})
+	issueConf["common_name"] = req.CommonName
+	issueConf["alt_names"] = strings.Join(req.DNSNames, ",")
+	issueConf["ip_sans"] = strings.Join(ipSANs, ",")
+	issueConf["ttl"] = req.TTL.String()
+	// the common name isn't a SAN of the client certificates
+	issueConf["exclude_cn_from_sans"] = "true"
+	sec, err := vClient.Write(fmt.Sprintf("%s/issue/%s", rootCertificateAuthorityName, spec.name), issueConf)
 	if err != nil {
-		glog.Errorf("Cannot generateSecretFor %s: %v", component, err)
+		glog.Errorf("Cannot generateSecretFor %s: %v", spec.name, err)
 		return err
 	}
-	for _, part := range pemParts {
+	for _, part := range pki.Suffixes {
 		content := []byte(sec.Data[part].(string))
-		certABSPath := path.Join(e.secretsABSPath, fmt.Sprintf("%s.%s", component, part))
-		err = ioutil.WriteFile(certABSPath, content, 0444)
+		certABSPath := pki.FilePath(e.secretsABSPath, spec.name, part)
+		err = ioutil.WriteFile(certABSPath, content, pki.FilePerm(part, 0444))
 		if err != nil {
 			return err
 		}
@@ -215,10 +238,23 @@ func (e *Environment) generateServiceAccountRSA() error {
 }
 
 func (e *Environment) isVaultSecrets() bool {
-	for _, part := range pemParts {
-		certFile := path.Join(e.secretsABSPath, "apiserver."+part)
-		_, err := os.Stat(certFile)
+	ca, err := pki.LoadCA(e.secretsABSPath, rootCertificateAuthorityName)
+	if err != nil {
+		glog.V(4).Infof("Cannot use the root CA in %s: %v", e.secretsABSPath, err)
+		return false
+	}
+	if !e.isSuppliedRootCA(ca) {
+		return false
+	}
+	for _, spec := range e.certificateSpecs() {
+		kp, err := pki.LoadKeyPair(e.secretsABSPath, spec.name)
+		if err != nil {
+			glog.V(4).Infof("Cannot use the certificate of %s: %v", spec.name, err)
+			return false
+		}
+		err = ca.Verify(kp, spec.request)
 		if err != nil {
+			glog.V(2).Infof("Renewing the vault secrets, the certificate of %s is outdated: %v", spec.name, err)
 			return false
 		}
 	}
@@ -261,9 +297,18 @@ func (e *Environment) setupSecrets() error {
 	if err != nil {
 		return err
 	}
-	err = e.generateVaultSecrets()
+	if e.pkiBackend == config.PKIVault {
+		err = e.generateVaultSecrets()
+	} else {
+		err = e.generateNativeSecrets()
+	}
 	if err != nil {
 		return err
 	}
-	return nil
+	err = e.generateComponentKubeconfigs()
+	if err != nil {
+		return err
+	}
+	_, err = e.ListCertificates()
+	return err
 }
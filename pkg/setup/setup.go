@@ -13,6 +13,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/Masterminds/semver"
@@ -23,6 +24,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
+	"github.com/DataDog/pupernetes/pkg/cache"
 	"github.com/DataDog/pupernetes/pkg/config"
 	"github.com/DataDog/pupernetes/pkg/options"
 	"github.com/DataDog/pupernetes/pkg/setup/requirements"
@@ -42,6 +44,9 @@ const (
 	defaultNetworkDirName         = "net.d"
 	defaultLogsDirName            = "logs"
 
+	// secretsDirPerm prevents the other users from reading the private keys
+	secretsDirPerm os.FileMode = 0700
+
 	defaultKubectlClusterName = "p8s"
 	defaultKubectlUserName    = "p8s"
 	defaultKubectlContextName = "p8s"
@@ -94,7 +99,10 @@ type Environment struct {
 	binaryRunc       *exeBinary
 
 	// dependencies
+	arch            string
 	downloadTimeout time.Duration
+	mirror          string
+	downloadCache   *cache.Cache
 	binaryCNI       *depBinary
 
 	templateMetadata *templateMetadata
@@ -113,15 +121,46 @@ type Environment struct {
 	kubeletClient  *http.Client
 	podListRequest *http.Request
 
+	// Hostname
+	hostnameOverride      string
+	hostnameProviderNames []string
+	awsMetadataEndpoint   string
+	gceMetadataEndpoint   string
+	azureMetadataEndpoint string
+
 	// Network
-	outboundIP            *net.IP
-	nodeIP                string
-	kubernetesClusterCIDR *net.IPNet
-	kubernetesClusterIP   *net.IP
-	podCIDR               *net.IPNet
-	podBridgeGatewayIP    *net.IP
-	dnsClusterIP          *net.IP
-	isDockerBridge        bool
+	outboundIP          *net.IP
+	nodeIP              string
+	nodeIPOverride      string
+	nodeInterface       string
+	upstreamNameservers []string
+	resolvedNameservers string
+	clusterDomain       string
+	dnsStubDomains      []dnsStubDomain
+	dnsHosts            []dnsHost
+	hostDNS             string
+	proxy               *proxyConfig
+	noProxy             string
+	// one CIDR per IP family, the first one is the primary family
+	kubernetesClusterCIDRs []*net.IPNet
+	kubernetesClusterIPs   []net.IP
+	podCIDRs               []*net.IPNet
+	podBridgeGatewayIPs    []net.IP
+	dnsClusterIPs          []net.IP
+	dnsClusterIP           *net.IP
+	cniPlugin              string
+	cniConfListPath        string
+	kernelPreflight        string
+	isDockerBridge         bool
+
+	// PKI
+	pkiBackend           string
+	caTTL                time.Duration
+	certificateTTL       time.Duration
+	caCertificatePath    string
+	caPrivateKeyPath     string
+	extraSANsDNSNames    []string
+	extraSANsIPAddresses []net.IP
 
 	// Vault token
 	vaultRootToken     string
@@ -135,17 +174,31 @@ type Environment struct {
 
 type templateMetadata struct {
 	// pointers are used when fields are initialized later
-	HyperkubeImageURL        string  `json:"hyperkube-image-url"`
-	Hostname                 *string `json:"hostname"`
-	RootABSPath              *string `json:"root"`
-	ServiceClusterIPRange    string  `json:"service-cluster-ip-range"`
-	KubernetesClusterIP      string  `json:"kubernetes-cluster-ip"`
-	DNSClusterIP             string  `json:"dns-cluster-ip"`
-	NodeIP                   *string `json:"node-ip"`
-	KubeletRootDirABSPath    string  `json:"kubelet-root-dir"`
-	CgroupDriver             string  `json:"cgroup-driver"`
-	ContainerRuntime         string  `json:"container-runtime"`
-	ContainerRuntimeEndpoint string  `json:"container-runtime-endpoint"`
+	HyperkubeImageURL        string          `json:"hyperkube-image-url"`
+	Hostname                 *string         `json:"hostname"`
+	RootABSPath              *string         `json:"root"`
+	ServiceClusterIPRange    string          `json:"service-cluster-ip-range"`
+	ServiceClusterIPRanges   string          `json:"service-cluster-ip-ranges"`
+	PodIPRanges              string          `json:"pod-ip-ranges"`
+	KubernetesClusterIP      string          `json:"kubernetes-cluster-ip"`
+	KubernetesClusterIPs     string          `json:"kubernetes-cluster-ips"`
+	DNSClusterIP             string          `json:"dns-cluster-ip"`
+	DNSClusterIPs            string          `json:"dns-cluster-ips"`
+	IPv6                     bool            `json:"ipv6"`
+	DualStack                bool            `json:"dual-stack"`
+	NodeIP                   *string         `json:"node-ip"`
+	UpstreamNameservers      *string         `json:"upstream-nameservers"`
+	ClusterDomain            string          `json:"cluster-domain"`
+	DNSStubDomains           []dnsStubDomain `json:"dns-stub-domains"`
+	DNSHosts                 []dnsHost       `json:"dns-hosts"`
+	HTTPProxy                string          `json:"http-proxy"`
+	HTTPSProxy               string          `json:"https-proxy"`
+	NoProxy                  *string         `json:"no-proxy"`
+	KubeletRootDirABSPath    string          `json:"kubelet-root-dir"`
+	CgroupDriver             string          `json:"cgroup-driver"`
+	ContainerRuntime         string          `json:"container-runtime"`
+	ContainerRuntimeEndpoint string          `json:"container-runtime-endpoint"`
+	Arch                     string          `json:"arch"`
 }
 
 // NewConfigSetup creates an Environment
@@ -199,7 +252,9 @@ func NewConfigSetup(givenRootPath string) (*Environment, error) {
 		drainOptions:           options.NewDrainOptions(config.ViperConfig.GetString("drain")),
 		kubectlLink:            config.ViperConfig.GetString("kubectl-link"),
 
+		arch:            config.ViperConfig.GetString("arch"),
 		downloadTimeout: config.ViperConfig.GetDuration("download-timeout"),
+		mirror:          config.ViperConfig.GetString("mirror"),
 
 		systemdUnitPrefix:         config.ViperConfig.GetString("systemd-unit-prefix"),
 		etcdUnitName:              config.ViperConfig.GetString("systemd-unit-prefix") + "etcd.service",
@@ -207,28 +262,110 @@ func NewConfigSetup(givenRootPath string) (*Environment, error) {
 		kubeAPIServerUnitName:     config.ViperConfig.GetString("systemd-unit-prefix") + "kube-apiserver.service",
 		containerRuntimeInterface: config.ViperConfig.GetString("container-runtime"),
 		vaultListenAddress:        config.ViperConfig.GetString("vault-listen-address"),
+		pkiBackend:                config.ViperConfig.GetString("pki-backend"),
+		caTTL:                     config.ViperConfig.GetDuration("ca-ttl"),
+		certificateTTL:            config.ViperConfig.GetDuration("certificate-ttl"),
+		caCertificatePath:         config.ViperConfig.GetString("ca-certificate"),
+		caPrivateKeyPath:          config.ViperConfig.GetString("ca-private-key"),
+		nodeIPOverride:            config.ViperConfig.GetString("node-ip"),
+		nodeInterface:             config.ViperConfig.GetString("node-interface"),
+		cniPlugin:                 config.ViperConfig.GetString("cni-plugin"),
+		cniConfListPath:           config.ViperConfig.GetString("cni-conflist"),
+		kernelPreflight:           config.ViperConfig.GetString("kernel-preflight"),
+		hostDNS:                   config.ViperConfig.GetString("host-dns"),
+		hostnameOverride:          config.ViperConfig.GetString("hostname-override"),
+		hostnameProviderNames:     config.ViperConfig.GetStringSlice("hostname-providers"),
+		awsMetadataEndpoint:       strings.TrimSuffix(config.ViperConfig.GetString("aws-metadata-endpoint"), "/"),
+		gceMetadataEndpoint:       strings.TrimSuffix(config.ViperConfig.GetString("gce-metadata-endpoint"), "/"),
+		azureMetadataEndpoint:     strings.TrimSuffix(config.ViperConfig.GetString("azure-metadata-endpoint"), "/"),
+	}
+	err = checkArch(e.arch)
+	if err != nil {
+		glog.Errorf("Invalid arch: %v", err)
+		return nil, err
+	}
+	if e.pkiBackend != config.PKINative && e.pkiBackend != config.PKIVault {
+		err = fmt.Errorf("unsupported pki backend %q, must be %s or %s", e.pkiBackend, config.PKINative, config.PKIVault)
+		glog.Errorf("Invalid pki backend: %v", err)
+		return nil, err
+	}
+	if e.cniPlugin != config.CNIBridge && e.cniPlugin != config.CNIPtp && e.cniPlugin != config.CNIChained {
+		err = fmt.Errorf("unsupported cni plugin %q, must be %s, %s or %s", e.cniPlugin, config.CNIBridge, config.CNIPtp, config.CNIChained)
+		glog.Errorf("Invalid cni plugin: %v", err)
+		return nil, err
+	}
+	if e.kernelPreflight != config.KernelPreflightApply && e.kernelPreflight != config.KernelPreflightCheck && e.kernelPreflight != config.KernelPreflightNone {
+		err = fmt.Errorf("unsupported kernel preflight %q, must be %s, %s or %s", e.kernelPreflight, config.KernelPreflightApply, config.KernelPreflightCheck, config.KernelPreflightNone)
+		glog.Errorf("Invalid kernel preflight: %v", err)
+		return nil, err
+	}
+	if e.hostDNS != config.HostDNSNone && e.hostDNS != config.HostDNSAuto && e.hostDNS != config.HostDNSResolved && e.hostDNS != config.HostDNSResolvConf {
+		err = fmt.Errorf("unsupported host dns %q, must be %s, %s, %s or %s", e.hostDNS, config.HostDNSAuto, config.HostDNSResolved, config.HostDNSResolvConf, config.HostDNSNone)
+		glog.Errorf("Invalid host dns: %v", err)
+		return nil, err
+	}
+	_, err = e.hostnameProviders()
+	if err != nil {
+		glog.Errorf("Invalid hostname providers: %v", err)
+		return nil, err
 	}
+	if e.cniConfListPath != "" {
+		e.cniConfListPath, err = filepath.Abs(e.cniConfListPath)
+		if err != nil {
+			glog.Errorf("Unexpected error during abspath: %v", err)
+			return nil, err
+		}
+	}
+	if (e.caCertificatePath == "") != (e.caPrivateKeyPath == "") {
+		err = fmt.Errorf("--ca-certificate and --ca-private-key must be given together")
+		glog.Errorf("Invalid root CA: %v", err)
+		return nil, err
+	}
+	e.extraSANsDNSNames, e.extraSANsIPAddresses = parseSANs(config.ViperConfig.GetStringSlice("extra-sans"))
+
+	e.proxy, err = newProxyConfig(
+		config.ViperConfig.GetString("http-proxy"),
+		config.ViperConfig.GetString("https-proxy"),
+		config.ViperConfig.GetStringSlice("no-proxy"),
+	)
+	if err != nil {
+		glog.Errorf("Cannot use the proxy: %v", err)
+		return nil, err
+	}
+
+	// Download cache
+	e.downloadCache, err = NewDownloadCache()
+	if err != nil {
+		return nil, err
+	}
+
 	// Kubernetes
 	e.binaryHyperkube = &exeBinary{
 		depBinary: depBinary{
 			archivePath:     path.Join(e.binABSPath, fmt.Sprintf("hyperkube-v%s.tar.gz", kubeVersion)),
 			binaryABSPath:   path.Join(e.binABSPath, "hyperkube"),
-			archiveURL:      fmt.Sprintf("https://dl.k8s.io/v%s/kubernetes-server-linux-amd64.tar.gz", kubeVersion),
 			version:         kubeVersion,
 			downloadTimeout: e.downloadTimeout,
+			checksum:        config.ViperConfig.GetString("hyperkube-checksum"),
 		},
 		skipVersionVerify: config.ViperConfig.GetBool("skip-binaries-version"),
 		commandVersion:    []string{"kubelet", "--version"},
 	}
 
+	vaultVersion := config.GetVersion("vault", e.arch)
+	etcdVersion := config.GetVersion("etcd", e.arch)
+	containerdVersion := config.GetVersion("containerd", e.arch)
+	runcVersion := config.GetVersion("runc", e.arch)
+	cniVersion := config.GetVersion("cni", e.arch)
+
 	// Vault
 	e.binaryVault = &exeBinary{
 		depBinary: depBinary{
-			archivePath:     path.Join(e.binABSPath, fmt.Sprintf("vault-v%s.zip", config.ViperConfig.GetString("vault-version"))),
+			archivePath:     path.Join(e.binABSPath, fmt.Sprintf("vault-v%s.zip", vaultVersion)),
 			binaryABSPath:   path.Join(e.binABSPath, "vault"),
-			archiveURL:      fmt.Sprintf("https://releases.hashicorp.com/vault/%s/vault_%s_linux_amd64.zip", config.ViperConfig.GetString("vault-version"), config.ViperConfig.GetString("vault-version")),
-			version:         config.ViperConfig.GetString("vault-version"),
+			version:         vaultVersion,
 			downloadTimeout: e.downloadTimeout,
+			checksum:        config.ViperConfig.GetString("vault-checksum"),
 		},
 		skipVersionVerify: config.ViperConfig.GetBool("skip-binaries-version"),
 		commandVersion:    []string{"--version"},
@@ -237,11 +374,11 @@ func NewConfigSetup(givenRootPath string) (*Environment, error) {
 	// Etcd
 	e.binaryEtcd = &exeBinary{
 		depBinary: depBinary{
-			archivePath:     path.Join(e.binABSPath, fmt.Sprintf("etcd-v%s.tar.gz", config.ViperConfig.GetString("etcd-version"))),
+			archivePath:     path.Join(e.binABSPath, fmt.Sprintf("etcd-v%s.tar.gz", etcdVersion)),
 			binaryABSPath:   path.Join(e.binABSPath, "etcd"),
-			archiveURL:      fmt.Sprintf("https://github.com/etcd-io/etcd/releases/download/v%s/etcd-v%s-linux-amd64.tar.gz", config.ViperConfig.GetString("etcd-version"), config.ViperConfig.GetString("etcd-version")),
-			version:         config.ViperConfig.GetString("etcd-version"),
+			version:         etcdVersion,
 			downloadTimeout: e.downloadTimeout,
+			checksum:        config.ViperConfig.GetString("etcd-checksum"),
 		},
 		skipVersionVerify: config.ViperConfig.GetBool("skip-binaries-version"),
 		commandVersion:    []string{"--version"},
@@ -250,11 +387,11 @@ func NewConfigSetup(givenRootPath string) (*Environment, error) {
 	// Containerd
 	e.binaryContainerd = &exeBinary{
 		depBinary: depBinary{
-			archivePath:     path.Join(e.binABSPath, fmt.Sprintf("containerd-v%s.tar.gz", config.ViperConfig.GetString("containerd-version"))),
+			archivePath:     path.Join(e.binABSPath, fmt.Sprintf("containerd-v%s.tar.gz", containerdVersion)),
 			binaryABSPath:   path.Join(e.binABSPath, "containerd"),
-			archiveURL:      fmt.Sprintf("https://github.com/containerd/containerd/releases/download/v%s/containerd-%s.linux-amd64.tar.gz", config.ViperConfig.GetString("containerd-version"), config.ViperConfig.GetString("containerd-version")),
-			version:         config.ViperConfig.GetString("containerd-version"),
+			version:         containerdVersion,
 			downloadTimeout: e.downloadTimeout,
+			checksum:        config.ViperConfig.GetString("containerd-checksum"),
 		},
 		skipVersionVerify: config.ViperConfig.GetBool("skip-binaries-version"),
 		commandVersion:    []string{"--version"},
@@ -263,11 +400,11 @@ func NewConfigSetup(givenRootPath string) (*Environment, error) {
 	// Runc
 	e.binaryRunc = &exeBinary{
 		depBinary: depBinary{
-			archivePath:     path.Join(e.binABSPath, fmt.Sprintf("runc-v%s", config.ViperConfig.GetString("runc-version"))),
+			archivePath:     path.Join(e.binABSPath, fmt.Sprintf("runc-v%s", runcVersion)),
 			binaryABSPath:   path.Join(e.binABSPath, "runc"),
-			archiveURL:      fmt.Sprintf("https://github.com/opencontainers/runc/releases/download/v%s/runc.amd64", config.ViperConfig.GetString("runc-version")),
-			version:         config.ViperConfig.GetString("runc-version"),
+			version:         runcVersion,
 			downloadTimeout: e.downloadTimeout,
+			checksum:        config.ViperConfig.GetString("runc-checksum"),
 		},
 		skipVersionVerify: config.ViperConfig.GetBool("skip-binaries-version"),
 		commandVersion:    []string{"--version"},
@@ -275,40 +412,118 @@ func NewConfigSetup(givenRootPath string) (*Environment, error) {
 
 	// CNI
 	e.binaryCNI = &depBinary{
-		archivePath:     path.Join(e.binABSPath, fmt.Sprintf("cni-v%s.tar.gz", config.ViperConfig.GetString("cni-version"))),
+		archivePath:     path.Join(e.binABSPath, fmt.Sprintf("cni-v%s.tar.gz", cniVersion)),
 		binaryABSPath:   path.Join(e.binABSPath, "bridge"),
-		archiveURL:      fmt.Sprintf("https://github.com/containernetworking/plugins/releases/download/v%s/cni-plugins-linux-amd64-v%s.tgz", config.ViperConfig.GetString("cni-version"), config.ViperConfig.GetString("cni-version")),
-		version:         config.ViperConfig.GetString("cni-version"),
+		version:         cniVersion,
 		downloadTimeout: e.downloadTimeout,
+		checksum:        config.ViperConfig.GetString("cni-checksum"),
+	}
+
+	for component, d := range map[string]*depBinary{
+		"hyperkube":  &e.binaryHyperkube.depBinary,
+		"vault":      &e.binaryVault.depBinary,
+		"etcd":       &e.binaryEtcd.depBinary,
+		"containerd": &e.binaryContainerd.depBinary,
+		"runc":       &e.binaryRunc.depBinary,
+		"cni":        e.binaryCNI,
+	} {
+		err = e.setArchiveSource(d, component)
+		if err != nil {
+			return nil, err
+		}
+		d.name = component
+		d.cache = e.downloadCache
+		d.proxy = e.proxy
+		d.requireChecksum = config.ViperConfig.GetBool("require-checksums")
+		d.downloadRetries = config.ViperConfig.GetInt("download-retries")
+		d.downloadRetryDelay = config.ViperConfig.GetDuration("download-retry-delay")
 	}
 
 	// SystemdUnits X-Section
 	e.systemdEnd2EndSection = e.createEnd2EndSection()
 
 	// Network
-	_, e.kubernetesClusterCIDR, err = net.ParseCIDR(config.ViperConfig.GetString("kubernetes-cluster-ip-range"))
+	hostNetworks, err := getHostNetworks()
 	if err != nil {
-		glog.Errorf("Unexpected error while parsing kubernetes cluster IP range: %v", err)
+		glog.Errorf("Cannot inspect the networks of the host: %v", err)
 		return nil, err
 	}
-	e.kubernetesClusterIP, err = pickInCIDR(e.kubernetesClusterCIDR.String(), 1)
+	persistedIPRanges := e.readIPRanges()
+	// the pods of a previous run keep their addresses and routes until the clean
+	hostNetworks = excludeOwnNetworks(hostNetworks, parseOwnCIDRs(
+		config.ViperConfig.GetString("kubernetes-cluster-ip-range"),
+		config.ViperConfig.GetString("pod-ip-range"),
+		persistedIPRanges.KubernetesClusterIPRange,
+		persistedIPRanges.PodIPRange,
+	))
+	kubernetesClusterIPRange, podIPRange, err := e.selectIPRanges(
+		config.ViperConfig.GetString("kubernetes-cluster-ip-range"),
+		config.ViperConfig.GetString("pod-ip-range"),
+		hostNetworks,
+	)
 	if err != nil {
-		glog.Errorf("Cannot get Kubernetes cluster IP: %v", err)
 		return nil, err
 	}
-	e.dnsClusterIP, err = pickInCIDR(e.kubernetesClusterCIDR.String(), 2)
+	e.kubernetesClusterCIDRs, err = parseCIDRs(kubernetesClusterIPRange)
 	if err != nil {
-		glog.Errorf("Cannot get DNS cluster IP: %v", err)
+		glog.Errorf("Unexpected error while parsing kubernetes cluster IP range: %v", err)
 		return nil, err
 	}
-	_, e.podCIDR, err = net.ParseCIDR(config.ViperConfig.GetString("pod-ip-range"))
+	for _, cidr := range e.kubernetesClusterCIDRs {
+		kubernetesClusterIP, err := pickInCIDR(cidr.String(), 1)
+		if err != nil {
+			glog.Errorf("Cannot get Kubernetes cluster IP: %v", err)
+			return nil, err
+		}
+		e.kubernetesClusterIPs = append(e.kubernetesClusterIPs, *kubernetesClusterIP)
+		dnsClusterIP, err := pickInCIDR(cidr.String(), 2)
+		if err != nil {
+			glog.Errorf("Cannot get DNS cluster IP: %v", err)
+			return nil, err
+		}
+		e.dnsClusterIPs = append(e.dnsClusterIPs, *dnsClusterIP)
+	}
+	e.dnsClusterIP = &e.dnsClusterIPs[0]
+	e.podCIDRs, err = parseCIDRs(podIPRange)
 	if err != nil {
 		glog.Errorf("Unexpected error while parsing pod IP range: %v", err)
 		return nil, err
 	}
-	e.podBridgeGatewayIP, err = pickInCIDR(e.podCIDR.String(), 1)
+	for _, cidr := range e.podCIDRs {
+		podBridgeGatewayIP, err := pickInCIDR(cidr.String(), 1)
+		if err != nil {
+			glog.Errorf("Cannot get pod gateway IP: %v", err)
+			return nil, err
+		}
+		e.podBridgeGatewayIPs = append(e.podBridgeGatewayIPs, *podBridgeGatewayIP)
+	}
+	err = e.validateIPFamilies()
+	if err != nil {
+		glog.Errorf("Cannot use the IP ranges: %v", err)
+		return nil, err
+	}
+
+	for _, ns := range config.ViperConfig.GetStringSlice("upstream-nameservers") {
+		if net.ParseIP(ns) == nil {
+			err = fmt.Errorf("invalid upstream nameserver %q", ns)
+			glog.Errorf("Cannot use the upstream nameservers: %v", err)
+			return nil, err
+		}
+		e.upstreamNameservers = append(e.upstreamNameservers, ns)
+	}
+	e.clusterDomain, err = normalizeDomain(config.ViperConfig.GetString("cluster-domain"))
+	if err != nil {
+		glog.Errorf("Cannot use the cluster domain: %v", err)
+		return nil, err
+	}
+	e.dnsStubDomains, err = parseDNSStubDomains(config.ViperConfig.GetStringSlice("dns-stub-domains"), e.clusterDomain)
+	if err != nil {
+		glog.Errorf("Cannot use the dns stub domains: %v", err)
+		return nil, err
+	}
+	e.dnsHosts, err = parseDNSHosts(config.ViperConfig.GetStringSlice("dns-hosts"))
 	if err != nil {
-		glog.Errorf("Cannot get pod gateway IP: %v", err)
+		glog.Errorf("Cannot use the dns hosts: %v", err)
 		return nil, err
 	}
 
@@ -342,17 +557,31 @@ func NewConfigSetup(givenRootPath string) (*Environment, error) {
 	// Template for manifests
 	e.templateMetadata = &templateMetadata{
 		// TODO conf this
-		HyperkubeImageURL:        fmt.Sprintf("gcr.io/google_containers/hyperkube:v%s", e.binaryHyperkube.version),
+		HyperkubeImageURL:        hyperkubeImageURL(e.binaryHyperkube.version, e.arch),
+		Arch:                     e.arch,
 		Hostname:                 &e.hostname,
 		RootABSPath:              &e.rootABSPath,
-		ServiceClusterIPRange:    e.kubernetesClusterCIDR.String(),
-		KubernetesClusterIP:      e.kubernetesClusterIP.String(),
+		ServiceClusterIPRange:    e.kubernetesClusterCIDRs[0].String(),
+		ServiceClusterIPRanges:   joinCIDRs(e.kubernetesClusterCIDRs),
+		PodIPRanges:              joinCIDRs(e.podCIDRs),
+		KubernetesClusterIP:      e.kubernetesClusterIPs[0].String(),
+		KubernetesClusterIPs:     joinIPs(e.kubernetesClusterIPs),
 		DNSClusterIP:             e.dnsClusterIP.String(),
+		DNSClusterIPs:            joinIPs(e.dnsClusterIPs),
+		IPv6:                     e.isIPv6(),
+		DualStack:                e.isDualStack(),
 		KubeletRootDirABSPath:    e.kubeletRootDir,
 		ContainerRuntime:         containerRuntime,
 		ContainerRuntimeEndpoint: ContainerRuntimeEndpoint,
 		CgroupDriver:             cgroupDriver,
 		NodeIP:                   &e.nodeIP, // initialized later
+		UpstreamNameservers:      &e.resolvedNameservers,
+		ClusterDomain:            e.clusterDomain,
+		DNSStubDomains:           e.dnsStubDomains,
+		DNSHosts:                 e.dnsHosts,
+		HTTPProxy:                e.proxy.httpProxy,
+		HTTPSProxy:               e.proxy.httpsProxy,
+		NoProxy:                  &e.noProxy, // initialized later
 	}
 
 	// Vault root token
@@ -377,7 +606,6 @@ func (e *Environment) setupDirectories() error {
 		path.Join(e.manifestTemplatesABSPath, defaultTemplates.ManifestAPI),
 		path.Join(e.manifestTemplatesABSPath, defaultTemplates.ManifestConfig),
 		e.etcdDataABSPath,
-		e.secretsABSPath,
 		e.networkConfigABSPath,
 		e.kubeletRootDir,
 		KubeletCRILogPath,
@@ -391,6 +619,23 @@ func (e *Environment) setupDirectories() error {
 		}
 		glog.V(4).Infof("Directory exists: %s", dir)
 	}
+	return e.setupSecretsDirectory()
+}
+
+// setupSecretsDirectory creates the directory of the private keys only accessible by its owner,
+// the mode of an existing one is restricted too
+func (e *Environment) setupSecretsDirectory() error {
+	err := os.MkdirAll(e.secretsABSPath, secretsDirPerm)
+	if err != nil {
+		glog.Errorf("Cannot create %s: %v", e.secretsABSPath, err)
+		return err
+	}
+	err = os.Chmod(e.secretsABSPath, secretsDirPerm)
+	if err != nil {
+		glog.Errorf("Cannot change the mode of %s: %v", e.secretsABSPath, err)
+		return err
+	}
+	glog.V(4).Infof("Directory exists: %s", e.secretsABSPath)
 	return nil
 }
 
@@ -400,15 +645,13 @@ func (e *Environment) Setup() error {
 	glog.V(3).Infof("Setup starting %s", e.rootABSPath)
 	for _, f := range []func() error{
 		requirements.CheckRequirements,
+		e.checkIPRanges,
 		e.setupHostname,
 		e.setupDirectories,
-		e.setupBinaryCNI,
-		e.setupBinaryEtcd,
-		e.setupBinaryContainerd,
-		e.setupBinaryRunc,
-		e.setupBinaryVault,
-		e.setupBinaryHyperkube,
+		e.setupKernel,
+		e.setupBinaries,
 		e.setupNetwork,
+		e.setupHostDNS,
 		e.setupManifests,
 		e.setupSystemd,
 		e.setupSecrets,
@@ -0,0 +1,313 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/docker/go-units"
+	"github.com/golang/glog"
+
+	"github.com/DataDog/pupernetes/pkg/cache"
+	"github.com/DataDog/pupernetes/pkg/config"
+)
+
+const (
+	archAMD64 = "amd64"
+	archARM64 = "arm64"
+)
+
+// supportedArchs are the values of --arch, named like runtime.GOARCH
+var supportedArchs = []string{archAMD64, archARM64}
+
+// archiveURLMetadata is given to the URL templates, including the user's ones like --hyperkube-url
+type archiveURLMetadata struct {
+	Version string
+	Arch    string
+}
+
+// upstreamSource is where the archive of a component and its checksum file are published
+type upstreamSource struct {
+	// sinceVersion is the first release published at these URLs, empty for the oldest ones
+	sinceVersion string
+	archiveURL   string
+	checksumURL  string
+}
+
+// upstreamSources are the URL templates of the upstream archives, the latest layout first
+var upstreamSources = map[string][]upstreamSource{
+	"hyperkube": {
+		{
+			archiveURL:  "https://dl.k8s.io/v{{.Version}}/kubernetes-server-linux-{{.Arch}}.tar.gz",
+			checksumURL: "https://dl.k8s.io/v{{.Version}}/kubernetes-server-linux-{{.Arch}}.tar.gz.sha512",
+		},
+	},
+	"vault": {
+		{
+			archiveURL:  "https://releases.hashicorp.com/vault/{{.Version}}/vault_{{.Version}}_linux_{{.Arch}}.zip",
+			checksumURL: "https://releases.hashicorp.com/vault/{{.Version}}/vault_{{.Version}}_SHA256SUMS",
+		},
+	},
+	"etcd": {
+		{
+			archiveURL:  "https://github.com/etcd-io/etcd/releases/download/v{{.Version}}/etcd-v{{.Version}}-linux-{{.Arch}}.tar.gz",
+			checksumURL: "https://github.com/etcd-io/etcd/releases/download/v{{.Version}}/SHA256SUMS",
+		},
+	},
+	"containerd": {
+		{
+			sinceVersion: "1.4.0",
+			archiveURL:   "https://github.com/containerd/containerd/releases/download/v{{.Version}}/containerd-{{.Version}}-linux-{{.Arch}}.tar.gz",
+			checksumURL:  "https://github.com/containerd/containerd/releases/download/v{{.Version}}/containerd-{{.Version}}-linux-{{.Arch}}.tar.gz.sha256sum",
+		},
+		{
+			archiveURL:  "https://github.com/containerd/containerd/releases/download/v{{.Version}}/containerd-{{.Version}}.linux-{{.Arch}}.tar.gz",
+			checksumURL: "https://github.com/containerd/containerd/releases/download/v{{.Version}}/containerd-{{.Version}}.linux-{{.Arch}}.tar.gz.sha256",
+		},
+	},
+	"runc": {
+		{
+			sinceVersion: "1.0.0",
+			archiveURL:   "https://github.com/opencontainers/runc/releases/download/v{{.Version}}/runc.{{.Arch}}",
+			checksumURL:  "https://github.com/opencontainers/runc/releases/download/v{{.Version}}/runc.sha256sum",
+		},
+		{
+			archiveURL: "https://github.com/opencontainers/runc/releases/download/v{{.Version}}/runc.{{.Arch}}",
+			// runc doesn't publish any checksum file for the amd64 default version, see pinnedChecksums
+		},
+	},
+	"cni": {
+		{
+			archiveURL:  "https://github.com/containernetworking/plugins/releases/download/v{{.Version}}/cni-plugins-linux-{{.Arch}}-v{{.Version}}.tgz",
+			checksumURL: "https://github.com/containernetworking/plugins/releases/download/v{{.Version}}/cni-plugins-linux-{{.Arch}}-v{{.Version}}.tgz.sha512",
+		},
+	},
+}
+
+// getUpstreamSource returns the latest layout published since the version,
+// the oldest one if the version isn't semver
+func getUpstreamSource(sources []upstreamSource, version string) upstreamSource {
+	v, err := semver.NewVersion(version)
+	for _, source := range sources {
+		if source.sinceVersion == "" {
+			return source
+		}
+		if err != nil {
+			continue
+		}
+		c, err := semver.NewConstraint(">=" + source.sinceVersion)
+		if err == nil && c.Check(v) {
+			return source
+		}
+	}
+	return sources[len(sources)-1]
+}
+
+// archMinVersions are the first upstream releases publishing the arch, for the components
+// not publishing it since their default version
+var archMinVersions = map[string]map[string]string{
+	archARM64: {
+		"containerd": "1.6.0",
+		"runc":       "1.0.0",
+	},
+}
+
+// checkArchiveArch returns an error if the upstream archive of the component version isn't published for the arch
+func checkArchiveArch(component, version, arch string) error {
+	minVersion, ok := archMinVersions[arch][component]
+	if !ok {
+		return nil
+	}
+	c, err := semver.NewConstraint(">=" + minVersion)
+	if err != nil {
+		return err
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid %s version %q: %v", component, version, err)
+	}
+	if !c.Check(v) {
+		return fmt.Errorf("%s %s isn't published for %s, use --%s-version %s or later, or --%s-url", component, version, arch, component, minVersion, component)
+	}
+	return nil
+}
+
+func checkArch(arch string) error {
+	for _, a := range supportedArchs {
+		if a == arch {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported arch %q, must be one of %s", arch, strings.Join(supportedArchs, ", "))
+}
+
+// upstreamURLs returns the upstream archive and checksum file URLs of the component
+func upstreamURLs(component, version, arch string) (string, string, error) {
+	sources, ok := upstreamSources[component]
+	if !ok {
+		return "", "", fmt.Errorf("unknown component %q", component)
+	}
+	source := getUpstreamSource(sources, version)
+	metadata := &archiveURLMetadata{Version: version, Arch: arch}
+	archiveURL, err := renderURLTemplate(source.archiveURL, metadata)
+	if err != nil {
+		return "", "", err
+	}
+	if source.checksumURL == "" {
+		return archiveURL, "", nil
+	}
+	checksumURL, err := renderURLTemplate(source.checksumURL, metadata)
+	if err != nil {
+		return "", "", err
+	}
+	return archiveURL, checksumURL, nil
+}
+
+// hyperkubeImageURL returns the hyperkube image of the arch,
+// the amd64 one keeps its historical name without suffix
+func hyperkubeImageURL(version, arch string) string {
+	if arch == archAMD64 {
+		return fmt.Sprintf("gcr.io/google_containers/hyperkube:v%s", version)
+	}
+	return fmt.Sprintf("gcr.io/google_containers/hyperkube-%s:v%s", arch, version)
+}
+
+// newDownloadClient returns an http client able to fetch http(s):// and file:// URLs,
+// through the given proxy or the one of the environment
+func newDownloadClient(timeout time.Duration, proxy *proxyConfig) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		t.Proxy = proxy.proxyFunc
+	}
+	t.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: t,
+	}
+}
+
+func checkSourceURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "file":
+		return nil
+	}
+	return fmt.Errorf("unsupported scheme %q in %s, must be http, https or file", u.Scheme, rawURL)
+}
+
+// mirrorURL rewrites the upstream URL to the same host/path layout inside the mirror:
+// https://dl.k8s.io/v1.16.3/kubernetes-server-linux-amd64.tar.gz becomes
+// <mirror>/dl.k8s.io/v1.16.3/kubernetes-server-linux-amd64.tar.gz
+func mirrorURL(mirror, upstreamURL string) (string, error) {
+	if mirror == "" || upstreamURL == "" {
+		return upstreamURL, nil
+	}
+	u, err := url.Parse(upstreamURL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(mirror, "/") + "/" + u.Host + u.Path, nil
+}
+
+func renderURLTemplate(urlTemplate string, metadata *archiveURLMetadata) (string, error) {
+	tpl, err := template.New("url").Option("missingkey=error").Parse(urlTemplate)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.NewBuffer(nil)
+	err = tpl.Execute(buf, metadata)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// isComponentInstalled returns false if the component isn't used with the container runtime or the pki backend
+func (e *Environment) isComponentInstalled(component string) bool {
+	switch component {
+	case "containerd", "runc":
+		return e.containerRuntimeInterface == config.CRIContainerd
+	case "vault":
+		return e.pkiBackend == config.PKIVault
+	}
+	return true
+}
+
+// setArchiveSource sets the upstream URLs of the arch then applies the --<component>-url and the --mirror settings
+func (e *Environment) setArchiveSource(d *depBinary, component string) error {
+	var err error
+
+	d.archiveURL, d.checksumURL, err = upstreamURLs(component, d.version, e.arch)
+	if err != nil {
+		glog.Errorf("Cannot build the %s archive URL: %v", component, err)
+		return err
+	}
+	d.pinnedChecksum = pinnedChecksums[pinnedChecksumKey(component, d.version, e.arch)]
+	urlTemplate := config.ViperConfig.GetString(component + "-url")
+	if urlTemplate == "" && e.isComponentInstalled(component) {
+		err = checkArchiveArch(component, d.version, e.arch)
+		if err != nil {
+			glog.Errorf("Unsupported %s archive: %v", component, err)
+			return err
+		}
+	}
+	if urlTemplate != "" {
+		d.archiveURL, err = renderURLTemplate(urlTemplate, &archiveURLMetadata{Version: d.version, Arch: e.arch})
+		if err != nil {
+			glog.Errorf("Cannot render the %s URL template %q: %v", component, urlTemplate, err)
+			return err
+		}
+		// the upstream checksum file and the pinned digest don't describe a custom archive
+		d.checksumURL = ""
+		d.pinnedChecksum = ""
+		glog.V(3).Infof("Using %s archive URL: %s", component, d.archiveURL)
+	} else if e.mirror != "" {
+		d.archiveURL, err = mirrorURL(e.mirror, d.archiveURL)
+		if err != nil {
+			glog.Errorf("Cannot use the mirror %s for %s: %v", e.mirror, component, err)
+			return err
+		}
+		d.checksumURL, err = mirrorURL(e.mirror, d.checksumURL)
+		if err != nil {
+			glog.Errorf("Cannot use the mirror %s for %s: %v", e.mirror, component, err)
+			return err
+		}
+		glog.V(3).Infof("Using mirrored %s archive URL: %s", component, d.archiveURL)
+	}
+	err = checkSourceURL(d.archiveURL)
+	if err != nil {
+		glog.Errorf("Invalid %s archive URL: %v", component, err)
+		return err
+	}
+	return nil
+}
+
+// NewDownloadCache returns the host-wide download cache configured with
+// --cache-dir and --cache-max-size, nil if disabled
+func NewDownloadCache() (*cache.Cache, error) {
+	dir := config.ViperConfig.GetString("cache-dir")
+	if dir == "" {
+		glog.V(3).Infof("Download cache disabled")
+		return nil, nil
+	}
+	maxSize, err := units.FromHumanSize(config.ViperConfig.GetString("cache-max-size"))
+	if err != nil {
+		glog.Errorf("Invalid cache max size %q: %v", config.ViperConfig.GetString("cache-max-size"), err)
+		return nil, err
+	}
+	glog.V(3).Infof("Using the download cache %s with a max size of %s", dir, units.BytesSize(float64(maxSize)))
+	return cache.NewCache(dir, maxSize), nil
+}
@@ -0,0 +1,265 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package setup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/pupernetes/pkg/config"
+)
+
+func TestMirrorURL(t *testing.T) {
+	testCases := []struct {
+		mirror   string
+		upstream string
+		expected string
+	}{
+		{
+			"",
+			"https://dl.k8s.io/v1.16.3/kubernetes-server-linux-amd64.tar.gz",
+			"https://dl.k8s.io/v1.16.3/kubernetes-server-linux-amd64.tar.gz",
+		},
+		{
+			"http://mirror.local/p8s/",
+			"https://dl.k8s.io/v1.16.3/kubernetes-server-linux-amd64.tar.gz",
+			"http://mirror.local/p8s/dl.k8s.io/v1.16.3/kubernetes-server-linux-amd64.tar.gz",
+		},
+		{
+			"file:///srv/p8s",
+			"https://releases.hashicorp.com/vault/0.9.5/vault_0.9.5_SHA256SUMS",
+			"file:///srv/p8s/releases.hashicorp.com/vault/0.9.5/vault_0.9.5_SHA256SUMS",
+		},
+		{
+			"file:///srv/p8s",
+			"",
+			"",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			u, err := mirrorURL(tc.mirror, tc.upstream)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, u)
+		})
+	}
+}
+
+func TestRenderURLTemplate(t *testing.T) {
+	u, err := renderURLTemplate("file:///srv/etcd-v{{.Version}}-linux-{{.Arch}}.tar.gz", &archiveURLMetadata{Version: "3.4.7", Arch: archARM64})
+	require.NoError(t, err)
+	assert.Equal(t, "file:///srv/etcd-v3.4.7-linux-arm64.tar.gz", u)
+
+	_, err = renderURLTemplate("file:///srv/etcd-v{{.Unknown}}.tar.gz", &archiveURLMetadata{Version: "3.4.7"})
+	assert.Error(t, err)
+}
+
+func TestUpstreamURLs(t *testing.T) {
+	testCases := []struct {
+		component string
+		version   string
+		arch      string
+		archive   string
+		checksum  string
+	}{
+		{
+			"hyperkube", "1.16.3", archAMD64,
+			"https://dl.k8s.io/v1.16.3/kubernetes-server-linux-amd64.tar.gz",
+			"https://dl.k8s.io/v1.16.3/kubernetes-server-linux-amd64.tar.gz.sha512",
+		},
+		{
+			"hyperkube", "1.16.3", archARM64,
+			"https://dl.k8s.io/v1.16.3/kubernetes-server-linux-arm64.tar.gz",
+			"https://dl.k8s.io/v1.16.3/kubernetes-server-linux-arm64.tar.gz.sha512",
+		},
+		{
+			"vault", "0.9.5", archAMD64,
+			"https://releases.hashicorp.com/vault/0.9.5/vault_0.9.5_linux_amd64.zip",
+			"https://releases.hashicorp.com/vault/0.9.5/vault_0.9.5_SHA256SUMS",
+		},
+		{
+			"vault", "0.9.5", archARM64,
+			"https://releases.hashicorp.com/vault/0.9.5/vault_0.9.5_linux_arm64.zip",
+			"https://releases.hashicorp.com/vault/0.9.5/vault_0.9.5_SHA256SUMS",
+		},
+		{
+			"etcd", "3.4.7", archAMD64,
+			"https://github.com/etcd-io/etcd/releases/download/v3.4.7/etcd-v3.4.7-linux-amd64.tar.gz",
+			"https://github.com/etcd-io/etcd/releases/download/v3.4.7/SHA256SUMS",
+		},
+		{
+			"etcd", "3.4.7", archARM64,
+			"https://github.com/etcd-io/etcd/releases/download/v3.4.7/etcd-v3.4.7-linux-arm64.tar.gz",
+			"https://github.com/etcd-io/etcd/releases/download/v3.4.7/SHA256SUMS",
+		},
+		{
+			"containerd", "1.1.3", archAMD64,
+			"https://github.com/containerd/containerd/releases/download/v1.1.3/containerd-1.1.3.linux-amd64.tar.gz",
+			"https://github.com/containerd/containerd/releases/download/v1.1.3/containerd-1.1.3.linux-amd64.tar.gz.sha256",
+		},
+		{
+			"containerd", "1.1.3", archARM64,
+			"https://github.com/containerd/containerd/releases/download/v1.1.3/containerd-1.1.3.linux-arm64.tar.gz",
+			"https://github.com/containerd/containerd/releases/download/v1.1.3/containerd-1.1.3.linux-arm64.tar.gz.sha256",
+		},
+		{
+			"containerd", "1.6.0", archARM64,
+			"https://github.com/containerd/containerd/releases/download/v1.6.0/containerd-1.6.0-linux-arm64.tar.gz",
+			"https://github.com/containerd/containerd/releases/download/v1.6.0/containerd-1.6.0-linux-arm64.tar.gz.sha256sum",
+		},
+		{
+			"runc", "1.0.0-rc5", archAMD64,
+			"https://github.com/opencontainers/runc/releases/download/v1.0.0-rc5/runc.amd64",
+			"",
+		},
+		{
+			"runc", "1.0.0-rc5", archARM64,
+			"https://github.com/opencontainers/runc/releases/download/v1.0.0-rc5/runc.arm64",
+			"",
+		},
+		{
+			"runc", "1.0.0", archARM64,
+			"https://github.com/opencontainers/runc/releases/download/v1.0.0/runc.arm64",
+			"https://github.com/opencontainers/runc/releases/download/v1.0.0/runc.sha256sum",
+		},
+		{
+			"cni", "0.8.1", archAMD64,
+			"https://github.com/containernetworking/plugins/releases/download/v0.8.1/cni-plugins-linux-amd64-v0.8.1.tgz",
+			"https://github.com/containernetworking/plugins/releases/download/v0.8.1/cni-plugins-linux-amd64-v0.8.1.tgz.sha512",
+		},
+		{
+			"cni", "0.8.1", archARM64,
+			"https://github.com/containernetworking/plugins/releases/download/v0.8.1/cni-plugins-linux-arm64-v0.8.1.tgz",
+			"https://github.com/containernetworking/plugins/releases/download/v0.8.1/cni-plugins-linux-arm64-v0.8.1.tgz.sha512",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.component+"-"+tc.version+"-"+tc.arch, func(t *testing.T) {
+			archive, checksum, err := upstreamURLs(tc.component, tc.version, tc.arch)
+			require.NoError(t, err)
+			assert.Equal(t, tc.archive, archive)
+			assert.Equal(t, tc.checksum, checksum)
+		})
+	}
+	// every layout of every component is covered
+	covered := make(map[string]int)
+	for _, tc := range testCases {
+		covered[tc.component]++
+	}
+	for component, sources := range upstreamSources {
+		assert.True(t, covered[component] >= len(sources), component)
+	}
+
+	// not semver
+	archive, _, err := upstreamURLs("containerd", "latest", archAMD64)
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/containerd/containerd/releases/download/vlatest/containerd-latest.linux-amd64.tar.gz", archive)
+
+	_, _, err = upstreamURLs("unknown", "1.0.0", archAMD64)
+	assert.Error(t, err)
+}
+
+func TestHyperkubeImageURL(t *testing.T) {
+	assert.Equal(t, "gcr.io/google_containers/hyperkube:v1.16.3", hyperkubeImageURL("1.16.3", archAMD64))
+	assert.Equal(t, "gcr.io/google_containers/hyperkube-arm64:v1.16.3", hyperkubeImageURL("1.16.3", archARM64))
+}
+
+func TestCheckArch(t *testing.T) {
+	assert.NoError(t, checkArch(archAMD64))
+	assert.NoError(t, checkArch(archARM64))
+	assert.Error(t, checkArch("s390x"))
+}
+
+func TestCheckArchiveArch(t *testing.T) {
+	assert.NoError(t, checkArchiveArch("containerd", "1.1.3", archAMD64))
+	assert.NoError(t, checkArchiveArch("etcd", "3.4.7", archARM64))
+	assert.Error(t, checkArchiveArch("containerd", "1.1.3", archARM64))
+	assert.NoError(t, checkArchiveArch("containerd", "1.6.0", archARM64))
+	assert.Error(t, checkArchiveArch("runc", "1.0.0-rc5", archARM64))
+	assert.NoError(t, checkArchiveArch("runc", "1.1.0", archARM64))
+	assert.Error(t, checkArchiveArch("runc", "latest", archARM64))
+}
+
+func TestNewConfigSetupARM64(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for key, value := range map[string]string{
+		"arch":              archARM64,
+		"cache-dir":         "",
+		"container-runtime": "docker",
+	} {
+		previous := config.ViperConfig.GetString(key)
+		config.ViperConfig.Set(key, value)
+		defer config.ViperConfig.Set(key, previous)
+	}
+
+	// the defaults of containerd and runc aren't published for arm64, docker doesn't use them
+	e, err := NewConfigSetup(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/etcd-io/etcd/releases/download/v3.4.7/etcd-v3.4.7-linux-arm64.tar.gz", e.binaryEtcd.archiveURL)
+
+	config.ViperConfig.Set("container-runtime", config.CRIContainerd)
+	e, err = NewConfigSetup(dir)
+	require.NoError(t, err)
+	assert.Equal(t, config.ArchDefaultVersions[archARM64]["containerd"], e.binaryContainerd.version)
+	assert.Equal(t, config.ArchDefaultVersions[archARM64]["runc"], e.binaryRunc.version)
+	assert.Equal(t, "https://github.com/opencontainers/runc/releases/download/v1.0.0/runc.arm64", e.binaryRunc.archiveURL)
+
+	// an explicit version isn't replaced
+	config.ViperConfig.Set("containerd-version", "1.5.0")
+	defer config.ViperConfig.Set("containerd-version", config.DefaultVersions["containerd"])
+	_, err = NewConfigSetup(dir)
+	assert.Error(t, err)
+}
+
+func TestIsComponentInstalled(t *testing.T) {
+	e := &Environment{containerRuntimeInterface: "docker", pkiBackend: config.PKINative}
+	assert.True(t, e.isComponentInstalled("etcd"))
+	assert.False(t, e.isComponentInstalled("containerd"))
+	assert.False(t, e.isComponentInstalled("runc"))
+	assert.False(t, e.isComponentInstalled("vault"))
+
+	e = &Environment{containerRuntimeInterface: config.CRIContainerd, pkiBackend: config.PKIVault}
+	assert.True(t, e.isComponentInstalled("containerd"))
+	assert.True(t, e.isComponentInstalled("runc"))
+	assert.True(t, e.isComponentInstalled("vault"))
+}
+
+func TestCheckSourceURL(t *testing.T) {
+	assert.NoError(t, checkSourceURL("https://dl.k8s.io/v1.16.3/kubernetes-server-linux-amd64.tar.gz"))
+	assert.NoError(t, checkSourceURL("file:///srv/p8s/runc.amd64"))
+	assert.Error(t, checkSourceURL("ftp://mirror.local/runc.amd64"))
+}
+
+func TestDownloadToFileFromFileURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p8s-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	source := path.Join(dir, "source.tar.gz")
+	require.NoError(t, ioutil.WriteFile(source, []byte("hello"), 0400))
+
+	d := &depBinary{
+		archiveURL:      "file://" + source,
+		archivePath:     path.Join(dir, "archive.tar.gz"),
+		downloadTimeout: time.Second,
+	}
+	require.NoError(t, d.downloadToFile())
+	b, err := ioutil.ReadFile(d.archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	d.archiveURL = "file://" + path.Join(dir, "missing.tar.gz")
+	assert.Error(t, d.downloadToFile())
+}
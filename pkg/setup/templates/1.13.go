@@ -10,6 +10,15 @@ Description=Hyperkube kubelet for pupernetes
 After=network.target
 
 [Service]
+{{- if or .HTTPProxy .HTTPSProxy }}
+{{- if .HTTPProxy }}
+Environment=HTTP_PROXY={{ .HTTPProxy }}
+{{- end }}
+{{- if .HTTPSProxy }}
+Environment=HTTPS_PROXY={{ .HTTPSProxy }}
+{{- end }}
+Environment=NO_PROXY={{ .NoProxy }}
+{{- end }}
 ExecStart={{.RootABSPath}}/bin/hyperkube kubelet \
 	--v=4 \
 	--allow-privileged \
@@ -22,11 +31,11 @@ ExecStart={{.RootABSPath}}/bin/hyperkube kubelet \
 	--kubeconfig={{.RootABSPath}}/manifest-config/kubeconfig-insecure.yaml \
 	--resolv-conf={{.RootABSPath}}/net.d/resolv-conf \
 	--cluster-dns={{ .DNSClusterIP }} \
-	--cluster-domain=cluster.local \
+	--cluster-domain={{ .ClusterDomain }} \
 	--cert-dir={{.RootABSPath}}/secrets \
-	--client-ca-file={{.RootABSPath}}/secrets/kubernetes.issuing_ca \
-	--tls-cert-file={{.RootABSPath}}/secrets/kubernetes.certificate \
-	--tls-private-key-file={{.RootABSPath}}/secrets/kubernetes.private_key \
+	--client-ca-file={{.RootABSPath}}/secrets/pupernetes.issuing_ca \
+	--tls-cert-file={{.RootABSPath}}/secrets/kubelet.certificate \
+	--tls-private-key-file={{.RootABSPath}}/secrets/kubelet.private_key \
 	--read-only-port=0 \
 	--anonymous-auth=false \
 	--authentication-token-webhook \
@@ -56,6 +65,15 @@ Description=containerd
 After=network.target
 
 [Service]
+{{- if or .HTTPProxy .HTTPSProxy }}
+{{- if .HTTPProxy }}
+Environment=HTTP_PROXY={{ .HTTPProxy }}
+{{- end }}
+{{- if .HTTPSProxy }}
+Environment=HTTPS_PROXY={{ .HTTPSProxy }}
+{{- end }}
+Environment=NO_PROXY={{ .NoProxy }}
+{{- end }}
 KillMode=process
 Environment=PATH=/bin:/sbin:/usr/bin:/usr/sbin/:/usr/local/bin:/usr/local/sbin:{{.RootABSPath}}/bin
 ExecStart={{.RootABSPath}}/bin/containerd \
@@ -78,6 +96,9 @@ ExecStart={{.RootABSPath}}/bin/hyperkube apiserver \
 	--insecure-port=8080 \
 	--allow-privileged=true \
 	--service-cluster-ip-range={{ .ServiceClusterIPRange }} \
+{{- if .IPv6 }}
+	--advertise-address={{ .NodeIP }} \
+{{- end }}
 	--enable-admission-plugins=PodPreset,NodeRestriction,EventRateLimit,PodTolerationRestriction \
 	--kubelet-preferred-address-types=InternalIP,LegacyHostIP,ExternalDNS,InternalDNS,Hostname \
 	--authorization-mode=RBAC \
@@ -85,22 +106,22 @@ ExecStart={{.RootABSPath}}/bin/hyperkube apiserver \
 	--anonymous-auth=false \
 	--service-account-lookup=true \
 	--runtime-config=api/all=true \
-	--client-ca-file={{.RootABSPath}}/secrets/kubernetes.issuing_ca \
-	--tls-cert-file={{.RootABSPath}}/secrets/kubernetes.certificate \
-	--tls-private-key-file={{.RootABSPath}}/secrets/kubernetes.private_key \
+	--client-ca-file={{.RootABSPath}}/secrets/pupernetes.issuing_ca \
+	--tls-cert-file={{.RootABSPath}}/secrets/apiserver.certificate \
+	--tls-private-key-file={{.RootABSPath}}/secrets/apiserver.private_key \
 	--service-account-key-file={{.RootABSPath}}/secrets/service-accounts.rsa \
-	--kubelet-client-certificate={{.RootABSPath}}/secrets/kubernetes.certificate \
-	--kubelet-client-key={{.RootABSPath}}/secrets/kubernetes.private_key \
+	--kubelet-client-certificate={{.RootABSPath}}/secrets/apiserver-kubelet-client.certificate \
+	--kubelet-client-key={{.RootABSPath}}/secrets/apiserver-kubelet-client.private_key \
 	--kubelet-https \
-	--requestheader-client-ca-file={{.RootABSPath}}/secrets/kubernetes.issuing_ca \
-	--requestheader-allowed-names=aggregator,p8s \
+	--requestheader-client-ca-file={{.RootABSPath}}/secrets/pupernetes.issuing_ca \
+	--requestheader-allowed-names=front-proxy-client \
 	--requestheader-extra-headers-prefix=X-Remote-Extra- \
 	--requestheader-group-headers=X-Remote-Group \
 	--requestheader-username-headers=X-Remote-User \
-	--proxy-client-cert-file={{.RootABSPath}}/secrets/kubernetes.certificate \
-	--proxy-client-key-file={{.RootABSPath}}/secrets/kubernetes.private_key \
+	--proxy-client-cert-file={{.RootABSPath}}/secrets/front-proxy-client.certificate \
+	--proxy-client-key-file={{.RootABSPath}}/secrets/front-proxy-client.private_key \
 	--kubelet-https \
-	--kubelet-certificate-authority={{.RootABSPath}}/secrets/kubernetes.issuing_ca \
+	--kubelet-certificate-authority={{.RootABSPath}}/secrets/pupernetes.issuing_ca \
 	--target-ram-mb=0 \
 	--watch-cache=false \
 	--default-watch-cache-size=0 \
@@ -123,6 +144,8 @@ Description=etcd for pupernetes
 After=network.target
 
 [Service]
+# etcd refuses to start on arm64 without this opt-in, it is ignored on amd64
+Environment=ETCD_UNSUPPORTED_ARCH={{.Arch}}
 ExecStart={{.RootABSPath}}/bin/etcd \
 	--name=etcdv3.1.11 \
 	--data-dir={{.RootABSPath}}/etcd-data \
@@ -148,7 +171,7 @@ kind: Config
 clusters:
   - cluster:
       server: https://127.0.0.1:6443
-      certificate-authority: "{{.RootABSPath}}/secrets/kubernetes.issuing_ca"
+      certificate-authority: "{{.RootABSPath}}/secrets/pupernetes.issuing_ca"
     name: p8s
 contexts:
   - context:
@@ -159,8 +182,8 @@ current-context: p8s
 users:
   - name: p8s
     username: p8s
-    client-certificate: "{{.RootABSPath}}/secrets/kubernetes.certificate"
-    client-key: "{{.RootABSPath}}/secrets/kubernetes.private_key"
+    client-certificate: "{{.RootABSPath}}/secrets/admin.certificate"
+    client-key: "{{.RootABSPath}}/secrets/admin.private_key"
 `),
 		},
 		{
@@ -328,7 +351,8 @@ spec:
     command:
     - /hyperkube
     - controller-manager
-    - --master=http://127.0.0.1:8080
+    - --kubeconfig=/etc/secrets/kube-controller-manager.kubeconfig
+    - --use-service-account-credentials=true
     - --leader-elect=true
     - --leader-elect-lease-duration=150s
     - --leader-elect-renew-deadline=100s
@@ -397,7 +421,7 @@ spec:
         command:
         - /hyperkube
         - scheduler
-        - --master=http://127.0.0.1:8080
+        - --kubeconfig=/etc/secrets/kube-scheduler.kubeconfig
         - --leader-elect=true
         - --leader-elect-lease-duration=150s
         - --leader-elect-renew-deadline=100s
@@ -433,11 +457,11 @@ data:
   config.yaml: |
     apiVersion: kubeproxy.config.k8s.io/v1alpha1
     kind: KubeProxyConfiguration
-    bindAddress: 0.0.0.0
+    bindAddress: {{ if .IPv6 }}"::"{{ else }}0.0.0.0{{ end }}
     clientConnection:
       kubeconfig: /var/lib/kubernetes/kubeconfig.yaml
     clusterCIDR: "{{ .ServiceClusterIPRange }}"
-    healthzBindAddress: 0.0.0.0:10256
+    healthzBindAddress: {{ if .IPv6 }}"[::]:10256"{{ else }}0.0.0.0:10256{{ end }}
     hostnameOverride: "{{ .Hostname }}"
     iptables:
       masqueradeAll: true
@@ -601,13 +625,28 @@ data:
         errors
         log
         health
-        kubernetes cluster.local {{ .ServiceClusterIPRange }} {
+{{- if .DNSHosts }}
+        hosts {
+{{- range .DNSHosts }}
+          {{ .IP }} {{ .Name }}
+{{- end }}
+          fallthrough
+        }
+{{- end }}
+        kubernetes {{ .ClusterDomain }} {{ .ServiceClusterIPRange }} {
           pods insecure
         }
         prometheus :9153
-        proxy . /etc/resolv.conf 8.8.8.8 8.8.4.4
+        proxy . {{ .UpstreamNameservers }}
+        cache 30
+    }
+{{- range .DNSStubDomains }}
+    {{ .Domain }}:53 {
+        errors
+        proxy . {{ .Forwarders }}
         cache 30
     }
+{{- end }}
 ---
 apiVersion: extensions/v1beta1
 kind: Deployment
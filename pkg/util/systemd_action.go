@@ -110,6 +110,20 @@ func StartUnit(d *dbus.Conn, unitName string) error {
 	return sd.executeSystemdAction()
 }
 
+// RestartUnit call dbus to restart the given unit name
+func RestartUnit(d *dbus.Conn, unitName string) error {
+	sd := &sytemdAction{
+		unitName:      unitName,
+		dbusConn:      d,
+		systemdAction: d.RestartUnit,
+		// legacy
+		expectedSubState: []string{"running"},
+		getUnitStates:    MustGetUnitStates,
+	}
+	glog.V(2).Infof("Restarting %s ...", unitName)
+	return sd.executeSystemdAction()
+}
+
 // StopUnit call dbus to stop the given unit name
 func StopUnit(d *dbus.Conn, unitName string) error {
 	sd := &sytemdAction{
@@ -2,6 +2,7 @@ package wait
 
 import (
 	"fmt"
+	"github.com/DataDog/pupernetes/pkg/api"
 	"github.com/DataDog/pupernetes/pkg/logging"
 	"github.com/DataDog/pupernetes/pkg/util"
 	"github.com/coreos/go-systemd/dbus"
@@ -10,19 +11,28 @@ import (
 	"time"
 )
 
+const (
+	// streamMinBackoff and streamMaxBackoff bound the delay before resuming an event stream ended cleanly
+	streamMinBackoff = 500 * time.Millisecond
+	streamMaxBackoff = 5 * time.Second
+)
+
 // Wait is used to poll a given systemd unit until its ready
 type Wait struct {
 	systemdUnitName       string
+	apiClient             *api.Client
 	timeout, loggingSince time.Duration
 }
 
-// NewWaiter instantiate a Wait with the given parameter
-func NewWaiter(systemdUnitName string, timeout, loggingSince time.Duration) *Wait {
+// NewWaiter instantiate a Wait with the given parameter,
+// if apiClient is set the events of the pupernetes API are consumed instead of polling the systemd unit
+func NewWaiter(systemdUnitName string, apiClient *api.Client, timeout, loggingSince time.Duration) *Wait {
 	if !strings.HasSuffix(systemdUnitName, ".service") {
 		systemdUnitName = systemdUnitName + ".service"
 	}
 	return &Wait{
 		systemdUnitName: systemdUnitName,
+		apiClient:       apiClient,
 		timeout:         timeout,
 		loggingSince:    loggingSince,
 	}
@@ -72,6 +82,10 @@ func (w *Wait) Wait() error {
 	timeout := time.NewTimer(w.timeout)
 	defer timeout.Stop()
 
+	if w.apiClient != nil {
+		return w.waitEvents(conn, timeout.C)
+	}
+
 	tick := time.NewTicker(time.Second * 3)
 	defer tick.Stop()
 	for {
@@ -99,3 +113,97 @@ func (w *Wait) Wait() error {
 		}
 	}
 }
+
+// handleEvent logs the event and returns true when the wait is over
+func (w *Wait) handleEvent(event *api.Event, result chan error) bool {
+	switch event.Type {
+	case api.EventReady:
+		glog.Infof("Pupernetes is ready")
+		result <- nil
+		return true
+
+	case api.EventStopped:
+		err := fmt.Errorf("pupernetes stopped before being ready")
+		if event.Details["error"] != "" {
+			err = fmt.Errorf("pupernetes stopped before being ready: %s", event.Details["error"])
+		}
+		glog.Errorf("Unexpected stop: %v", err)
+		result <- err
+		return true
+
+	case api.EventProbeFailed:
+		glog.Warningf("Event %s %v", event.Type, event.Details)
+
+	default:
+		glog.Infof("Event %s %v", event.Type, event.Details)
+	}
+	return false
+}
+
+// waitEvents consumes the events of the pupernetes API until the ready or the stopped event,
+// the systemd unit is polled when the API isn't reachable
+func (w *Wait) waitEvents(conn *dbus.Conn, timeout <-chan time.Time) error {
+	result := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	go func() {
+		since := 0
+		backoff := streamMinBackoff
+		for {
+			previous := since
+			var done bool
+			var err error
+			since, done, err = w.apiClient.StreamEvents(since, func(event *api.Event) bool {
+				return w.handleEvent(event, result)
+			})
+			if done {
+				return
+			}
+			if err == nil {
+				// the stream ended cleanly, like after its maximum duration or on the close of the API
+				if since != previous {
+					backoff = streamMinBackoff
+				}
+				glog.V(4).Infof("Event stream ended, resuming in %s", backoff.String())
+				select {
+				case <-quit:
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > streamMaxBackoff {
+					backoff = streamMaxBackoff
+				}
+				continue
+			}
+			state, err := getSystemdUnitState(conn, w.systemdUnitName)
+			if err != nil {
+				result <- err
+				return
+			}
+			if state == "dead" {
+				err = fmt.Errorf("systemd job %s is %s", w.systemdUnitName, state)
+				glog.Errorf("Unexpected state of systemd job: %v", err)
+				result <- err
+				return
+			}
+			glog.V(4).Infof("Systemd unit %s is %s, waiting for the API", w.systemdUnitName, state)
+			select {
+			case <-quit:
+				return
+			case <-time.After(time.Second * 3):
+			}
+		}
+	}()
+
+	select {
+	case err := <-result:
+		return err
+
+	case <-timeout:
+		err := fmt.Errorf("timeout while waiting for the events of %s", w.systemdUnitName)
+		glog.Errorf("Unexpected timeout: %v", err)
+		return err
+	}
+}
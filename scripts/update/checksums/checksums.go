@@ -0,0 +1,104 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/DataDog/pupernetes/pkg/setup"
+)
+
+// generatedMarker isn't in the header to not mark this file as generated
+const generatedMarker = "// Code generated by scripts/update/checksums/checksums.go; DO NOT EDIT."
+
+const header = `// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2018 Datadog, Inc.
+
+%s
+
+package setup
+
+// pinnedChecksums are the digests of the upstream archives of the default versions
+var pinnedChecksums = map[string]string{
+`
+
+func init() {
+	flag.CommandLine.Parse([]string{})
+	flag.Lookup("alsologtostderr").Value.Set("true")
+	flag.Lookup("v").Value.Set("2")
+}
+
+// archiveSHA256 downloads the archive and returns its digest
+func archiveSHA256(client *http.Client, archiveURL string) (string, error) {
+	resp, err := client.Get(archiveURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, archiveURL)
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func main() {
+	cwd, err := os.Getwd()
+	if err != nil {
+		glog.Exitln(err)
+	}
+	urls, err := setup.DefaultArchiveURLs()
+	if err != nil {
+		glog.Exitln(err)
+	}
+	var keys []string
+	for key := range urls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	buf := bytes.NewBufferString(fmt.Sprintf(header, generatedMarker))
+	for _, key := range keys {
+		glog.Infof("Downloading %s ...", urls[key])
+		sum, err := archiveSHA256(client, urls[key])
+		if err != nil {
+			glog.Exitf("Cannot pin %s: %v", key, err)
+		}
+		fmt.Fprintf(buf, "%q: %q,\n", key, "sha256:"+sum)
+	}
+	buf.WriteString("}\n")
+	b, err := format.Source(buf.Bytes())
+	if err != nil {
+		glog.Exitln(err)
+	}
+	pinnedFile := path.Join(cwd, "pkg", "setup", "checksum_pinned.go")
+	err = ioutil.WriteFile(pinnedFile, b, 0644)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	glog.Infof("Pinned %d digests in %s", len(keys), pinnedFile)
+}